@@ -0,0 +1,117 @@
+package config
+
+import (
+	"fmt"
+	"github.com/mrproliu/go-agent-instrumentation/agent/reporter"
+	// the reporters could be selected by the config
+	_ "github.com/mrproliu/go-agent-instrumentation/agent/reporter/otlp"
+	_ "github.com/mrproliu/go-agent-instrumentation/agent/reporter/skywalking"
+	"github.com/mrproliu/go-agent-instrumentation/framework/core"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+)
+
+// FileEnv is the environment variable of the config file path, the file is shared with the toolexec
+const FileEnv = "SW_AGENT_CONFIG"
+
+// Config is the runtime config of the agent, the keys in the YAML file are the snake case of the field names,
+// and could be overridden by the environment variables, such as "SW_AGENT_REPORTER_GRPC_BACKEND_ADDRESS".
+// The "build" section of the file is read by the toolexec
+type Config struct {
+	Service     string // the service name, default the name of the executable
+	Instance    string // the service instance name, default "<pid>@<hostname>"
+	Propagators string // the propagators of the trace context, such as "sw8,tracecontext,baggage", default "sw8"
+	Sampling    core.SamplingOptions
+	Reporter    reporter.Config
+	Plugins     map[string]map[string]interface{} // the config sections of the plugins, read by core.DeclarePluginConfig
+	// the interceptors skipped at runtime, "<plugin>/<interceptor>" or the plugin name, such as "gin/ServerHTTPInterceptor"
+	DisabledInterceptors []string
+	Reload               ReloadOptions
+
+	file string // the file loaded from, watched by the Watcher
+}
+
+// Default returns the default config, reports to the SkyWalking OAP on the localhost and samples all traces
+func Default() *Config {
+	return &Config{
+		Propagators: core.PropagatorSW8,
+		Reporter:    reporter.Config{Type: "grpc"},
+	}
+}
+
+// Load reads the config from the YAML file and the environment variables based on the defaults.
+// The file path is read from the SW_AGENT_CONFIG if empty, only the environment variables are used if both empty
+func Load(path string) (*Config, error) {
+	if path == "" {
+		path = os.Getenv(FileEnv)
+	}
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		cfg, err := parse(content)
+		if err != nil {
+			return nil, fmt.Errorf("parse config file %s failure: %v", path, err)
+		}
+		cfg.file = path
+		return cfg, nil
+	}
+	return parse(nil)
+}
+
+// parse decodes the YAML content and the environment variables based on the defaults
+func parse(content []byte) (*Config, error) {
+	values := make(map[string]interface{})
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return nil, err
+	}
+	// the build section is decoded by the toolexec
+	delete(values, "build")
+	cfg := Default()
+	if err := core.DecodeConfig(values, "SW_AGENT", cfg); err != nil {
+		return nil, err
+	}
+	if err := reporter.Validate(&cfg.Reporter); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Start applies the config to the enhanced packages, and starts the reporter.
+// Shutdown the returned pipeline when the application exits, so the queued segments are flushed
+func Start(cfg *Config) (*reporter.Pipeline, error) {
+	if cfg.Service == "" {
+		cfg.Service = filepath.Base(os.Args[0])
+	}
+	if cfg.Instance == "" {
+		hostname, _ := os.Hostname()
+		cfg.Instance = fmt.Sprintf("%d@%s", os.Getpid(), hostname)
+	}
+	if err := applyDynamic(cfg); err != nil {
+		return nil, err
+	}
+	core.SetServiceInstance(cfg.Service, cfg.Instance)
+	cfg.Reporter.Service, cfg.Reporter.Instance = cfg.Service, cfg.Instance
+	return reporter.Start(&cfg.Reporter)
+}
+
+// applyDynamic applies the options which could be changed at runtime,
+// the propagators, sampling, plugin sections and disabled interceptors.
+// All of them are validated before applying, so the invalid config changes nothing
+func applyDynamic(cfg *Config) error {
+	propagator, err := core.NewPropagator(cfg.Propagators)
+	if err != nil {
+		return err
+	}
+	applyPlugins, err := core.PreparePluginConfig(cfg.Plugins)
+	if err != nil {
+		return err
+	}
+	applyPlugins()
+	core.SetPropagator(propagator)
+	core.SetSampler(core.NewSampler(cfg.Sampling))
+	core.SetDisabledInterceptors(cfg.DisabledInterceptors)
+	return nil
+}
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"github.com/mrproliu/go-agent-instrumentation/agent/reporter"
+	"github.com/mrproliu/go-agent-instrumentation/agent/reporter/skywalking"
+	"github.com/mrproliu/go-agent-instrumentation/framework/core"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigFile writes the YAML content into the temp directory, returns the path
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "agent.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const testConfigFile = `
+build:
+  plugins: [gin]
+  strict: false
+service: users
+sampling:
+  default: {rate: 100}
+  routes: {"GET:/health": {rate: -1}}
+reporter:
+  grpc: {backend_address: "oap:11800", timeout: 3s}
+plugins:
+  gin:
+    collect_request_headers: [x-user-id]
+disabled_interceptors: [gin/ServerHTTPInterceptor]
+reload: {interval: 30s}
+`
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfigFile(t, testConfigFile)
+	t.Setenv(FileEnv, "")
+	t.Setenv("SW_AGENT_REPORTER_GRPC_BACKEND_ADDRESS", "env-oap:11800")
+	t.Setenv("SW_AGENT_SAMPLING_DEFAULT_PROBABILITY", "0.5")
+	t.Setenv("SW_AGENT_DISABLED_INTERCEPTORS", "gin, grpc/ClientInterceptor")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Service != "users" || cfg.file != path {
+		t.Errorf("the service from the file: %s, file: %s", cfg.Service, cfg.file)
+	}
+	// the defaults not in the file
+	if cfg.Propagators != core.PropagatorSW8 || cfg.Reporter.Type != "grpc" || cfg.Reload.Timeout != 0 {
+		t.Errorf("the defaults are changed, propagators: %s, reporter: %s, reload timeout: %v", cfg.Propagators, cfg.Reporter.Type, cfg.Reload.Timeout)
+	}
+	// the section of the grpc reporter is decoded when creating the reporter
+	var grpc skywalking.Options
+	if err := reporter.DecodeOptions(&cfg.Reporter, "grpc", &grpc); err != nil {
+		t.Fatal(err)
+	}
+	if grpc.BackendAddress != "env-oap:11800" || grpc.Timeout != 3*time.Second {
+		t.Errorf("the grpc reporter: %+v", grpc)
+	}
+	expectedSampling := core.SamplingOptions{
+		Default: core.SamplingRule{Rate: 100, Probability: 0.5},
+		Routes:  map[string]core.SamplingRule{"GET:/health": {Rate: -1}},
+	}
+	if !reflect.DeepEqual(cfg.Sampling, expectedSampling) {
+		t.Errorf("the sampling: %+v, expected: %+v", cfg.Sampling, expectedSampling)
+	}
+	if !reflect.DeepEqual(cfg.DisabledInterceptors, []string{"gin", "grpc/ClientInterceptor"}) {
+		t.Errorf("the disabled interceptors: %v", cfg.DisabledInterceptors)
+	}
+	expectedPlugins := map[string]map[string]interface{}{"gin": {"collect_request_headers": []interface{}{"x-user-id"}}}
+	if !reflect.DeepEqual(cfg.Plugins, expectedPlugins) || cfg.Reload.Interval != 30*time.Second {
+		t.Errorf("the plugins: %v, reload: %+v", cfg.Plugins, cfg.Reload)
+	}
+}
+
+func TestLoadFromEnvironment(t *testing.T) {
+	path := writeConfigFile(t, "service: from-file\n")
+	t.Setenv(FileEnv, path)
+	t.Setenv("SW_AGENT_SERVICE", "")
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Service != "from-file" || cfg.file != path {
+		t.Errorf("the config from %s: %s, file: %s", FileEnv, cfg.Service, cfg.file)
+	}
+
+	// only the environment variables without the file
+	t.Setenv(FileEnv, "")
+	t.Setenv("SW_AGENT_SERVICE", "from-env")
+	t.Setenv("SW_AGENT_REPORTER_TYPE", "log")
+	if cfg, err = Load(""); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Service != "from-env" || cfg.Reporter.Type != "log" || cfg.Propagators != core.PropagatorSW8 || cfg.file != "" {
+		t.Errorf("the config from the environment variables: %+v", cfg)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Setenv(FileEnv, "")
+	tests := map[string]string{
+		"servce: users\n": "unknown config servce",
+		"reporter:\n  grpc: {backend: oap:11800}\n":         "unknown config reporter.grpc.backend",
+		"reporter:\n  grcp: {backend_address: oap:11800}\n": "unknown config reporter.grcp",
+		"reload: {interval: 30}\n":                          "config reload.interval: the duration should be",
+		"sampling:\n  routes: {\"/\": {rate: all}}\n":       "config sampling.routes./.rate: strconv.ParseInt",
+		"reload: {logger: stdout}\n":                        "unknown config reload.logger",
+		"service: [users\n":                                 "yaml:",
+		"build: {plugins: [unknown]}\nservice: users\n":     "",
+		"build: {unknown_option: true}\nservice: users\n":   "",
+		"plugins:\n  gin: {unknown_option: true}\n":         "",
+		"disabled_interceptors: gin/ServerHTTPInterceptor":  "",
+	}
+	for content, expected := range tests {
+		_, err := Load(writeConfigFile(t, content))
+		if expected == "" {
+			// the build section is checked by the toolexec, the plugin sections when applying them
+			if err != nil {
+				t.Errorf("%q: %v", content, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%q: the error %v, expected: %s", content, err, expected)
+		}
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("the missing file is loaded")
+	}
+}
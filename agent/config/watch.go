@@ -0,0 +1,165 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// ReloadOptions watches the config changes, the propagators, sampling, plugin sections and disabled interceptors
+// are applied without restarting, the others(such as the service and reporter) take effect after restarting
+type ReloadOptions struct {
+	Interval time.Duration // the interval of checking the changes, the watcher is not started if 0
+	URL      string        // reads the config from the URL instead of the file, such as "http://config-service/agent.yaml"
+	Timeout  time.Duration // the timeout of requesting the URL, default 5s
+
+	Logger func(format string, args ...interface{}) `config:"-"` // the log of the reload failures, default log.Printf
+}
+
+// Watcher checks the config file or URL periodically, and applies the changed config
+type Watcher struct {
+	opts   ReloadOptions
+	source func() ([]byte, error)
+	client *http.Client
+
+	lock      sync.Mutex
+	last      []byte
+	current   *Config
+	listeners []func(*Config)
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// Watch starts watching the changes of the config by its Reload options, from the URL if set, otherwise the loaded file.
+// Stop the watcher when the application exits
+func Watch(cfg *Config) (*Watcher, error) {
+	opts := cfg.Reload
+	if opts.Interval <= 0 {
+		return nil, fmt.Errorf("the reload interval should be positive: %v", opts.Interval)
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.Logger == nil {
+		opts.Logger = log.Printf
+	}
+	w := &Watcher{
+		opts:    opts,
+		client:  &http.Client{Timeout: opts.Timeout},
+		current: cfg,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	switch {
+	case opts.URL != "":
+		w.source = w.readURL
+	case cfg.file != "":
+		w.source = func() ([]byte, error) { return os.ReadFile(cfg.file) }
+	default:
+		return nil, fmt.Errorf("no config file or reload URL to watch")
+	}
+	// the config loaded from the file is the baseline, the URL is applied in the first check
+	if opts.URL == "" {
+		last, err := w.source()
+		if err != nil {
+			return nil, err
+		}
+		w.last = last
+	}
+	go w.run()
+	return w, nil
+}
+
+// OnChange adds the listener invoked after the changed config applied
+func (w *Watcher) OnChange(listener func(*Config)) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.listeners = append(w.listeners[:len(w.listeners):len(w.listeners)], listener)
+}
+
+// Current returns the config applied last time
+func (w *Watcher) Current() *Config {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.current
+}
+
+// Check reads the config immediately, and applies it when the content changed.
+// The invalid config is not applied, and it's checked again in the next time
+func (w *Watcher) Check() error {
+	content, err := w.source()
+	if err != nil {
+		return err
+	}
+	cfg, err := w.apply(content)
+	if err != nil || cfg == nil {
+		return err
+	}
+	w.lock.Lock()
+	listeners := w.listeners
+	w.lock.Unlock()
+	for _, listener := range listeners {
+		listener(cfg)
+	}
+	return nil
+}
+
+// apply parses and applies the content, returns nil config if not changed
+func (w *Watcher) apply(content []byte) (*Config, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.last != nil && bytes.Equal(content, w.last) {
+		return nil, nil
+	}
+	cfg, err := parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("parse the reloaded config failure: %v", err)
+	}
+	if err := applyDynamic(cfg); err != nil {
+		return nil, err
+	}
+	// the static options are kept until restarting
+	cfg.Service, cfg.Instance, cfg.Reporter, cfg.Reload, cfg.file =
+		w.current.Service, w.current.Instance, w.current.Reporter, w.current.Reload, w.current.file
+	w.last, w.current = content, cfg
+	return cfg, nil
+}
+
+// Stop stops watching, the applied config is kept
+func (w *Watcher) Stop() {
+	close(w.stop)
+	<-w.done
+}
+
+func (w *Watcher) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if err := w.Check(); err != nil {
+				w.opts.Logger("skywalking agent: reload config failure: %v", err)
+			}
+		}
+	}
+}
+
+func (w *Watcher) readURL() ([]byte, error) {
+	resp, err := w.client.Get(w.opts.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("request %s failure: %s", w.opts.URL, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"fmt"
+	"github.com/mrproliu/go-agent-instrumentation/framework/core"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type watchPluginConfig struct {
+	Enabled bool
+	Limit   int
+}
+
+func TestWatcherReload(t *testing.T) {
+	t.Setenv(FileEnv, "")
+	pluginA := core.DeclarePluginConfig("watch-a", &watchPluginConfig{})
+	pluginB := core.DeclarePluginConfig("watch-b", &watchPluginConfig{})
+	defer func() {
+		_ = applyDynamic(Default())
+	}()
+
+	path := writeConfigFile(t, `
+service: users
+propagators: sw8
+disabled_interceptors: [gin]
+reload: {interval: 1h}
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := applyDynamic(cfg); err != nil {
+		t.Fatal(err)
+	}
+	w, err := Watch(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	changes := make([]*Config, 0)
+	w.OnChange(func(changed *Config) {
+		changes = append(changes, changed)
+	})
+
+	// the content is not changed
+	if err := w.Check(); err != nil || len(changes) != 0 {
+		t.Fatalf("the unchanged config is applied: %v, changes: %d", err, len(changes))
+	}
+
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the applied options: propagator fields, disabled interceptors, plugin configs and the sampling of the health check
+	current := func() string {
+		return fmt.Sprintf("%v %v %+v %+v %t", core.GetPropagator().Fields(), core.DisabledInterceptors(), pluginA.Get(), pluginB.Get(),
+			core.GetSampler().Sample("GET:/health", nil, nil))
+	}
+	write(`
+service: orders
+propagators: tracecontext
+sampling:
+  routes: {"GET:/health": {rate: -1}}
+plugins:
+  watch-a: {enabled: true, limit: 10}
+  watch-b: {limit: 20}
+reload: {interval: 1h}
+`)
+	if err := w.Check(); err != nil {
+		t.Fatal(err)
+	}
+	applied := current()
+	expected := "[traceparent tracestate] [] &{Enabled:true Limit:10} &{Enabled:false Limit:20} false"
+	if applied != expected {
+		t.Errorf("the reloaded config: %v, expected: %v", applied, expected)
+	}
+	// the static options are kept until restarting
+	if len(changes) != 1 || changes[0] != w.Current() || w.Current().Service != "users" || w.Current().Propagators != "tracecontext" {
+		t.Errorf("the current config: %+v, changes: %d", w.Current(), len(changes))
+	}
+
+	// any invalid option changes nothing, the valid options in the same content are not applied either
+	invalid := map[string]string{
+		"the propagator": `
+propagators: jaeger
+disabled_interceptors: [gin]
+plugins:
+  watch-a: {limit: 11}
+`,
+		"the plugin section": `
+propagators: sw8
+sampling:
+  routes: {"GET:/health": {rate: 0}}
+plugins:
+  watch-a: {limit: 11}
+  watch-b: {limit: twenty}
+`,
+		"the unknown key": `
+propagators: sw8
+plugins:
+  watch-a: {limit: 11, unknown: 1}
+`,
+	}
+	for name, content := range invalid {
+		write(content)
+		if err := w.Check(); err == nil {
+			t.Errorf("%s: the invalid config is applied", name)
+		}
+		if actual := current(); actual != expected {
+			t.Errorf("%s: the config is changed by the invalid config: %v, expected: %v", name, actual, expected)
+		}
+		if len(changes) != 1 || w.Current().Propagators != "tracecontext" {
+			t.Errorf("%s: the current config is changed: %+v, changes: %d", name, w.Current(), len(changes))
+		}
+	}
+
+	// the fixed content is applied in the next check
+	write(strings.Replace(invalid["the plugin section"], "twenty", "20", 1))
+	if err := w.Check(); err != nil {
+		t.Fatal(err)
+	}
+	expected = "[sw8 sw8-correlation] [] &{Enabled:false Limit:11} &{Enabled:false Limit:20} true"
+	if actual := current(); actual != expected || len(changes) != 2 {
+		t.Errorf("the fixed config: %v, expected: %v, changes: %d", actual, expected, len(changes))
+	}
+}
+
+func TestWatchOptions(t *testing.T) {
+	if _, err := Watch(&Config{Reload: ReloadOptions{Interval: time.Second}}); err == nil {
+		t.Error("watching without the file or URL")
+	}
+	if _, err := Watch(&Config{file: writeConfigFile(t, "service: users\n")}); err == nil {
+		t.Error("watching without the interval")
+	}
+}
@@ -0,0 +1,24 @@
+module github.com/mrproliu/go-agent-instrumentation/agent
+
+go 1.19
+
+require (
+	github.com/mrproliu/go-agent-instrumentation/framework/core v0.0.0-00010101000000-000000000000
+	go.opentelemetry.io/proto/otlp v1.0.0
+	google.golang.org/grpc v1.58.3
+	google.golang.org/protobuf v1.31.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/dave/dst v0.27.2 // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/grpc-ecosystem/grpc-gateway/v2 v2.16.0 // indirect
+	golang.org/x/net v0.12.0 // indirect
+	golang.org/x/sys v0.10.0 // indirect
+	golang.org/x/text v0.11.0 // indirect
+	google.golang.org/genproto/googleapis/api v0.0.0-20230711160842-782d3b101e98 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20230711160842-782d3b101e98 // indirect
+)
+
+replace github.com/mrproliu/go-agent-instrumentation/framework/core => ../frameworks/core
@@ -0,0 +1,174 @@
+package leak
+
+import (
+	"expvar"
+	"fmt"
+	"github.com/mrproliu/go-agent-instrumentation/framework/core"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// the count of leaked goroutines reported
+	leakedTotal = expvar.NewInt("skywalking_goroutine_leaks_total")
+	// the count of reported goroutines which still alive
+	leakedAlive = expvar.NewInt("skywalking_goroutine_leaks_alive")
+
+	detectorCount int32
+)
+
+type Options struct {
+	Threshold time.Duration                            // report the goroutines still alive the duration after the request finished, default 30s
+	Interval  time.Duration                            // the interval of checking, default 5s
+	Logger    func(format string, args ...interface{}) // the log of the leaked goroutines, default log.Printf
+}
+
+// Detector records the requests which spawn goroutines, reports the goroutines still alive
+// after the request finished for a while
+type Detector struct {
+	opts Options
+	key  *core.GLSKey
+	// the goroutines belong to the tracked requests, goid -> the request, read from the GLS of the alive goroutines
+	goroutines func() map[int64]*origin
+
+	lock    sync.Mutex
+	origins []*origin
+	stop    chan struct{}
+	stopped atomic.Bool
+}
+
+// the request which spawns goroutines, it's passed to the spawned goroutines directly or indirectly by the GLS
+type origin struct {
+	name     string
+	traceID  string
+	finished atomic.Int64 // unix nano, 0 means running
+	reported bool
+}
+
+func NewDetector(opts Options) *Detector {
+	if opts.Threshold <= 0 {
+		opts.Threshold = 30 * time.Second
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 5 * time.Second
+	}
+	if opts.Logger == nil {
+		opts.Logger = log.Printf
+	}
+	d := &Detector{
+		opts: opts,
+		key:  core.NewGLSKey(fmt.Sprintf("goroutine-leak-%d", atomic.AddInt32(&detectorCount, 1))),
+		stop: make(chan struct{}),
+	}
+	d.goroutines = d.trackedGoroutines
+	return d
+}
+
+// Start checks the leaked goroutines in background, return false if the runtime is not instrumented
+func (d *Detector) Start() bool {
+	if core.GoroutineID() == 0 {
+		return false
+	}
+	go d.run()
+	return true
+}
+
+func (d *Detector) Stop() {
+	if d.stopped.CompareAndSwap(false, true) {
+		close(d.stop)
+	}
+}
+
+// Track marks the current goroutine is processing the request, the goroutines spawned from now on belong to the request.
+// Invoke the returned function when the request finished
+func (d *Detector) Track(name, traceID string) func() {
+	if d.stopped.Load() || core.GoroutineID() == 0 {
+		return func() {}
+	}
+	o := &origin{name: name, traceID: traceID}
+	d.lock.Lock()
+	d.origins = append(d.origins, o)
+	d.lock.Unlock()
+
+	previous := d.key.Get()
+	d.key.Set(o)
+	return func() {
+		o.finished.CompareAndSwap(0, time.Now().UnixNano())
+		d.key.Set(previous)
+	}
+}
+
+// trackedGoroutines finds the tracked request of every alive goroutine by the GLS,
+// the goroutine which never accessed the GLS has the values of its parent, so it's found as well
+func (d *Detector) trackedGoroutines() map[int64]*origin {
+	result := make(map[int64]*origin)
+	for goid, gls := range core.GoroutinesGLS() {
+		if o, ok := d.key.GetFrom(gls).(*origin); ok {
+			result[goid] = o
+		}
+	}
+	return result
+}
+
+func (d *Detector) run() {
+	ticker := time.NewTicker(d.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			d.check(time.Now())
+		}
+	}
+}
+
+func (d *Detector) check(now time.Time) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	spawned := make(map[*origin][]int64)
+	for goid, o := range d.goroutines() {
+		spawned[o] = append(spawned[o], goid)
+	}
+	leaks := make(map[*origin][]int64)
+	var alive int64
+	origins := d.origins[:0]
+	for _, o := range d.origins {
+		finished := o.finished.Load()
+		if finished != 0 && len(spawned[o]) == 0 {
+			continue
+		}
+		origins = append(origins, o)
+		if finished == 0 || now.Sub(time.Unix(0, finished)) < d.opts.Threshold {
+			continue
+		}
+		alive += int64(len(spawned[o]))
+		if !o.reported {
+			goroutines := spawned[o]
+			sort.Slice(goroutines, func(i, j int) bool { return goroutines[i] < goroutines[j] })
+			leaks[o] = goroutines
+		}
+		o.reported = true
+	}
+	for i := len(origins); i < len(d.origins); i++ {
+		d.origins[i] = nil
+	}
+	d.origins = origins
+	leakedAlive.Set(alive)
+
+	if len(leaks) == 0 {
+		return
+	}
+	stacks := goroutineStacks()
+	for o, goroutines := range leaks {
+		for _, goid := range goroutines {
+			leakedTotal.Add(1)
+			d.opts.Logger("goroutine leak: goroutine %d is still alive %s after the request %q(trace id: %s) finished\n%s",
+				goid, now.Sub(time.Unix(0, o.finished.Load())).Truncate(time.Second), o.name, o.traceID, stacks[goid])
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package leak
+
+import (
+	"fmt"
+	"github.com/mrproliu/go-agent-instrumentation/framework/core"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// currentGoroutineID parses the ID from the stack header, such as "goroutine 18 [running]:"
+func currentGoroutineID(t *testing.T) int64 {
+	buf := make([]byte, 64)
+	header := strings.TrimPrefix(string(buf[:runtime.Stack(buf, false)]), "goroutine ")
+	goid, err := strconv.ParseInt(header[:strings.IndexByte(header, ' ')], 10, 64)
+	if err != nil {
+		t.Error(err)
+	}
+	return goid
+}
+
+func blockForever(t *testing.T, started chan<- int64, release <-chan struct{}) {
+	started <- currentGoroutineID(t)
+	<-release
+}
+
+func TestDetectorReportsLeakedGoroutine(t *testing.T) {
+	var lock sync.Mutex
+	logs := make([]string, 0)
+	d := NewDetector(Options{Threshold: 10 * time.Millisecond, Interval: time.Hour, Logger: func(format string, args ...interface{}) {
+		lock.Lock()
+		defer lock.Unlock()
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}})
+	defer d.Stop()
+
+	// the runtime is not instrumented in the test, the goroutine is marked as spawned by the request directly
+	o := &origin{name: "GET:/users", traceID: "trace-1"}
+	d.origins = append(d.origins, o)
+	started, release := make(chan int64), make(chan struct{})
+	go blockForever(t, started, release)
+	goid := <-started
+	alive := map[int64]*origin{goid: o}
+	d.goroutines = func() map[int64]*origin {
+		return alive
+	}
+
+	// the request is running
+	d.check(time.Now())
+	if len(logs) != 0 {
+		t.Fatalf("reported before the request finished: %v", logs)
+	}
+
+	finished := time.Now()
+	o.finished.Store(finished.UnixNano())
+	d.check(finished.Add(5 * time.Millisecond))
+	if len(logs) != 0 {
+		t.Fatalf("reported before the threshold: %v", logs)
+	}
+
+	d.check(finished.Add(20 * time.Millisecond))
+	if len(logs) != 1 {
+		t.Fatalf("the leak is not reported once: %v", logs)
+	}
+	expected := fmt.Sprintf(`goroutine leak: goroutine %d is still alive 0s after the request "GET:/users"(trace id: trace-1) finished`, goid)
+	if !strings.HasPrefix(logs[0], expected) {
+		t.Errorf("the log: %s, expected: %s", logs[0], expected)
+	}
+	// the stack of the leaked goroutine is logged with the location which created it
+	for _, frame := range []string{fmt.Sprintf("goroutine %d [chan receive", goid), "leak.blockForever", "created by"} {
+		if !strings.Contains(logs[0], frame) {
+			t.Errorf("the stack is not logged, %q is missing: %s", frame, logs[0])
+		}
+	}
+	if value := leakedAlive.Value(); value != 1 {
+		t.Errorf("the alive leaks: %d", value)
+	}
+
+	// reported only once, the request is kept until the goroutine exits
+	d.check(finished.Add(time.Second))
+	if len(logs) != 1 || len(d.origins) != 1 {
+		t.Fatalf("the leak is reported again: %v", logs)
+	}
+	close(release)
+	alive = map[int64]*origin{}
+	d.check(finished.Add(2 * time.Second))
+	if len(d.origins) != 0 || leakedAlive.Value() != 0 {
+		t.Errorf("the request is not removed after the goroutine exited, requests: %d, alive leaks: %d", len(d.origins), leakedAlive.Value())
+	}
+}
+
+func TestDetectorIgnoresExitedGoroutines(t *testing.T) {
+	logs := make([]string, 0)
+	d := NewDetector(Options{Threshold: 10 * time.Millisecond, Interval: time.Hour, Logger: func(format string, args ...interface{}) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}})
+	defer d.Stop()
+	d.goroutines = func() map[int64]*origin {
+		return nil
+	}
+
+	o := &origin{name: "GET:/orders", traceID: "trace-2"}
+	d.origins = append(d.origins, o)
+	finished := time.Now()
+	o.finished.Store(finished.UnixNano())
+	d.check(finished.Add(time.Second))
+	if len(logs) != 0 || len(d.origins) != 0 {
+		t.Errorf("the request without alive goroutines, logs: %v, requests: %d", logs, len(d.origins))
+	}
+}
+
+// TestDetectorWithInstrumentedRuntime reads the GLS of the goroutines while they are changing it, for the race detector.
+// It's run by "go test -toolexec", see TestInstrumentedRuntime of the toolexec
+func TestDetectorWithInstrumentedRuntime(t *testing.T) {
+	if core.GoroutineID() == 0 {
+		t.Skip("the runtime is not instrumented")
+	}
+	var lock sync.Mutex
+	logs := make([]string, 0)
+	d := NewDetector(Options{Threshold: time.Millisecond, Interval: time.Millisecond, Logger: func(format string, args ...interface{}) {
+		lock.Lock()
+		defer lock.Unlock()
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}})
+	d.Start()
+	defer d.Stop()
+
+	// the other goroutines keep changing the values of different types and spawning
+	stop := make(chan struct{})
+	var churn sync.WaitGroup
+	key := core.NewGLSKey("test-churn")
+	for i := 0; i < 8; i++ {
+		churn.Add(1)
+		go func() {
+			defer churn.Done()
+			for j := 0; ; j++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if j%2 == 0 {
+					key.Set(j)
+				} else {
+					key.Set(strconv.Itoa(j))
+				}
+				go func() {}()
+			}
+		}()
+	}
+	defer func() {
+		close(stop)
+		churn.Wait()
+	}()
+
+	// the goroutines spawned by the request directly and indirectly
+	started, release := make(chan int64), make(chan struct{})
+	defer close(release)
+	finish := d.Track("GET:/users", "trace-1")
+	go func() {
+		go blockForever(t, started, release)
+		blockForever(t, started, release)
+	}()
+	leaked := []int64{<-started, <-started}
+	finish()
+
+	reported := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string(nil), logs...)
+	}
+	for deadline := time.Now().Add(5 * time.Second); len(reported()) < len(leaked); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("the leaks are not reported: %v", reported())
+		}
+	}
+	for _, goid := range leaked {
+		expected := fmt.Sprintf(`goroutine %d is still alive`, goid)
+		found := false
+		for _, log := range reported() {
+			found = found || strings.Contains(log, expected) && strings.Contains(log, "(trace id: trace-1)")
+		}
+		if !found {
+			t.Errorf("the leaked goroutine %d is not reported: %v", goid, reported())
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package leak
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+)
+
+// goroutineStacks dumps the stacks of all goroutines, goid -> stack, the stack ends with the "created by" location
+func goroutineStacks() map[int64]string {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+
+	result := make(map[int64]string)
+	for _, block := range bytes.Split(buf, []byte("\n\n")) {
+		// goroutine 18 [chan receive, 1 minutes]:
+		header := bytes.TrimPrefix(block, []byte("goroutine "))
+		end := bytes.IndexByte(header, ' ')
+		if len(header) == len(block) || end < 0 {
+			continue
+		}
+		goid, err := strconv.ParseInt(string(header[:end]), 10, 64)
+		if err != nil {
+			continue
+		}
+		result[goid] = string(block)
+	}
+	return result
+}
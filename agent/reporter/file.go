@@ -0,0 +1,124 @@
+package reporter
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/mrproliu/go-agent-instrumentation/framework/core"
+	"os"
+	"path/filepath"
+)
+
+type FileOptions struct {
+	Path       string // the path of the file, default "skywalking-segments.ndjson"
+	MaxSize    int64  // rotate the file when it exceeds the size in bytes, default 100MB
+	MaxBackups int    // the count of rotated files kept as <path>.1 ... <path>.N, default 3
+}
+
+// FileReporter writes the segments as the newline delimited JSON, rotates the file by size
+type FileReporter struct {
+	opts    FileOptions
+	file    *os.File
+	writer  *bufio.Writer
+	size    int64
+	renamed bool // the files are renamed for rotating, but the new file isn't opened yet
+}
+
+func NewFileReporter(opts FileOptions) (*FileReporter, error) {
+	if opts.Path == "" {
+		opts.Path = "skywalking-segments.ndjson"
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = 100 * 1024 * 1024
+	}
+	if opts.MaxBackups <= 0 {
+		opts.MaxBackups = 3
+	}
+	if err := os.MkdirAll(filepath.Dir(opts.Path), 0755); err != nil {
+		return nil, err
+	}
+	f := &FileReporter{opts: opts}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *FileReporter) Report(segments []*core.SegmentData) error {
+	for _, segment := range segments {
+		content, err := json.Marshal(segment)
+		if err != nil {
+			return err
+		}
+		if f.size > 0 && f.size+int64(len(content))+1 > f.opts.MaxSize {
+			if err := f.rotate(); err != nil {
+				return err
+			}
+		}
+		n, err := f.writer.Write(append(content, '\n'))
+		f.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return f.writer.Flush()
+}
+
+func (f *FileReporter) Close() error {
+	if err := f.writer.Flush(); err != nil {
+		f.file.Close()
+		return err
+	}
+	return f.file.Close()
+}
+
+func (f *FileReporter) open() error {
+	file, size, err := openFile(f.opts.Path)
+	if err != nil {
+		return err
+	}
+	f.file = file
+	f.writer = bufio.NewWriter(file)
+	f.size = size
+	return nil
+}
+
+func openFile(path string) (*os.File, int64, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, 0, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, stat.Size(), nil
+}
+
+// rotate renames the files: path.N-1 -> path.N ... path -> path.1, the oldest one is removed.
+// The current file is kept until the new file opened, so the failed rotation is retried by the next write
+func (f *FileReporter) rotate() error {
+	if err := f.writer.Flush(); err != nil {
+		return err
+	}
+	if !f.renamed {
+		for i := f.opts.MaxBackups - 1; i >= 0; i-- {
+			from := f.opts.Path
+			if i > 0 {
+				from = fmt.Sprintf("%s.%d", f.opts.Path, i)
+			}
+			if err := os.Rename(from, fmt.Sprintf("%s.%d", f.opts.Path, i+1)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		f.renamed = true
+	}
+	file, size, err := openFile(f.opts.Path)
+	if err != nil {
+		return err
+	}
+	f.file.Close()
+	f.file, f.writer, f.size, f.renamed = file, bufio.NewWriter(file), size, false
+	return nil
+}
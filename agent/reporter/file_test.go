@@ -0,0 +1,111 @@
+package reporter
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/mrproliu/go-agent-instrumentation/framework/core"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// readTraceIDs reads the trace ids of the segments in the file, nil if the file not exists
+func readTraceIDs(t *testing.T, path string) []string {
+	t.Helper()
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	result := make([]string, 0)
+	for scanner := bufio.NewScanner(file); scanner.Scan(); {
+		segment := &core.SegmentData{}
+		if err := json.Unmarshal(scanner.Bytes(), segment); err != nil {
+			t.Fatalf("the line of %s: %v", path, err)
+		}
+		result = append(result, segment.TraceID)
+	}
+	return result
+}
+
+func reportTrace(r Reporter, traceID string) error {
+	return r.Report([]*core.SegmentData{{TraceID: traceID}})
+}
+
+func TestFileReporterRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "segments.ndjson")
+	// every segment exceeds the size, so the file is rotated before writing the next one
+	r, err := NewFileReporter(FileOptions{Path: path, MaxSize: 1, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, traceID := range []string{"trace-0", "trace-1", "trace-2", "trace-3"} {
+		if err := reportTrace(r, traceID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files := [][]string{readTraceIDs(t, path), readTraceIDs(t, path+".1"), readTraceIDs(t, path+".2"), readTraceIDs(t, path+".3")}
+	if expected := [][]string{{"trace-3"}, {"trace-2"}, {"trace-1"}, nil}; !reflect.DeepEqual(files, expected) {
+		t.Errorf("the rotated files: %v, expected: %v", files, expected)
+	}
+
+	// the size of the existing file is counted when opening
+	r, err = NewFileReporter(FileOptions{Path: path, MaxSize: 1, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if err := reportTrace(r, "trace-4"); err != nil {
+		t.Fatal(err)
+	}
+	if ids := readTraceIDs(t, path); !reflect.DeepEqual(ids, []string{"trace-4"}) {
+		t.Errorf("the file after reopening: %v", ids)
+	}
+}
+
+func TestFileReporterRotateFailure(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	path := filepath.Join(dir, "segments.ndjson")
+	r, err := NewFileReporter(FileOptions{Path: path, MaxSize: 1, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if err := reportTrace(r, "trace-0"); err != nil {
+		t.Fatal(err)
+	}
+	// the new file couldn't be opened, the current file is kept
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := reportTrace(r, "trace-1"); err == nil {
+		t.Fatal("the rotation without the directory is succeeded")
+	}
+	if err := reportTrace(r, "trace-2"); err == nil {
+		t.Fatal("the rotation without the directory is succeeded")
+	}
+
+	// the rotation is retried by the next write
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := reportTrace(r, "trace-3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := reportTrace(r, "trace-4"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files := [][]string{readTraceIDs(t, path), readTraceIDs(t, path+".1"), readTraceIDs(t, path+".2")}
+	if expected := [][]string{{"trace-4"}, {"trace-3"}, nil}; !reflect.DeepEqual(files, expected) {
+		t.Errorf("the rotated files: %v, expected: %v", files, expected)
+	}
+}
@@ -0,0 +1,40 @@
+package reporter
+
+import (
+	"encoding/json"
+	"github.com/mrproliu/go-agent-instrumentation/framework/core"
+	"log"
+	"os"
+)
+
+type LogOptions struct {
+	Logger *log.Logger // default writes to the stdout
+}
+
+// LogReporter writes the segment as a JSON line into the logger
+type LogReporter struct {
+	logger *log.Logger
+}
+
+func NewLogReporter(opts LogOptions) *LogReporter {
+	logger := opts.Logger
+	if logger == nil {
+		logger = log.New(os.Stdout, "[skywalking] ", log.LstdFlags)
+	}
+	return &LogReporter{logger: logger}
+}
+
+func (l *LogReporter) Report(segments []*core.SegmentData) error {
+	for _, segment := range segments {
+		content, err := json.Marshal(segment)
+		if err != nil {
+			return err
+		}
+		l.logger.Printf("segment: %s", content)
+	}
+	return nil
+}
+
+func (l *LogReporter) Close() error {
+	return nil
+}
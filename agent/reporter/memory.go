@@ -0,0 +1,40 @@
+package reporter
+
+import (
+	"github.com/mrproliu/go-agent-instrumentation/framework/core"
+	"sync"
+)
+
+// MemoryReporter keeps the reported segments in memory, for the tests
+type MemoryReporter struct {
+	lock     sync.Mutex
+	segments []*core.SegmentData
+}
+
+func NewMemoryReporter() *MemoryReporter {
+	return &MemoryReporter{}
+}
+
+func (m *MemoryReporter) Report(segments []*core.SegmentData) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.segments = append(m.segments, segments...)
+	return nil
+}
+
+// Segments returns the reported segments
+func (m *MemoryReporter) Segments() []*core.SegmentData {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]*core.SegmentData(nil), m.segments...)
+}
+
+func (m *MemoryReporter) Reset() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.segments = nil
+}
+
+func (m *MemoryReporter) Close() error {
+	return nil
+}
@@ -0,0 +1,196 @@
+package otlp
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/mrproliu/go-agent-instrumentation/framework/core"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"net"
+	"sort"
+	"strconv"
+)
+
+const scopeName = "github.com/mrproliu/go-agent-instrumentation"
+
+// the tags of the agent are renamed to the OpenTelemetry semantic conventions
+var semanticTags = map[string]string{
+	"http.method":  "http.request.method",
+	"url":          "url.full",
+	"status_code":  "http.response.status_code",
+	"db.type":      "db.system",
+	"db.instance":  "db.name",
+	"db.statement": "db.statement",
+	"mq.broker":    "messaging.url",
+	"mq.queue":     "messaging.destination.name",
+	"mq.topic":     "messaging.destination.name",
+}
+
+// the attributes with the int value
+var intAttributes = map[string]bool{
+	"http.response.status_code": true,
+	"server.port":               true,
+}
+
+func buildRequest(segments []*core.SegmentData, resource []*commonpb.KeyValue) *coltracepb.ExportTraceServiceRequest {
+	spans := make([]*tracepb.Span, 0)
+	for _, segment := range segments {
+		for _, span := range segment.Spans {
+			spans = append(spans, convertSpan(segment, span))
+		}
+	}
+	return &coltracepb.ExportTraceServiceRequest{
+		ResourceSpans: []*tracepb.ResourceSpans{{
+			Resource: &resourcepb.Resource{Attributes: resource},
+			ScopeSpans: []*tracepb.ScopeSpans{{
+				Scope: &commonpb.InstrumentationScope{Name: scopeName, Version: core.AgentVersion()},
+				Spans: spans,
+			}},
+		}},
+	}
+}
+
+func convertSpan(segment *core.SegmentData, span *core.SpanData) *tracepb.Span {
+	result := &tracepb.Span{
+		TraceId:           traceID(segment.TraceID),
+		SpanId:            spanID(segment.SegmentID, span.SpanID),
+		Name:              span.OperationName,
+		Kind:              spanKind(span),
+		StartTimeUnixNano: uint64(span.StartTime.UnixNano()),
+		EndTimeUnixNano:   uint64(span.EndTime.UnixNano()),
+		Status:            &tracepb.Status{},
+	}
+	if span.ParentSpanID >= 0 {
+		result.ParentSpanId = spanID(segment.SegmentID, span.ParentSpanID)
+	} else if len(segment.Refs) > 0 {
+		// the first span continues the span of the parent segment, the other refs are links
+		result.ParentSpanId = spanID(segment.Refs[0].ParentSegmentID, segment.Refs[0].ParentSpanID)
+		for _, ref := range segment.Refs[1:] {
+			result.Links = append(result.Links, &tracepb.Span_Link{
+				TraceId: traceID(ref.TraceID),
+				SpanId:  spanID(ref.ParentSegmentID, ref.ParentSpanID),
+			})
+		}
+	}
+
+	for _, tag := range span.Tags {
+		key := tag.Key
+		if semantic, ok := semanticTags[key]; ok {
+			key = semantic
+		}
+		result.Attributes = append(result.Attributes, attribute(key, tag.Value))
+	}
+	if span.Peer != "" {
+		host, port, err := net.SplitHostPort(span.Peer)
+		if err != nil {
+			host = span.Peer
+		}
+		result.Attributes = append(result.Attributes, attribute("server.address", host))
+		if port != "" {
+			result.Attributes = append(result.Attributes, attribute("server.port", port))
+		}
+	}
+	if span.ComponentID != core.ComponentIDUnknown {
+		result.Attributes = append(result.Attributes, attribute("skywalking.component_id", strconv.Itoa(int(span.ComponentID))))
+	}
+
+	for _, log := range span.Logs {
+		result.Events = append(result.Events, convertLog(log))
+	}
+	if span.IsError {
+		result.Status.Code = tracepb.Status_STATUS_CODE_ERROR
+		for _, event := range result.Events {
+			if event.Name == "exception" {
+				for _, attr := range event.Attributes {
+					if attr.Key == "exception.message" {
+						result.Status.Message = attr.Value.GetStringValue()
+					}
+				}
+			}
+		}
+	}
+	return result
+}
+
+// convertLog converts the log to the event, the error log is the "exception" event
+func convertLog(log core.LogData) *tracepb.Span_Event {
+	event := &tracepb.Span_Event{Name: "log", TimeUnixNano: uint64(log.Time.UnixNano())}
+	fields := make(map[string]string)
+	for _, field := range log.Fields {
+		fields[field.Key] = field.Value
+	}
+	if name, ok := fields["event"]; ok {
+		event.Name = name
+		delete(fields, "event")
+	}
+	if event.Name == "error" {
+		event.Name = "exception"
+		if message, ok := fields["message"]; ok {
+			fields["exception.message"] = message
+			delete(fields, "message")
+		}
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		event.Attributes = append(event.Attributes, attribute(k, fields[k]))
+	}
+	return event
+}
+
+func spanKind(span *core.SpanData) tracepb.Span_SpanKind {
+	switch span.Kind {
+	case core.SpanKindEntry:
+		if span.Layer == core.SpanLayerMQ {
+			return tracepb.Span_SPAN_KIND_CONSUMER
+		}
+		return tracepb.Span_SPAN_KIND_SERVER
+	case core.SpanKindExit:
+		if span.Layer == core.SpanLayerMQ {
+			return tracepb.Span_SPAN_KIND_PRODUCER
+		}
+		return tracepb.Span_SPAN_KIND_CLIENT
+	}
+	return tracepb.Span_SPAN_KIND_INTERNAL
+}
+
+func attribute(key, value string) *commonpb.KeyValue {
+	if intAttributes[key] {
+		if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: v}}}
+		}
+	}
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+// traceID uses the trace id directly if it's 16 bytes hex, the 8 bytes(64-bit B3) is left padded with zeros,
+// otherwise hash it. It's same with the trace id injected by the W3C propagator
+func traceID(id string) []byte {
+	if decoded, err := hex.DecodeString(id); err == nil && len(decoded) == 16 {
+		return decoded
+	} else if err == nil && len(decoded) == 8 {
+		return append(make([]byte, 8), decoded...)
+	}
+	sum := sha256.Sum256([]byte(id))
+	return sum[:16]
+}
+
+// spanID builds the 8 bytes span id from the segment and the span id in segment,
+// so the parent span id could be built from the refs.
+// The span id extracted from the W3C or B3 headers is the parent segment id of ref, use it directly
+func spanID(segmentID string, id int32) []byte {
+	if decoded, err := hex.DecodeString(segmentID); err == nil && len(decoded) == 8 && id == 0 {
+		return decoded
+	}
+	sum := sha256.Sum256([]byte(segmentID))
+	result := make([]byte, 8)
+	copy(result, sum[:4])
+	binary.BigEndian.PutUint32(result[4:], uint32(id))
+	return result
+}
@@ -0,0 +1,55 @@
+package otlp
+
+import (
+	"encoding/hex"
+	"github.com/mrproliu/go-agent-instrumentation/framework/core"
+	"strings"
+	"testing"
+)
+
+// TestTraceIDConsistentWithPropagation checks the exported ids are same with the ids injected into the downstream
+func TestTraceIDConsistentWithPropagation(t *testing.T) {
+	propagator, err := core.NewPropagator(core.PropagatorB3, core.PropagatorTraceContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	core.SetPropagator(propagator)
+	var segments []*core.SegmentData
+	core.SetSegmentReporter(func(segment *core.SegmentData) {
+		segments = append(segments, segment)
+	})
+	defer func() {
+		core.SetPropagator(nil)
+		core.SetSegmentReporter(nil)
+	}()
+
+	tests := map[string]string{
+		"463ac35c9f6413ad":                 "0000000000000000463ac35c9f6413ad", // 64-bit B3 trace id
+		"80f198ee56343ba864fe8b2a57d3eff7": "80f198ee56343ba864fe8b2a57d3eff7",
+	}
+	for upstream, expected := range tests {
+		segments = nil
+		span := core.GetTracer().CreateEntrySpan("GET:/users", core.MapCarrier{"b3": upstream + "-a2fb4a1d1a96d312-1"})
+		downstream := core.MapCarrier{}
+		span.Inject(downstream)
+		span.End()
+		if len(segments) != 1 {
+			t.Fatalf("the reported segments: %d", len(segments))
+		}
+
+		parts := strings.Split(downstream.Get("traceparent"), "-")
+		if len(parts) != 4 || parts[1] != expected {
+			t.Fatalf("the injected traceparent of %s: %s", upstream, downstream.Get("traceparent"))
+		}
+		exported := buildRequest(segments, nil).ResourceSpans[0].ScopeSpans[0].Spans[0]
+		if id := hex.EncodeToString(exported.TraceId); id != parts[1] {
+			t.Errorf("the exported trace id of %s: %s, injected: %s", upstream, id, parts[1])
+		}
+		if id := hex.EncodeToString(exported.SpanId); id != parts[2] {
+			t.Errorf("the exported span id of %s: %s, injected: %s", upstream, id, parts[2])
+		}
+		if id := hex.EncodeToString(exported.ParentSpanId); id != "a2fb4a1d1a96d312" {
+			t.Errorf("the exported parent span id of %s: %s", upstream, id)
+		}
+	}
+}
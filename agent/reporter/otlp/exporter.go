@@ -0,0 +1,190 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/mrproliu/go-agent-instrumentation/agent/reporter"
+	"github.com/mrproliu/go-agent-instrumentation/framework/core"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	ProtocolGRPC = "grpc"
+	ProtocolHTTP = "http/protobuf"
+)
+
+type Options struct {
+	Protocol           string            // "grpc"(default) or "http/protobuf"
+	Endpoint           string            // default "127.0.0.1:4317" for gRPC, "http://127.0.0.1:4318/v1/traces" for HTTP
+	Headers            map[string]string // the headers(gRPC metadata) of the requests, such as the authentication
+	Service            string            // the service.name of the resource, default the name of the executable
+	Instance           string            // the service.instance.id of the resource, optional
+	ResourceAttributes map[string]string // the extra attributes of the resource
+	Timeout            time.Duration     // the timeout of each export, default 10s
+}
+
+// Exporter sends the segments as the OTLP spans to the OpenTelemetry Collector
+type Exporter struct {
+	opts     Options
+	resource []*commonpb.KeyValue
+
+	conn   *grpc.ClientConn
+	client coltracepb.TraceServiceClient
+	http   *http.Client
+}
+
+func init() {
+	reporter.DeclareOptions("otlp", Options{})
+	reporter.Register("otlp", func(cfg *reporter.Config) (reporter.Reporter, error) {
+		var opts Options
+		if err := reporter.DecodeOptions(cfg, "otlp", &opts); err != nil {
+			return nil, err
+		}
+		if opts.Service == "" {
+			opts.Service = cfg.Service
+		}
+		if opts.Instance == "" {
+			opts.Instance = cfg.Instance
+		}
+		return New(opts)
+	})
+}
+
+func New(opts Options) (*Exporter, error) {
+	if opts.Protocol == "" {
+		opts.Protocol = ProtocolGRPC
+	}
+	if opts.Service == "" {
+		opts.Service = filepath.Base(os.Args[0])
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	e := &Exporter{opts: opts, resource: resourceAttributes(opts)}
+	switch opts.Protocol {
+	case ProtocolGRPC:
+		if e.opts.Endpoint == "" {
+			e.opts.Endpoint = "127.0.0.1:4317"
+		}
+		conn, err := grpc.Dial(e.opts.Endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			return nil, err
+		}
+		e.conn = conn
+		e.client = coltracepb.NewTraceServiceClient(conn)
+	case ProtocolHTTP:
+		if e.opts.Endpoint == "" {
+			e.opts.Endpoint = "http://127.0.0.1:4318/v1/traces"
+		}
+		e.http = &http.Client{Timeout: opts.Timeout}
+	default:
+		return nil, fmt.Errorf("unknown OTLP protocol %q", opts.Protocol)
+	}
+	return e, nil
+}
+
+func (e *Exporter) Report(segments []*core.SegmentData) error {
+	request := buildRequest(segments, e.resource)
+	if e.client != nil {
+		return e.exportGRPC(request)
+	}
+	return e.exportHTTP(request)
+}
+
+func (e *Exporter) Close() error {
+	if e.conn != nil {
+		return e.conn.Close()
+	}
+	return nil
+}
+
+func (e *Exporter) exportGRPC(request *coltracepb.ExportTraceServiceRequest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), e.opts.Timeout)
+	defer cancel()
+	if len(e.opts.Headers) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(e.opts.Headers))
+	}
+	response, err := e.client.Export(ctx, request)
+	if err != nil {
+		return err
+	}
+	return partialFailure(response)
+}
+
+func (e *Exporter) exportHTTP(request *coltracepb.ExportTraceServiceRequest) error {
+	body, err := proto.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.opts.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	for k, v := range e.opts.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("export to %s failure, status: %d, body: %s", e.opts.Endpoint, resp.StatusCode, content)
+	}
+	response := &coltracepb.ExportTraceServiceResponse{}
+	if len(content) > 0 && resp.Header.Get("Content-Type") == "application/x-protobuf" {
+		if err := proto.Unmarshal(content, response); err != nil {
+			return err
+		}
+	}
+	return partialFailure(response)
+}
+
+func partialFailure(response *coltracepb.ExportTraceServiceResponse) error {
+	if p := response.GetPartialSuccess(); p != nil && p.RejectedSpans > 0 {
+		return fmt.Errorf("%d spans are rejected by the collector: %s", p.RejectedSpans, p.ErrorMessage)
+	}
+	return nil
+}
+
+func resourceAttributes(opts Options) []*commonpb.KeyValue {
+	attributes := map[string]string{
+		"service.name":           opts.Service,
+		"telemetry.sdk.language": "go",
+		"telemetry.sdk.name":     "go-agent-instrumentation",
+		"telemetry.sdk.version":  core.AgentVersion(),
+	}
+	if opts.Instance != "" {
+		attributes["service.instance.id"] = opts.Instance
+	}
+	for k, v := range opts.ResourceAttributes {
+		attributes[k] = v
+	}
+	keys := make([]string, 0, len(attributes))
+	for k := range attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	result := make([]*commonpb.KeyValue, 0, len(keys))
+	for _, k := range keys {
+		result = append(result, attribute(k, attributes[k]))
+	}
+	return result
+}
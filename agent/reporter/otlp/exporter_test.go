@@ -0,0 +1,196 @@
+package otlp
+
+import (
+	"context"
+	"encoding/hex"
+	"github.com/mrproliu/go-agent-instrumentation/framework/core"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCollector receives the spans by the OTLP/gRPC and OTLP/HTTP
+type fakeCollector struct {
+	coltracepb.UnimplementedTraceServiceServer
+
+	lock     sync.Mutex
+	requests []*coltracepb.ExportTraceServiceRequest
+	headers  []string
+}
+
+func (f *fakeCollector) Export(ctx context.Context, request *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	f.record(request, md.Get("x-token"))
+	return &coltracepb.ExportTraceServiceResponse{}, nil
+}
+
+func (f *fakeCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil || r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	request := &coltracepb.ExportTraceServiceRequest{}
+	if err := proto.Unmarshal(body, request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.record(request, r.Header.Values("X-Token"))
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	content, _ := proto.Marshal(&coltracepb.ExportTraceServiceResponse{})
+	w.Write(content)
+}
+
+func (f *fakeCollector) record(request *coltracepb.ExportTraceServiceRequest, headers []string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.requests = append(f.requests, request)
+	f.headers = append(f.headers, headers...)
+}
+
+func testSegment() *core.SegmentData {
+	now := time.Now()
+	return &core.SegmentData{
+		TraceID:   "0af7651916cd43dd8448eb211c80319c",
+		SegmentID: "segment-1",
+		Refs: []core.SegmentRef{{
+			RefType: core.SegmentRefCrossProcess, TraceID: "0af7651916cd43dd8448eb211c80319c", ParentSegmentID: "parent-segment", ParentSpanID: 3,
+		}},
+		Spans: []*core.SpanData{
+			{SpanID: 1, ParentSpanID: 0, OperationName: "GET:/orders", Peer: "orders:8080", Kind: core.SpanKindExit,
+				Layer: core.SpanLayerHttp, ComponentID: core.ComponentIDGoHttpClient, StartTime: now, EndTime: now, IsError: true,
+				Logs: []core.LogData{{Time: now, Fields: []core.Tag{{Key: "event", Value: "error"}, {Key: "message", Value: "connection refused"}}}}},
+			{SpanID: 0, ParentSpanID: -1, OperationName: "GET:/users", Kind: core.SpanKindEntry, Layer: core.SpanLayerHttp,
+				ComponentID: core.ComponentIDGin, StartTime: now.Add(-time.Second), EndTime: now,
+				Tags: []core.Tag{{Key: "http.method", Value: "GET"}, {Key: "status_code", Value: "500"}}},
+		},
+	}
+}
+
+func attributes(kvs []*commonpb.KeyValue) map[string]*commonpb.AnyValue {
+	result := make(map[string]*commonpb.AnyValue)
+	for _, kv := range kvs {
+		result[kv.Key] = kv.Value
+	}
+	return result
+}
+
+func verify(t *testing.T, collector *fakeCollector) {
+	collector.lock.Lock()
+	defer collector.lock.Unlock()
+	if len(collector.requests) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(collector.requests))
+	}
+	if len(collector.headers) != 1 || collector.headers[0] != "secret" {
+		t.Fatalf("the headers are not sent: %v", collector.headers)
+	}
+	resourceSpans := collector.requests[0].ResourceSpans
+	if len(resourceSpans) != 1 || len(resourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected resource spans: %v", resourceSpans)
+	}
+	resource := attributes(resourceSpans[0].Resource.Attributes)
+	if resource["service.name"].GetStringValue() != "svc" || resource["service.instance.id"].GetStringValue() != "inst" ||
+		resource["deployment.environment"].GetStringValue() != "test" || resource["telemetry.sdk.language"].GetStringValue() != "go" {
+		t.Fatalf("unexpected resource: %v", resource)
+	}
+
+	spans := resourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	exit, entry := spans[0], spans[1]
+	if hex.EncodeToString(entry.TraceId) != "0af7651916cd43dd8448eb211c80319c" || len(entry.SpanId) != 8 {
+		t.Fatalf("unexpected ids: %x %x", entry.TraceId, entry.SpanId)
+	}
+	if entry.Kind != tracepb.Span_SPAN_KIND_SERVER || exit.Kind != tracepb.Span_SPAN_KIND_CLIENT {
+		t.Fatalf("unexpected kinds: %v %v", entry.Kind, exit.Kind)
+	}
+	if string(exit.ParentSpanId) != string(entry.SpanId) {
+		t.Fatal("the exit span should be the child of entry span")
+	}
+	if string(entry.ParentSpanId) != string(spanID("parent-segment", 3)) {
+		t.Fatal("the entry span should be the child of the span in parent segment")
+	}
+	entryAttrs := attributes(entry.Attributes)
+	if entryAttrs["http.request.method"].GetStringValue() != "GET" || entryAttrs["http.response.status_code"].GetIntValue() != 500 {
+		t.Fatalf("unexpected entry attributes: %v", entryAttrs)
+	}
+	exitAttrs := attributes(exit.Attributes)
+	if exitAttrs["server.address"].GetStringValue() != "orders" || exitAttrs["server.port"].GetIntValue() != 8080 {
+		t.Fatalf("unexpected exit attributes: %v", exitAttrs)
+	}
+	if exit.Status.Code != tracepb.Status_STATUS_CODE_ERROR || exit.Status.Message != "connection refused" {
+		t.Fatalf("unexpected status: %v", exit.Status)
+	}
+	if len(exit.Events) != 1 || exit.Events[0].Name != "exception" ||
+		attributes(exit.Events[0].Attributes)["exception.message"].GetStringValue() != "connection refused" {
+		t.Fatalf("unexpected events: %v", exit.Events)
+	}
+}
+
+func options(protocol, endpoint string) Options {
+	return Options{
+		Protocol:           protocol,
+		Endpoint:           endpoint,
+		Headers:            map[string]string{"x-token": "secret"},
+		Service:            "svc",
+		Instance:           "inst",
+		ResourceAttributes: map[string]string{"deployment.environment": "test"},
+	}
+}
+
+func TestExportGRPC(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	collector := &fakeCollector{}
+	server := grpc.NewServer()
+	coltracepb.RegisterTraceServiceServer(server, collector)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	e, err := New(options(ProtocolGRPC, listener.Addr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	if err := e.Report([]*core.SegmentData{testSegment()}); err != nil {
+		t.Fatal(err)
+	}
+	verify(t, collector)
+}
+
+func TestExportHTTP(t *testing.T) {
+	collector := &fakeCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	e, err := New(options(ProtocolHTTP, server.URL+"/v1/traces"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	if err := e.Report([]*core.SegmentData{testSegment()}); err != nil {
+		t.Fatal(err)
+	}
+	verify(t, collector)
+
+	failed, err := New(options(ProtocolHTTP, server.URL+"/unknown"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := failed.Report([]*core.SegmentData{testSegment()}); err == nil {
+		t.Fatal("the export should fail when the collector rejects it")
+	}
+}
@@ -0,0 +1,193 @@
+package reporter
+
+import (
+	"errors"
+	"expvar"
+	"github.com/mrproliu/go-agent-instrumentation/framework/core"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	sentTotal    = expvar.NewInt("skywalking_reporter_segments_sent")
+	droppedTotal = expvar.NewInt("skywalking_reporter_segments_dropped")
+	failedTotal  = expvar.NewInt("skywalking_reporter_segments_failed")
+)
+
+var ErrShutdown = errors.New("the reporter pipeline is shutdown")
+
+type Options struct {
+	QueueSize     int           // the max count of queued segments, the new segments are dropped when full, default 10000
+	BatchSize     int           // the max count of segments in a report, default 100
+	FlushInterval time.Duration // the max time of a segment waits in the queue, default 1s
+}
+
+// Stats is the counters of the pipeline
+type Stats struct {
+	Queued  int
+	Sent    int64
+	Dropped int64
+	Failed  int64
+}
+
+// Pipeline queues the segments, and reports them in batch by a background goroutine
+type Pipeline struct {
+	reporter Reporter
+	opts     Options
+
+	queue    chan *core.SegmentData
+	flush    chan chan struct{}
+	shutdown chan struct{}
+	done     chan struct{}
+	once     sync.Once
+
+	// guards the closed with the enqueuing, so the segments offered before shutdown are all drained
+	lock   sync.RWMutex
+	closed bool
+
+	sent    atomic.Int64
+	dropped atomic.Int64
+	failed  atomic.Int64
+}
+
+func NewPipeline(r Reporter, opts Options) *Pipeline {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 10000
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	p := &Pipeline{
+		reporter: r,
+		opts:     opts,
+		queue:    make(chan *core.SegmentData, opts.QueueSize),
+		flush:    make(chan chan struct{}),
+		shutdown: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Offer queues the segment without blocking, the segment is dropped when the queue is full
+func (p *Pipeline) Offer(segment *core.SegmentData) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if p.closed {
+		p.drop()
+		return
+	}
+	select {
+	case p.queue <- segment:
+	default:
+		p.drop()
+	}
+}
+
+// Flush reports the queued segments, wait until finished or timeout
+func (p *Pipeline) Flush(timeout time.Duration) error {
+	finished := make(chan struct{})
+	select {
+	case p.flush <- finished:
+	case <-p.done:
+		return ErrShutdown
+	case <-time.After(timeout):
+		return errors.New("flush the reporter timeout")
+	}
+	select {
+	case <-finished:
+		return nil
+	case <-time.After(timeout):
+		return errors.New("flush the reporter timeout")
+	}
+}
+
+// Shutdown reports the queued segments and closes the reporter, the segments offered after shutdown are dropped
+func (p *Pipeline) Shutdown(timeout time.Duration) error {
+	p.once.Do(func() {
+		p.lock.Lock()
+		p.closed = true
+		p.lock.Unlock()
+		close(p.shutdown)
+	})
+	select {
+	case <-p.done:
+	case <-time.After(timeout):
+		return errors.New("shutdown the reporter timeout")
+	}
+	return p.reporter.Close()
+}
+
+func (p *Pipeline) Stats() Stats {
+	return Stats{
+		Queued:  len(p.queue),
+		Sent:    p.sent.Load(),
+		Dropped: p.dropped.Load(),
+		Failed:  p.failed.Load(),
+	}
+}
+
+func (p *Pipeline) drop() {
+	p.dropped.Add(1)
+	droppedTotal.Add(1)
+}
+
+func (p *Pipeline) run() {
+	defer close(p.done)
+	ticker := time.NewTicker(p.opts.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]*core.SegmentData, 0, p.opts.BatchSize)
+	for {
+		select {
+		case segment := <-p.queue:
+			batch = append(batch, segment)
+			if len(batch) >= p.opts.BatchSize {
+				batch = p.report(batch)
+			}
+		case <-ticker.C:
+			batch = p.report(batch)
+		case finished := <-p.flush:
+			batch = p.report(p.drain(batch))
+			close(finished)
+		case <-p.shutdown:
+			p.report(p.drain(batch))
+			return
+		}
+	}
+}
+
+// drain reports all queued segments, return the remaining batch
+func (p *Pipeline) drain(batch []*core.SegmentData) []*core.SegmentData {
+	for {
+		select {
+		case segment := <-p.queue:
+			batch = append(batch, segment)
+			if len(batch) >= p.opts.BatchSize {
+				batch = p.report(batch)
+			}
+		default:
+			return batch
+		}
+	}
+}
+
+func (p *Pipeline) report(batch []*core.SegmentData) []*core.SegmentData {
+	if len(batch) == 0 {
+		return batch
+	}
+	count := int64(len(batch))
+	if err := p.reporter.Report(batch); err != nil {
+		log.Printf("report %d segments failure: %v", count, err)
+		p.failed.Add(count)
+		failedTotal.Add(count)
+	} else {
+		p.sent.Add(count)
+		sentTotal.Add(count)
+	}
+	return make([]*core.SegmentData, 0, p.opts.BatchSize)
+}
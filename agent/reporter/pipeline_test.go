@@ -0,0 +1,212 @@
+package reporter
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mrproliu/go-agent-instrumentation/framework/core"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testReporter keeps the segments in the memory reporter, and records the sizes of the batches.
+// The reports are blocked until the release is closed when it's set
+type testReporter struct {
+	*MemoryReporter
+	lock    sync.Mutex
+	batches []int
+	closed  bool
+	err     error
+	entered chan struct{}
+	release chan struct{}
+}
+
+func newTestReporter() *testReporter {
+	return &testReporter{MemoryReporter: NewMemoryReporter()}
+}
+
+func (r *testReporter) Report(segments []*core.SegmentData) error {
+	if r.release != nil {
+		r.entered <- struct{}{}
+		<-r.release
+	}
+	r.lock.Lock()
+	r.batches = append(r.batches, len(segments))
+	err := r.err
+	r.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return r.MemoryReporter.Report(segments)
+}
+
+func (r *testReporter) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.closed = true
+	return r.MemoryReporter.Close()
+}
+
+func (r *testReporter) Batches() []int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]int(nil), r.batches...)
+}
+
+func offerSegments(p *Pipeline, count int) {
+	for i := 0; i < count; i++ {
+		p.Offer(&core.SegmentData{TraceID: fmt.Sprintf("trace-%d", i)})
+	}
+}
+
+// waitFor checks the condition until it's true or timeout
+func waitFor(t *testing.T, condition func() bool, format string, args ...interface{}) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !condition(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf(format, args...)
+		}
+	}
+}
+
+func TestPipelineBatching(t *testing.T) {
+	r := newTestReporter()
+	p := NewPipeline(r, Options{BatchSize: 3, FlushInterval: time.Hour})
+	defer p.Shutdown(time.Second)
+
+	offerSegments(p, 7)
+	// the full batches are reported without waiting the interval
+	waitFor(t, func() bool { return len(r.Segments()) == 6 }, "the full batches are not reported: %v", r.Batches())
+	if err := p.Flush(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if batches := r.Batches(); !reflect.DeepEqual(batches, []int{3, 3, 1}) {
+		t.Errorf("the batches: %v", batches)
+	}
+	segments := r.Segments()
+	for i, segment := range segments {
+		if expected := fmt.Sprintf("trace-%d", i); segment.TraceID != expected {
+			t.Errorf("the segment %d: %s, expected: %s", i, segment.TraceID, expected)
+		}
+	}
+	if stats := p.Stats(); stats != (Stats{Sent: 7}) {
+		t.Errorf("the stats: %+v", stats)
+	}
+}
+
+func TestPipelineFlushInterval(t *testing.T) {
+	r := newTestReporter()
+	p := NewPipeline(r, Options{BatchSize: 100, FlushInterval: 10 * time.Millisecond})
+	defer p.Shutdown(time.Second)
+
+	offerSegments(p, 2)
+	waitFor(t, func() bool { return len(r.Segments()) == 2 }, "the segments are not reported by the interval: %v", r.Batches())
+	if stats := p.Stats(); stats.Sent != 2 || stats.Queued != 0 {
+		t.Errorf("the stats: %+v", stats)
+	}
+}
+
+func TestPipelineDropOnFull(t *testing.T) {
+	r := newTestReporter()
+	r.entered, r.release = make(chan struct{}), make(chan struct{})
+	p := NewPipeline(r, Options{QueueSize: 2, BatchSize: 1, FlushInterval: time.Hour})
+
+	// the first segment is reporting and blocked, the queue is filled by the next two
+	offerSegments(p, 1)
+	<-r.entered
+	offerSegments(p, 5)
+	if stats := p.Stats(); stats != (Stats{Queued: 2, Dropped: 3}) {
+		t.Errorf("the stats when the queue is full: %+v", stats)
+	}
+	// the reporting blocks the flush
+	if err := p.Flush(10 * time.Millisecond); err == nil {
+		t.Error("the flush is not timeout")
+	}
+
+	close(r.release)
+	go func() {
+		for range r.entered {
+		}
+	}()
+	if err := p.Shutdown(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if stats := p.Stats(); stats != (Stats{Sent: 3, Dropped: 3}) {
+		t.Errorf("the stats after shutdown: %+v", stats)
+	}
+	close(r.entered)
+}
+
+func TestPipelineReportFailure(t *testing.T) {
+	r := newTestReporter()
+	r.err = errors.New("unavailable")
+	p := NewPipeline(r, Options{BatchSize: 2, FlushInterval: time.Hour})
+	defer p.Shutdown(time.Second)
+
+	offerSegments(p, 3)
+	if err := p.Flush(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if stats := p.Stats(); stats != (Stats{Failed: 3}) || len(r.Segments()) != 0 {
+		t.Errorf("the stats: %+v, reported: %d", stats, len(r.Segments()))
+	}
+}
+
+func TestPipelineShutdown(t *testing.T) {
+	r := newTestReporter()
+	p := NewPipeline(r, Options{BatchSize: 2, FlushInterval: time.Hour})
+
+	offerSegments(p, 5)
+	if err := p.Shutdown(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// the queued segments are reported before closing the reporter
+	if len(r.Segments()) != 5 || !r.closed {
+		t.Errorf("reported: %d, closed: %t", len(r.Segments()), r.closed)
+	}
+
+	offerSegments(p, 1)
+	if stats := p.Stats(); stats != (Stats{Sent: 5, Dropped: 1}) {
+		t.Errorf("the stats after shutdown: %+v", stats)
+	}
+	if err := p.Flush(time.Second); err != ErrShutdown {
+		t.Errorf("flush after shutdown: %v", err)
+	}
+	if err := p.Shutdown(time.Second); err != nil {
+		t.Errorf("shutdown again: %v", err)
+	}
+}
+
+func TestPipelineOfferDuringShutdown(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		r := newTestReporter()
+		p := NewPipeline(r, Options{BatchSize: 10, FlushInterval: time.Hour})
+		// the segments are offered concurrently until the shutdown finished
+		var offered atomic.Int64
+		var stop atomic.Bool
+		var wg sync.WaitGroup
+		for j := 0; j < 4; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for !stop.Load() {
+					p.Offer(&core.SegmentData{})
+					offered.Add(1)
+				}
+			}()
+		}
+		waitFor(t, func() bool { return offered.Load() > 100 }, "the segments are not offered")
+		if err := p.Shutdown(time.Second); err != nil {
+			t.Fatal(err)
+		}
+		stop.Store(true)
+		wg.Wait()
+		// every segment is either reported by the shutdown or dropped
+		stats := p.Stats()
+		if stats.Queued != 0 || stats.Sent+stats.Dropped != offered.Load() || int(stats.Sent) != len(r.Segments()) {
+			t.Fatalf("the stats: %+v, offered: %d, reported: %d", stats, offered.Load(), len(r.Segments()))
+		}
+	}
+}
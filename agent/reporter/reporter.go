@@ -0,0 +1,153 @@
+package reporter
+
+import (
+	"fmt"
+	"github.com/mrproliu/go-agent-instrumentation/framework/core"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// Reporter sends the finished segments, the segments are batched by the Pipeline,
+// so the implementation doesn't need to be goroutine safe
+type Reporter interface {
+	Report(segments []*core.SegmentData) error
+	Close() error
+}
+
+// Config selects the reporter and the options of the pipeline
+type Config struct {
+	Type     string // the registered name of the reporter, such as "memory", "log", "file", "grpc", "otlp"
+	Pipeline Options
+	Log      LogOptions
+	File     FileOptions
+	// the options of the reporters registered outside this package, keyed by the reporter name, such as "grpc",
+	// they are the config sections(such as "reporter.grpc") or the option values, read by DecodeOptions
+	Extra map[string]interface{} `config:"*"`
+
+	Service  string `config:"-"` // the service name of the agent, the default of the reporters
+	Instance string `config:"-"` // the service instance name of the agent, the default of the reporters
+}
+
+// Factory creates the reporter from the config
+type Factory func(cfg *Config) (Reporter, error)
+
+var (
+	factoriesLock sync.RWMutex
+	factories     = make(map[string]Factory)
+	options       = make(map[string]reflect.Type)
+)
+
+func init() {
+	Register("memory", func(cfg *Config) (Reporter, error) {
+		return NewMemoryReporter(), nil
+	})
+	Register("log", func(cfg *Config) (Reporter, error) {
+		return NewLogReporter(cfg.Log), nil
+	})
+	Register("file", func(cfg *Config) (Reporter, error) {
+		return NewFileReporter(cfg.File)
+	})
+}
+
+// Register adds the reporter which could be selected by the name in config
+func Register(name string, factory Factory) {
+	factoriesLock.Lock()
+	defer factoriesLock.Unlock()
+	factories[name] = factory
+}
+
+// Registered returns the names of registered reporters
+func Registered() []string {
+	factoriesLock.RLock()
+	defer factoriesLock.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the reporter by the type in config, the reporters outside this package should be imported
+// to register themselves, such as "github.com/mrproliu/go-agent-instrumentation/agent/reporter/skywalking"
+func New(cfg *Config) (Reporter, error) {
+	factoriesLock.RLock()
+	factory := factories[cfg.Type]
+	factoriesLock.RUnlock()
+	if factory == nil {
+		return nil, fmt.Errorf("unknown reporter type %q, registered: %v", cfg.Type, Registered())
+	}
+	if err := Validate(cfg); err != nil {
+		return nil, err
+	}
+	return factory(cfg)
+}
+
+// DeclareOptions declares the type of the options of the reporter registered outside this package,
+// so the config section of it could be checked by Validate before creating the reporter
+func DeclareOptions(name string, defaults interface{}) {
+	factoriesLock.Lock()
+	defer factoriesLock.Unlock()
+	options[name] = reflect.TypeOf(defaults)
+}
+
+// Validate checks the options in the Extra are the sections of the registered reporters, and could be decoded
+func Validate(cfg *Config) error {
+	names := make([]string, 0, len(cfg.Extra))
+	for name := range cfg.Extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		factoriesLock.RLock()
+		factory, typ := factories[name], options[name]
+		factoriesLock.RUnlock()
+		if factory == nil {
+			return fmt.Errorf("unknown config reporter.%s, registered: %v", name, Registered())
+		}
+		if typ == nil {
+			continue
+		}
+		if err := DecodeOptions(cfg, name, reflect.New(typ).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DecodeOptions fills the options(pointer of struct) of the reporter registered outside this package.
+// The option value of the same type in the Extra is used directly, otherwise the config section is decoded
+// into it, and could be overridden by the environment variables "SW_AGENT_REPORTER_<NAME>_<KEY>"
+func DecodeOptions(cfg *Config, name string, target interface{}) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("the options of reporter %s should be the pointer of struct: %T", name, target)
+	}
+	value := cfg.Extra[name]
+	if option := reflect.ValueOf(value); option.IsValid() && option.Type() == v.Elem().Type() {
+		v.Elem().Set(option)
+		return nil
+	}
+	// decoded as the field of the reporter section, so the keys and environment variables are same with the other options
+	field := reflect.StructOf([]reflect.StructField{{Name: "Options", Type: v.Elem().Type(), Tag: reflect.StructTag(`config:"` + name + `"`)}})
+	section := reflect.New(reflect.StructOf([]reflect.StructField{{Name: "Reporter", Type: field}}))
+	section.Elem().Field(0).Field(0).Set(v.Elem())
+	if err := core.DecodeConfig(map[string]interface{}{"reporter": map[string]interface{}{name: value}}, "SW_AGENT", section.Interface()); err != nil {
+		return err
+	}
+	v.Elem().Set(section.Elem().Field(0).Field(0))
+	return nil
+}
+
+// Start creates the reporter and the pipeline by config, the segments of all enhanced packages are reported by them.
+// Shutdown the pipeline when the application exits, so the queued segments are flushed
+func Start(cfg *Config) (*Pipeline, error) {
+	r, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	p := NewPipeline(r, cfg.Pipeline)
+	core.SetSegmentReporter(p.Offer)
+	return p, nil
+}
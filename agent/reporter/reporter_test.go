@@ -0,0 +1,89 @@
+package reporter
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testOptions struct {
+	Address string
+	Timeout time.Duration
+}
+
+func init() {
+	DeclareOptions("test", testOptions{})
+	Register("test", func(cfg *Config) (Reporter, error) {
+		var opts testOptions
+		if err := DecodeOptions(cfg, "test", &opts); err != nil {
+			return nil, err
+		}
+		return NewMemoryReporter(), nil
+	})
+}
+
+func TestDecodeOptions(t *testing.T) {
+	t.Setenv("SW_AGENT_REPORTER_TEST_ADDRESS", "env:11800")
+	cfg := &Config{Type: "test", Extra: map[string]interface{}{"test": map[string]interface{}{"address": "yaml:11800", "timeout": "3s"}}}
+	opts := testOptions{Timeout: time.Second}
+	if err := DecodeOptions(cfg, "test", &opts); err != nil {
+		t.Fatal(err)
+	}
+	if opts != (testOptions{Address: "env:11800", Timeout: 3 * time.Second}) {
+		t.Errorf("the options decoded from the section: %+v", opts)
+	}
+	// the option value is used as it is
+	cfg.Extra["test"] = testOptions{Address: "value:11800"}
+	if err := DecodeOptions(cfg, "test", &opts); err != nil || opts != (testOptions{Address: "value:11800"}) {
+		t.Errorf("the options from the value: %+v, error: %v", opts, err)
+	}
+	// only the environment variables without the section
+	opts = testOptions{}
+	if err := DecodeOptions(&Config{}, "test", &opts); err != nil || opts != (testOptions{Address: "env:11800"}) {
+		t.Errorf("the options from the environment variables: %+v, error: %v", opts, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := map[string]map[string]interface{}{
+		"":                                       {"test": map[string]interface{}{"address": "oap:11800"}, "log": nil},
+		"unknown config reporter.test.addr":      {"test": map[string]interface{}{"addr": "oap:11800"}},
+		"config reporter.test.timeout":           {"test": map[string]interface{}{"timeout": 3}},
+		"config reporter.test should be a map":   {"test": "oap:11800"},
+		"unknown config reporter.tset":           {"tset": map[string]interface{}{}},
+		"unknown reporter type \"unregistered\"": nil,
+	}
+	for expected, extra := range tests {
+		cfg := &Config{Type: "test", Extra: extra}
+		if strings.Contains(expected, "unregistered") {
+			cfg.Type = "unregistered"
+		}
+		r, err := New(cfg)
+		if expected == "" {
+			if err != nil {
+				t.Errorf("%v: %v", extra, err)
+			} else {
+				r.Close()
+			}
+			continue
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), expected) {
+			t.Errorf("%v: the error %v, expected: %s", extra, err, expected)
+		}
+	}
+}
+
+// TestDependencies checks the reporters outside this package are never linked unless imported
+func TestDependencies(t *testing.T) {
+	output, err := exec.Command("go", "list", "-deps", ".").CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, output)
+	}
+	for _, pkg := range strings.Fields(string(output)) {
+		if strings.HasPrefix(pkg, "google.golang.org/") || strings.HasPrefix(pkg, "go.opentelemetry.io/") ||
+			strings.HasPrefix(pkg, "github.com/mrproliu/go-agent-instrumentation/agent/reporter/") {
+			t.Errorf("the reporter package depends on %s", pkg)
+		}
+	}
+}
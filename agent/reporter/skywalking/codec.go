@@ -0,0 +1,30 @@
+package skywalking
+
+import "fmt"
+
+// rawCodec passes the encoded protobuf messages through the gRPC, so no generated code is required
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch m := v.(type) {
+	case []byte:
+		return m, nil
+	case *[]byte:
+		return *m, nil
+	}
+	return nil, fmt.Errorf("unsupported message type %T", v)
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("unsupported message type %T", v)
+	}
+	*m = append((*m)[:0], data...)
+	return nil
+}
+
+// keep the content type as "application/grpc+proto"
+func (rawCodec) Name() string {
+	return "proto"
+}
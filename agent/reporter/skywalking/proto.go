@@ -0,0 +1,126 @@
+package skywalking
+
+import (
+	"github.com/mrproliu/go-agent-instrumentation/framework/core"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// the messages of the SkyWalking v3 protocol(apache/skywalking-data-collect-protocol), encoded by the field numbers
+
+// SegmentObject in language-agent/Tracing.proto
+func encodeSegment(segment *core.SegmentData, service, instance string) []byte {
+	var b []byte
+	b = appendString(b, 1, segment.TraceID)
+	b = appendString(b, 2, segment.SegmentID)
+	for _, span := range segment.Spans {
+		var refs []core.SegmentRef
+		if span.ParentSpanID < 0 {
+			// the refs of segment are kept by its first span
+			refs = segment.Refs
+		}
+		b = appendMessage(b, 3, encodeSpan(span, refs))
+	}
+	b = appendString(b, 4, service)
+	b = appendString(b, 5, instance)
+	return b
+}
+
+// SpanObject in language-agent/Tracing.proto
+func encodeSpan(span *core.SpanData, refs []core.SegmentRef) []byte {
+	var b []byte
+	b = appendInt(b, 1, int64(span.SpanID))
+	b = appendInt(b, 2, int64(span.ParentSpanID))
+	b = appendInt(b, 3, span.StartTime.UnixMilli())
+	b = appendInt(b, 4, span.EndTime.UnixMilli())
+	for i := range refs {
+		b = appendMessage(b, 5, encodeSegmentRef(&refs[i]))
+	}
+	b = appendString(b, 6, span.OperationName)
+	b = appendString(b, 7, span.Peer)
+	b = appendInt(b, 8, int64(span.Kind))
+	b = appendInt(b, 9, int64(span.Layer))
+	b = appendInt(b, 10, int64(span.ComponentID))
+	b = appendBool(b, 11, span.IsError)
+	for _, tag := range span.Tags {
+		b = appendMessage(b, 12, encodeKeyValue(tag.Key, tag.Value))
+	}
+	for _, log := range span.Logs {
+		var l []byte
+		l = appendInt(l, 1, log.Time.UnixMilli())
+		for _, field := range log.Fields {
+			l = appendMessage(l, 2, encodeKeyValue(field.Key, field.Value))
+		}
+		b = appendMessage(b, 13, l)
+	}
+	return b
+}
+
+// SegmentReference in language-agent/Tracing.proto
+func encodeSegmentRef(ref *core.SegmentRef) []byte {
+	var b []byte
+	b = appendInt(b, 1, int64(ref.RefType))
+	b = appendString(b, 2, ref.TraceID)
+	b = appendString(b, 3, ref.ParentSegmentID)
+	b = appendInt(b, 4, int64(ref.ParentSpanID))
+	b = appendString(b, 5, ref.ParentService)
+	b = appendString(b, 6, ref.ParentServiceInstance)
+	b = appendString(b, 7, ref.ParentEndpoint)
+	b = appendString(b, 8, ref.NetworkAddress)
+	return b
+}
+
+// InstanceProperties in management/Management.proto
+func encodeInstanceProperties(service, instance string, properties []core.Tag) []byte {
+	var b []byte
+	b = appendString(b, 1, service)
+	b = appendString(b, 2, instance)
+	for _, p := range properties {
+		b = appendMessage(b, 3, encodeKeyValue(p.Key, p.Value))
+	}
+	return b
+}
+
+// InstancePingPkg in management/Management.proto
+func encodeInstancePing(service, instance string) []byte {
+	var b []byte
+	b = appendString(b, 1, service)
+	b = appendString(b, 2, instance)
+	return b
+}
+
+// KeyStringValuePair in common/Common.proto
+func encodeKeyValue(key, value string) []byte {
+	var b []byte
+	b = appendString(b, 1, key)
+	b = appendString(b, 2, value)
+	return b
+}
+
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendInt(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+func appendBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, protowire.EncodeBool(v))
+}
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
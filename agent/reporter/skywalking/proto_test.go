@@ -0,0 +1,145 @@
+package skywalking
+
+import (
+	"encoding/json"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"testing"
+)
+
+// the messages of apache/skywalking-data-collect-protocol(common/Common.proto, language-agent/Tracing.proto
+// and management/Management.proto) as the descriptors, so the test decodes the messages by the protobuf runtime
+// instead of the assumptions of the encoder
+var protocolFile = func() protoreflect.FileDescriptor {
+	const (
+		str    = descriptorpb.FieldDescriptorProto_TYPE_STRING
+		int32_ = descriptorpb.FieldDescriptorProto_TYPE_INT32
+		int64_ = descriptorpb.FieldDescriptorProto_TYPE_INT64
+		bool_  = descriptorpb.FieldDescriptorProto_TYPE_BOOL
+		enum   = descriptorpb.FieldDescriptorProto_TYPE_ENUM
+		msg    = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+	)
+	field := func(name string, num int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string, repeated bool) *descriptorpb.FieldDescriptorProto {
+		label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+		if repeated {
+			label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+		}
+		f := &descriptorpb.FieldDescriptorProto{Name: proto.String(name), JsonName: proto.String(name), Number: proto.Int32(num), Type: typ.Enum(), Label: label.Enum()}
+		if typeName != "" {
+			f.TypeName = proto.String(".skywalking.v3." + typeName)
+		}
+		return f
+	}
+	message := func(name string, fields ...*descriptorpb.FieldDescriptorProto) *descriptorpb.DescriptorProto {
+		return &descriptorpb.DescriptorProto{Name: proto.String(name), Field: fields}
+	}
+	enumType := func(name string, values ...string) *descriptorpb.EnumDescriptorProto {
+		e := &descriptorpb.EnumDescriptorProto{Name: proto.String(name)}
+		for i, value := range values {
+			e.Value = append(e.Value, &descriptorpb.EnumValueDescriptorProto{Name: proto.String(value), Number: proto.Int32(int32(i))})
+		}
+		return e
+	}
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("skywalking.proto"),
+		Package: proto.String("skywalking.v3"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			message("KeyStringValuePair", field("key", 1, str, "", false), field("value", 2, str, "", false)),
+			message("SegmentObject",
+				field("traceId", 1, str, "", false),
+				field("traceSegmentId", 2, str, "", false),
+				field("spans", 3, msg, "SpanObject", true),
+				field("service", 4, str, "", false),
+				field("serviceInstance", 5, str, "", false),
+				field("isSizeLimited", 6, bool_, "", false)),
+			message("SegmentReference",
+				field("refType", 1, enum, "RefType", false),
+				field("traceId", 2, str, "", false),
+				field("parentTraceSegmentId", 3, str, "", false),
+				field("parentSpanId", 4, int32_, "", false),
+				field("parentService", 5, str, "", false),
+				field("parentServiceInstance", 6, str, "", false),
+				field("parentEndpoint", 7, str, "", false),
+				field("networkAddressUsedAtPeer", 8, str, "", false)),
+			message("SpanObject",
+				field("spanId", 1, int32_, "", false),
+				field("parentSpanId", 2, int32_, "", false),
+				field("startTime", 3, int64_, "", false),
+				field("endTime", 4, int64_, "", false),
+				field("refs", 5, msg, "SegmentReference", true),
+				field("operationName", 6, str, "", false),
+				field("peer", 7, str, "", false),
+				field("spanType", 8, enum, "SpanType", false),
+				field("spanLayer", 9, enum, "SpanLayer", false),
+				field("componentId", 10, int32_, "", false),
+				field("isError", 11, bool_, "", false),
+				field("tags", 12, msg, "KeyStringValuePair", true),
+				field("logs", 13, msg, "Log", true),
+				field("skipAnalysis", 14, bool_, "", false)),
+			message("Log", field("time", 1, int64_, "", false), field("data", 2, msg, "KeyStringValuePair", true)),
+			message("InstanceProperties",
+				field("service", 1, str, "", false),
+				field("serviceInstance", 2, str, "", false),
+				field("properties", 3, msg, "KeyStringValuePair", true),
+				field("layer", 4, str, "", false)),
+			message("InstancePingPkg",
+				field("service", 1, str, "", false),
+				field("serviceInstance", 2, str, "", false),
+				field("layer", 3, str, "", false)),
+		},
+		EnumType: []*descriptorpb.EnumDescriptorProto{
+			enumType("SpanType", "Entry", "Exit", "Local"),
+			enumType("RefType", "CrossProcess", "CrossThread"),
+			enumType("SpanLayer", "Unknown", "Database", "RPCFramework", "Http", "MQ", "Cache", "FAAS"),
+		},
+	}
+	result, err := protodesc.NewFile(file, nil)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}()
+
+// decodeMessage decodes the message of the protocol as the JSON object, the unknown fields are rejected
+func decodeMessage(t *testing.T, name string, data []byte) map[string]interface{} {
+	t.Helper()
+	m := dynamicpb.NewMessage(protocolFile.Messages().ByName(protoreflect.Name(name)))
+	if err := proto.Unmarshal(data, m); err != nil {
+		t.Fatalf("decode %s failure: %v", name, err)
+	}
+	checkUnknownFields(t, name, m)
+	content, err := protojson.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make(map[string]interface{})
+	if err := json.Unmarshal(content, &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func checkUnknownFields(t *testing.T, path string, m protoreflect.Message) {
+	t.Helper()
+	if len(m.GetUnknown()) > 0 {
+		t.Errorf("the unknown fields in %s: %v", path, m.GetUnknown())
+	}
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if fd.Kind() != protoreflect.MessageKind {
+			return true
+		}
+		if fd.IsList() {
+			for i := 0; i < v.List().Len(); i++ {
+				checkUnknownFields(t, path+"."+string(fd.Name()), v.List().Get(i).Message())
+			}
+		} else {
+			checkUnknownFields(t, path+"."+string(fd.Name()), v.Message())
+		}
+		return true
+	})
+}
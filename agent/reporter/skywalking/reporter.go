@@ -0,0 +1,272 @@
+package skywalking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mrproliu/go-agent-instrumentation/agent/reporter"
+	"github.com/mrproliu/go-agent-instrumentation/framework/core"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	collectMethod    = "/skywalking.v3.TraceSegmentReportService/collect"
+	propertiesMethod = "/skywalking.v3.ManagementService/reportInstanceProperties"
+	keepAliveMethod  = "/skywalking.v3.ManagementService/keepAlive"
+)
+
+type Options struct {
+	BackendAddress    string        // the gRPC address of the OAP, default "127.0.0.1:11800"
+	Service           string        // the service name, default the name of the executable
+	Instance          string        // the service instance name, default "<pid>@<hostname>"
+	Authentication    string        // the token for the OAP authentication, optional
+	KeepAliveInterval time.Duration // the interval of the instance heartbeat, default 20s
+	Timeout           time.Duration // the timeout of each request, default 10s
+	MinBackoff        time.Duration // the wait time after the first failure, doubled on every failure, default 1s
+	MaxBackoff        time.Duration // default 30s
+	// the max count of the segments kept while the OAP is unavailable, they are sent with the next batch after reconnected.
+	// The batch which couldn't be kept is failed, default 1000
+	RetryBufferSize int
+}
+
+// Reporter sends the segments to the SkyWalking OAP by the v3 gRPC protocol,
+// and keeps the instance alive by the management service
+type Reporter struct {
+	opts Options
+	conn *grpc.ClientConn
+
+	lock               sync.Mutex
+	backoff            time.Duration
+	retryAt            time.Time
+	propertiesReported bool
+
+	pending []*core.SegmentData // the segments waiting for retry, only accessed by Report and Close
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+var errBackoff = errors.New("the OAP is unavailable, waiting for reconnect")
+
+func init() {
+	reporter.DeclareOptions("grpc", Options{})
+	reporter.Register("grpc", func(cfg *reporter.Config) (reporter.Reporter, error) {
+		var opts Options
+		if err := reporter.DecodeOptions(cfg, "grpc", &opts); err != nil {
+			return nil, err
+		}
+		if opts.Service == "" {
+			opts.Service = cfg.Service
+		}
+		if opts.Instance == "" {
+			opts.Instance = cfg.Instance
+		}
+		return New(opts)
+	})
+}
+
+func New(opts Options) (*Reporter, error) {
+	if opts.BackendAddress == "" {
+		opts.BackendAddress = "127.0.0.1:11800"
+	}
+	if opts.Service == "" {
+		opts.Service = filepath.Base(os.Args[0])
+	}
+	if opts.Instance == "" {
+		hostname, _ := os.Hostname()
+		opts.Instance = fmt.Sprintf("%d@%s", os.Getpid(), hostname)
+	}
+	if opts.KeepAliveInterval <= 0 {
+		opts.KeepAliveInterval = 20 * time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = time.Second
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = 30 * time.Second
+	}
+	if opts.RetryBufferSize <= 0 {
+		opts.RetryBufferSize = 1000
+	}
+	// the connection reconnects by itself, the failed requests are retried after the backoff
+	conn, err := grpc.Dial(opts.BackendAddress,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(rawCodec{})))
+	if err != nil {
+		return nil, err
+	}
+	r := &Reporter{
+		opts: opts,
+		conn: conn,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go r.keepAlive()
+	return r, nil
+}
+
+// Report sends the segments with the ones waiting for retry. The segments are kept for retry when the OAP is unavailable,
+// so they survive the restart of the OAP, and they may be sent twice if the OAP received part of the failed request
+func (r *Reporter) Report(segments []*core.SegmentData) error {
+	err := errBackoff
+	if r.ready() {
+		if err = r.send(append(r.pending[:len(r.pending):len(r.pending)], segments...)); err == nil {
+			r.pending = nil
+			return nil
+		}
+	}
+	if len(r.pending)+len(segments) > r.opts.RetryBufferSize {
+		return err
+	}
+	r.pending = append(r.pending, segments...)
+	return nil
+}
+
+// Close sends the segments waiting for retry if the OAP is available
+func (r *Reporter) Close() error {
+	close(r.stop)
+	<-r.done
+	if len(r.pending) > 0 && r.ready() {
+		if err := r.send(r.pending); err != nil {
+			log.Printf("report %d segments to %s failure when closing: %v", len(r.pending), r.opts.BackendAddress, err)
+		}
+		r.pending = nil
+	}
+	return r.conn.Close()
+}
+
+func (r *Reporter) send(segments []*core.SegmentData) error {
+	ctx, cancel := r.context()
+	defer cancel()
+	err := r.collect(ctx, segments)
+	r.updateBackoff(err)
+	return err
+}
+
+func (r *Reporter) collect(ctx context.Context, segments []*core.SegmentData) error {
+	stream, err := r.conn.NewStream(ctx, &grpc.StreamDesc{ClientStreams: true}, collectMethod)
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if err := stream.SendMsg(encodeSegment(segment, r.opts.Service, r.opts.Instance)); err != nil {
+			return err
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+	var commands []byte
+	return stream.RecvMsg(&commands)
+}
+
+func (r *Reporter) keepAlive() {
+	defer close(r.done)
+	ticker := time.NewTicker(r.opts.KeepAliveInterval)
+	defer ticker.Stop()
+	for {
+		r.heartbeat()
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// heartbeat reports the instance properties before the first keep alive, and after the OAP is reconnected
+func (r *Reporter) heartbeat() {
+	if !r.ready() {
+		return
+	}
+	ctx, cancel := r.context()
+	defer cancel()
+	var commands []byte
+	r.lock.Lock()
+	reported := r.propertiesReported
+	r.lock.Unlock()
+	if !reported {
+		err := r.conn.Invoke(ctx, propertiesMethod, encodeInstanceProperties(r.opts.Service, r.opts.Instance, instanceProperties()), &commands)
+		r.updateBackoff(err)
+		if err != nil {
+			log.Printf("report the instance properties to %s failure: %v", r.opts.BackendAddress, err)
+			return
+		}
+		r.lock.Lock()
+		r.propertiesReported = true
+		r.lock.Unlock()
+	}
+	err := r.conn.Invoke(ctx, keepAliveMethod, encodeInstancePing(r.opts.Service, r.opts.Instance), &commands)
+	r.updateBackoff(err)
+}
+
+func (r *Reporter) context() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.opts.Timeout)
+	if r.opts.Authentication != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authentication", r.opts.Authentication)
+	}
+	return ctx, cancel
+}
+
+// ready checks the backoff is passed
+func (r *Reporter) ready() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.retryAt.IsZero() || !time.Now().Before(r.retryAt)
+}
+
+// updateBackoff updates the backoff by the result of the request
+func (r *Reporter) updateBackoff(err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err == nil {
+		r.backoff = 0
+		r.retryAt = time.Time{}
+		return
+	}
+	if r.backoff == 0 {
+		r.backoff = r.opts.MinBackoff
+	} else if r.backoff *= 2; r.backoff > r.opts.MaxBackoff {
+		r.backoff = r.opts.MaxBackoff
+	}
+	r.retryAt = time.Now().Add(r.backoff)
+	// the OAP may be restarted, report the properties again
+	r.propertiesReported = false
+}
+
+func instanceProperties() []core.Tag {
+	hostname, _ := os.Hostname()
+	properties := []core.Tag{
+		{Key: "language", Value: "go"},
+		{Key: "OS Name", Value: runtime.GOOS},
+		{Key: "hostname", Value: hostname},
+		{Key: "Process No.", Value: strconv.Itoa(os.Getpid())},
+		{Key: "Go Version", Value: runtime.Version()},
+		{Key: "Agent Version", Value: core.AgentVersion()},
+	}
+	if info := core.BuildInfo(); info != nil {
+		properties = append(properties, core.Tag{Key: "Agent Plugins", Value: info["plugins"]},
+			core.Tag{Key: "Toolexec Identity", Value: info["toolexec.identity"]})
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ip, ok := addr.(*net.IPNet); ok && !ip.IP.IsLoopback() && ip.IP.To4() != nil {
+				properties = append(properties, core.Tag{Key: "ipv4", Value: ip.IP.String()})
+			}
+		}
+	}
+	return properties
+}
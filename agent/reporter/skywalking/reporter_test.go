@@ -0,0 +1,318 @@
+package skywalking
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/mrproliu/go-agent-instrumentation/framework/core"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/metadata"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeOAP receives the raw messages of the SkyWalking v3 protocol
+type fakeOAP struct {
+	server   *grpc.Server
+	listener net.Listener
+
+	lock       sync.Mutex
+	segments   [][]byte
+	properties [][]byte
+	pings      [][]byte
+	tokens     []string
+}
+
+func startFakeOAP(t *testing.T, address string) *fakeOAP {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oap := &fakeOAP{listener: listener, server: grpc.NewServer(grpc.ForceServerCodec(rawCodec{}))}
+	unary := func(received *[][]byte) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+		return func(_ interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+			var msg []byte
+			if err := dec(&msg); err != nil {
+				return nil, err
+			}
+			oap.record(ctx, received, msg)
+			return []byte{}, nil
+		}
+	}
+	oap.server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "skywalking.v3.ManagementService",
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{
+			{MethodName: "reportInstanceProperties", Handler: unary(&oap.properties)},
+			{MethodName: "keepAlive", Handler: unary(&oap.pings)},
+		},
+	}, struct{}{})
+	oap.server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "skywalking.v3.TraceSegmentReportService",
+		HandlerType: (*interface{})(nil),
+		Streams: []grpc.StreamDesc{{
+			StreamName:    "collect",
+			ClientStreams: true,
+			Handler: func(_ interface{}, stream grpc.ServerStream) error {
+				for {
+					var msg []byte
+					if err := stream.RecvMsg(&msg); err != nil {
+						return stream.SendMsg([]byte{})
+					}
+					oap.record(stream.Context(), &oap.segments, msg)
+				}
+			},
+		}},
+	}, struct{}{})
+	go oap.server.Serve(listener)
+	return oap
+}
+
+func (o *fakeOAP) record(ctx context.Context, received *[][]byte, msg []byte) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	*received = append(*received, msg)
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		o.tokens = append(o.tokens, md.Get("authentication")...)
+	}
+}
+
+func (o *fakeOAP) received() (segments, properties, pings [][]byte, tokens []string) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.segments, o.properties, o.pings, o.tokens
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func testSegment() *core.SegmentData {
+	now := time.UnixMilli(1700000000000)
+	return &core.SegmentData{
+		TraceID:   "trace-1",
+		SegmentID: "segment-1",
+		Refs: []core.SegmentRef{{
+			RefType: core.SegmentRefCrossProcess, TraceID: "trace-1", ParentSegmentID: "parent-segment", ParentSpanID: 2,
+			ParentService: "upstream", ParentServiceInstance: "upstream-1", ParentEndpoint: "GET:/", NetworkAddress: "127.0.0.1:8080",
+		}},
+		Spans: []*core.SpanData{
+			{SpanID: 1, ParentSpanID: 0, OperationName: "redis/get", Peer: "127.0.0.1:6379", Kind: core.SpanKindExit,
+				Layer: core.SpanLayerCache, StartTime: now, EndTime: now, IsError: true,
+				Logs: []core.LogData{{Time: now, Fields: []core.Tag{{Key: "event", Value: "error"}}}}},
+			{SpanID: 0, ParentSpanID: -1, OperationName: "GET:/users", Kind: core.SpanKindEntry, Layer: core.SpanLayerHttp,
+				ComponentID: core.ComponentIDGin, StartTime: now.Add(-time.Second), EndTime: now,
+				Tags: []core.Tag{{Key: "status_code", Value: "200"}}},
+		},
+	}
+}
+
+// the JSON of the testSegment decoded as the SegmentObject, the zero values are omitted
+const expectedSegment = `{
+  "traceId": "trace-1",
+  "traceSegmentId": "segment-1",
+  "service": "svc",
+  "serviceInstance": "inst",
+  "spans": [{
+    "spanId": 1,
+    "startTime": "1700000000000",
+    "endTime": "1700000000000",
+    "operationName": "redis/get",
+    "peer": "127.0.0.1:6379",
+    "spanType": "Exit",
+    "spanLayer": "Cache",
+    "isError": true,
+    "logs": [{"time": "1700000000000", "data": [{"key": "event", "value": "error"}]}]
+  }, {
+    "parentSpanId": -1,
+    "startTime": "1699999999000",
+    "endTime": "1700000000000",
+    "refs": [{
+      "traceId": "trace-1",
+      "parentTraceSegmentId": "parent-segment",
+      "parentSpanId": 2,
+      "parentService": "upstream",
+      "parentServiceInstance": "upstream-1",
+      "parentEndpoint": "GET:/",
+      "networkAddressUsedAtPeer": "127.0.0.1:8080"
+    }],
+    "operationName": "GET:/users",
+    "spanLayer": "Http",
+    "componentId": 5006,
+    "tags": [{"key": "status_code", "value": "200"}]
+  }]
+}`
+
+func TestReportSegments(t *testing.T) {
+	oap := startFakeOAP(t, "127.0.0.1:0")
+	defer oap.server.Stop()
+	r, err := New(Options{BackendAddress: oap.listener.Addr().String(), Service: "svc", Instance: "inst", Authentication: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if err := r.Report([]*core.SegmentData{testSegment(), testSegment()}); err != nil {
+		t.Fatal(err)
+	}
+	segments, _, _, tokens := oap.received()
+	if len(segments) != 2 {
+		t.Fatalf("expected 2 segments, got %d", len(segments))
+	}
+	var expected map[string]interface{}
+	if err := json.Unmarshal([]byte(expectedSegment), &expected); err != nil {
+		t.Fatal(err)
+	}
+	// the refs of segment are in its first span
+	if segment := decodeMessage(t, "SegmentObject", segments[0]); !reflect.DeepEqual(segment, expected) {
+		content, _ := json.Marshal(segment)
+		t.Fatalf("the segment: %s", content)
+	}
+	if len(tokens) == 0 || tokens[0] != "token" {
+		t.Fatalf("the authentication is not sent: %v", tokens)
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	oap := startFakeOAP(t, "127.0.0.1:0")
+	defer oap.server.Stop()
+	r, err := New(Options{BackendAddress: oap.listener.Addr().String(), Service: "svc", Instance: "inst", KeepAliveInterval: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	waitFor(t, func() bool {
+		_, _, pings, _ := oap.received()
+		return len(pings) >= 2
+	})
+	_, properties, pings, _ := oap.received()
+	if len(properties) != 1 {
+		t.Fatalf("the properties should be reported once, got %d", len(properties))
+	}
+	instance := decodeMessage(t, "InstanceProperties", properties[0])
+	if instance["service"] != "svc" || instance["serviceInstance"] != "inst" {
+		t.Fatalf("unexpected instance properties: %v", instance)
+	}
+	keys := make(map[string]bool)
+	for _, p := range instance["properties"].([]interface{}) {
+		keys[p.(map[string]interface{})["key"].(string)] = true
+	}
+	if !keys["language"] || !keys["hostname"] || !keys["Process No."] {
+		t.Fatalf("unexpected properties: %v", keys)
+	}
+	if ping := decodeMessage(t, "InstancePingPkg", pings[0]); !reflect.DeepEqual(ping, map[string]interface{}{"service": "svc", "serviceInstance": "inst"}) {
+		t.Fatalf("unexpected ping: %v", ping)
+	}
+}
+
+// reportTrace reports a segment of the trace
+func reportTrace(r *Reporter, traceID string) error {
+	segment := testSegment()
+	segment.TraceID = traceID
+	return r.Report([]*core.SegmentData{segment})
+}
+
+// receivedTraces returns the trace ids of the segments received by the OAP
+func receivedTraces(t *testing.T, oap *fakeOAP) []string {
+	segments, _, _, _ := oap.received()
+	result := make([]string, 0, len(segments))
+	for _, segment := range segments {
+		result = append(result, decodeMessage(t, "SegmentObject", segment)["traceId"].(string))
+	}
+	return result
+}
+
+// waitReconnected waits until the backoff is passed and the connection is reconnected
+func waitReconnected(t *testing.T, r *Reporter) {
+	waitFor(t, func() bool {
+		r.conn.Connect()
+		return r.ready() && r.conn.GetState() == connectivity.Ready
+	})
+}
+
+func TestReconnectWithBackoff(t *testing.T) {
+	// reserve an address, the OAP starts later
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	r, err := New(Options{BackendAddress: address, Timeout: 200 * time.Millisecond, KeepAliveInterval: time.Hour,
+		MinBackoff: 300 * time.Millisecond, MaxBackoff: time.Second, RetryBufferSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// the failed segments and the segments during the backoff are kept until the buffer is full
+	if err := reportTrace(r, "trace-1"); err != nil {
+		t.Fatalf("the failed segment is not kept: %v", err)
+	}
+	if r.ready() {
+		t.Fatal("the report should wait for the backoff after failure")
+	}
+	if err := reportTrace(r, "trace-2"); err != nil {
+		t.Fatalf("the segment during the backoff is not kept: %v", err)
+	}
+	if err := reportTrace(r, "trace-3"); !errors.Is(err, errBackoff) {
+		t.Fatalf("the report should fail when the buffer is full, got %v", err)
+	}
+
+	oap := startFakeOAP(t, address)
+	waitReconnected(t, r)
+	if err := reportTrace(r, "trace-4"); err != nil {
+		t.Fatal(err)
+	}
+	if traces := receivedTraces(t, oap); !reflect.DeepEqual(traces, []string{"trace-1", "trace-2", "trace-4"}) {
+		t.Fatalf("the segments after reconnected: %v", traces)
+	}
+	r.lock.Lock()
+	if r.backoff != 0 || !r.retryAt.IsZero() || len(r.pending) != 0 {
+		t.Fatal("the backoff and the buffer should be reset after reconnected")
+	}
+	r.lock.Unlock()
+
+	// the segments survive the restart of the OAP
+	oap.server.Stop()
+	if err := reportTrace(r, "trace-5"); err != nil {
+		t.Fatalf("the segment is not kept when the OAP is restarting: %v", err)
+	}
+	oap = startFakeOAP(t, address)
+	defer oap.server.Stop()
+	waitReconnected(t, r)
+	if err := reportTrace(r, "trace-6"); err != nil {
+		t.Fatal(err)
+	}
+	if traces := receivedTraces(t, oap); !reflect.DeepEqual(traces, []string{"trace-5", "trace-6"}) {
+		t.Fatalf("the segments after the OAP restarted: %v", traces)
+	}
+}
+
+func TestCloseSendsPending(t *testing.T) {
+	oap := startFakeOAP(t, "127.0.0.1:0")
+	defer oap.server.Stop()
+	r, err := New(Options{BackendAddress: oap.listener.Addr().String(), KeepAliveInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.pending = []*core.SegmentData{testSegment()}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if traces := receivedTraces(t, oap); !reflect.DeepEqual(traces, []string{"trace-1"}) {
+		t.Fatalf("the segments sent when closing: %v", traces)
+	}
+}
@@ -0,0 +1,340 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the global names of the plugin config sections and the listeners decode them
+const (
+	pluginConfigGlobal          = "plugin-config"
+	pluginConfigListenersGlobal = "plugin-config-listeners"
+)
+
+// the prefix of the environment variables of the agent config
+const configEnvPrefix = "SW_AGENT"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// DecodeConfig fills the target(pointer of struct) by the values decoded from the YAML and the environment variables,
+// the environment variables take precedence, the fields not found keep the values of target as the defaults.
+//
+// The key of a field is the snake case of its name(such as "backend_address"), or the name in the `config` tag,
+// "-" to ignore the field, "*" to keep the values of the keys not matched any field in the map field. The environment variable is the prefix and the keys of the field path in upper case,
+// such as "SW_AGENT_REPORTER_GRPC_BACKEND_ADDRESS", the slice and map in it are "a,b" and "k1=v1,k2=v2", empty is ignored.
+// The keys not matched any field are rejected, so the misspelled keys never fall back to the defaults silently
+func DecodeConfig(values map[string]interface{}, envPrefix string, target interface{}) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("the config should be the pointer of struct: %T", target)
+	}
+	return decodeConfigStruct(values, envPrefix, v.Elem(), "")
+}
+
+// SetPluginConfig changes the config sections of the plugins("plugins.<plugin>" in the agent config),
+// the configs declared by the plugins are decoded again. Nothing is changed when any section is invalid
+func SetPluginConfig(sections map[string]map[string]interface{}) error {
+	apply, err := PreparePluginConfig(sections)
+	if err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+// PreparePluginConfig decodes the configs declared by the plugins from the sections, and returns the function applies them.
+// So the agent could validate all the changed options before applying any of them
+func PreparePluginConfig(sections map[string]map[string]interface{}) (apply func(), err error) {
+	listeners, _ := globalValue(pluginConfigListenersGlobal).([]func(map[string]map[string]interface{}) (func(), error))
+	applies := make([]func(), 0, len(listeners))
+	for _, listener := range listeners {
+		decoded, err := listener(sections)
+		if err != nil {
+			return nil, err
+		}
+		applies = append(applies, decoded)
+	}
+	return func() {
+		setGlobalValue(pluginConfigGlobal, sections)
+		for _, decoded := range applies {
+			decoded()
+		}
+	}, nil
+}
+
+// PluginConfig is the config declared by the plugin, the value is replaced as a whole when the agent changes the config,
+// so the interceptors should read it by Get in every invocation instead of keeping it
+type PluginConfig struct {
+	plugin string
+	value  atomic.Value
+
+	lock      sync.Mutex
+	listeners []func()
+}
+
+// DeclarePluginConfig declares the config of the plugin, the defaults is the pointer of struct with the default values.
+// It's decoded from the config section of the plugin and the environment variables "SW_AGENT_PLUGIN_<PLUGIN>_<KEY>",
+// and decoded again when the agent changes the config sections, so it should be invoked when the package initializing
+func DeclarePluginConfig(plugin string, defaults interface{}) *PluginConfig {
+	v := reflect.ValueOf(defaults)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		panic(fmt.Errorf("the config of plugin %s should be the pointer of struct: %T", plugin, defaults))
+	}
+	c := &PluginConfig{plugin: plugin}
+	c.value.Store(defaults)
+	envPrefix := configEnvPrefix + "_PLUGIN_" + strings.ToUpper(strings.ReplaceAll(plugin, "-", "_"))
+	decode := func(sections map[string]map[string]interface{}) (func(), error) {
+		decoded := reflect.New(v.Elem().Type())
+		decoded.Elem().Set(v.Elem())
+		if err := decodeConfigStruct(sections[plugin], envPrefix, decoded.Elem(), "plugins."+plugin); err != nil {
+			return nil, err
+		}
+		return func() {
+			c.value.Store(decoded.Interface())
+			c.lock.Lock()
+			listeners := c.listeners
+			c.lock.Unlock()
+			for _, listener := range listeners {
+				listener()
+			}
+		}, nil
+	}
+	listeners, _ := globalValue(pluginConfigListenersGlobal).([]func(map[string]map[string]interface{}) (func(), error))
+	setGlobalValue(pluginConfigListenersGlobal, append(listeners[:len(listeners):len(listeners)], decode))
+	// the invalid config keeps the defaults, and it's reported when the agent applies the config
+	sections, _ := globalValue(pluginConfigGlobal).(map[string]map[string]interface{})
+	if apply, err := decode(sections); err == nil {
+		apply()
+	}
+	return c
+}
+
+// Get returns the current config, it's the same type with the defaults and should not be modified
+func (c *PluginConfig) Get() interface{} {
+	return c.value.Load()
+}
+
+// OnChange adds the listener invoked after the config changed by the agent, such as rebuilding the caches of the plugin
+func (c *PluginConfig) OnChange(listener func()) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.listeners = append(c.listeners[:len(c.listeners):len(c.listeners)], listener)
+}
+
+func decodeConfigStruct(values map[string]interface{}, envPrefix string, v reflect.Value, path string) error {
+	t := v.Type()
+	known := make(map[string]bool, t.NumField())
+	remain := -1
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := configKey(field)
+		if key == "" || field.PkgPath != "" {
+			continue
+		}
+		if key == "*" && field.Type.Kind() == reflect.Map && field.Type.Key().Kind() == reflect.String {
+			remain = i
+			continue
+		}
+		known[key] = true
+		fieldPath, env := key, ""
+		if path != "" {
+			fieldPath = path + "." + key
+		}
+		if envPrefix != "" {
+			env = envPrefix + "_" + strings.ToUpper(key)
+		}
+		raw, exist := values[key]
+		if v.Field(i).Kind() == reflect.Struct {
+			nested, ok := raw.(map[string]interface{})
+			if exist && raw != nil && !ok {
+				return fmt.Errorf("config %s should be a map", fieldPath)
+			}
+			if err := decodeConfigStruct(nested, env, v.Field(i), fieldPath); err != nil {
+				return err
+			}
+			continue
+		}
+		if exist && raw != nil {
+			if err := setConfigValue(v.Field(i), raw, fieldPath); err != nil {
+				return fmt.Errorf("config %s: %v", fieldPath, err)
+			}
+		}
+		if value := os.Getenv(env); value != "" && env != "" {
+			if err := setConfigValue(v.Field(i), value, fieldPath); err != nil {
+				return fmt.Errorf("environment variable %s: %v", env, err)
+			}
+		}
+	}
+	unknown, remained := make([]string, 0), make(map[string]interface{})
+	for key, value := range values {
+		if known[key] {
+			continue
+		}
+		remained[key] = value
+		if path != "" {
+			key = path + "." + key
+		}
+		unknown = append(unknown, key)
+	}
+	if remain >= 0 && len(remained) > 0 {
+		if err := setConfigValue(v.Field(remain), remained, path); err != nil {
+			return fmt.Errorf("config %s: %v", path, err)
+		}
+		return nil
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown config %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+func setConfigValue(v reflect.Value, raw interface{}, path string) error {
+	text := strings.TrimSpace(fmt.Sprint(raw))
+	if v.Type() == durationType {
+		if _, ok := raw.(string); !ok {
+			return fmt.Errorf("the duration should be like \"10s\": %v", raw)
+		}
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(fmt.Sprint(raw))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(text, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(text, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(text, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Interface:
+		v.Set(reflect.ValueOf(raw))
+	case reflect.Slice:
+		items, ok := raw.([]interface{})
+		if s, isString := raw.(string); isString {
+			items, ok = splitConfigList(s), true
+		}
+		if !ok {
+			items = []interface{}{raw}
+		}
+		slice := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setConfigValue(slice.Index(i), item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("the key of map should be string")
+		}
+		entries, ok := raw.(map[string]interface{})
+		if s, isString := raw.(string); isString {
+			entries, ok = splitConfigMap(s), true
+		}
+		if !ok {
+			return fmt.Errorf("should be a map")
+		}
+		m := reflect.MakeMapWithSize(v.Type(), len(entries))
+		for key, entry := range entries {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := setConfigValue(elem, entry, path+"."+key); err != nil {
+				return err
+			}
+			m.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), elem)
+		}
+		v.Set(m)
+	case reflect.Struct:
+		nested, ok := raw.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("should be a map")
+		}
+		return decodeConfigStruct(nested, "", v, path)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// configKey returns the key of the field, empty if ignored
+func configKey(field reflect.StructField) string {
+	if tag, ok := field.Tag.Lookup("config"); ok {
+		if tag == "-" {
+			return ""
+		}
+		return tag
+	}
+	return snakeCase(field.Name)
+}
+
+// snakeCase converts the field name, such as "BackendAddress" to "backend_address", "OTLPEndpoint" to "otlp_endpoint"
+func snakeCase(name string) string {
+	var builder strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		isUpper := 'A' <= c && c <= 'Z'
+		if isUpper && i > 0 {
+			prev := name[i-1]
+			prevUpper := 'A' <= prev && prev <= 'Z'
+			nextLower := i+1 < len(name) && 'a' <= name[i+1] && name[i+1] <= 'z'
+			if !prevUpper || nextLower {
+				builder.WriteByte('_')
+			}
+		}
+		if isUpper {
+			c = c - 'A' + 'a'
+		}
+		builder.WriteByte(c)
+	}
+	return builder.String()
+}
+
+func splitConfigList(value string) []interface{} {
+	result := make([]interface{}, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func splitConfigMap(value string) map[string]interface{} {
+	result := make(map[string]interface{})
+	for _, item := range splitConfigList(value) {
+		kv := strings.SplitN(item.(string), "=", 2)
+		if len(kv) == 2 {
+			result[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	return result
+}
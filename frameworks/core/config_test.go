@@ -0,0 +1,214 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testServerConfig struct {
+	BackendAddress string
+	OTLPEndpoint   string
+	Timeout        time.Duration
+	Headers        map[string]string
+}
+
+type testConfig struct {
+	Service  string
+	Rate     int
+	Ratio    float64
+	Enabled  bool
+	Tags     []string
+	Server   testServerConfig
+	Renamed  string      `config:"name"`
+	Ignored  string      `config:"-"`
+	Extra    interface{} // kept as decoded from the YAML
+	internal string
+}
+
+func TestDecodeConfigPrecedence(t *testing.T) {
+	t.Setenv("TEST_SERVICE", "from-env")
+	t.Setenv("TEST_SERVER_BACKEND_ADDRESS", "env:11800")
+	t.Setenv("TEST_SERVER_OTLP_ENDPOINT", "env:4317")
+	t.Setenv("TEST_SERVER_HEADERS", "k1=v1, k2=v2=v3,invalid")
+	t.Setenv("TEST_TAGS", "a, ,b")
+	t.Setenv("TEST_ENABLED", "")
+	t.Setenv("TEST_NAME", "renamed-env")
+	t.Setenv("TEST_IGNORED", "ignored-env")
+
+	cfg := &testConfig{Service: "default", Rate: 10, Ratio: 0.5, Enabled: true, Ignored: "default",
+		Server: testServerConfig{BackendAddress: "default:11800", Timeout: time.Second}}
+	values := map[string]interface{}{
+		"service": "from-yaml",
+		"rate":    20,
+		"enabled": false,
+		"tags":    []interface{}{"yaml"},
+		"server":  map[string]interface{}{"backend_address": "yaml:11800", "timeout": "3s"},
+		"extra":   map[string]interface{}{"k": "v"},
+	}
+	if err := DecodeConfig(values, "TEST", cfg); err != nil {
+		t.Fatal(err)
+	}
+	expected := &testConfig{
+		Service: "from-env", // the environment variable over the YAML
+		Rate:    20,         // the YAML over the default
+		Ratio:   0.5,        // the default
+		Enabled: false,      // the empty environment variable is ignored
+		Tags:    []string{"a", "b"},
+		Server: testServerConfig{
+			BackendAddress: "env:11800",
+			OTLPEndpoint:   "env:4317",
+			Timeout:        3 * time.Second,
+			Headers:        map[string]string{"k1": "v1", "k2": "v2=v3"},
+		},
+		Renamed: "renamed-env",
+		Ignored: "default",
+		Extra:   map[string]interface{}{"k": "v"},
+	}
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("the decoded config: %+v\nexpected: %+v", cfg, expected)
+	}
+}
+
+func TestDecodeConfigErrors(t *testing.T) {
+	server := func(key string, value interface{}) map[string]interface{} {
+		return map[string]interface{}{"server": map[string]interface{}{key: value}}
+	}
+	tests := []struct {
+		values   map[string]interface{}
+		target   interface{} // the testConfig by default
+		env      string      // the value of TEST_RATE
+		expected string      // the prefix of the error
+	}{
+		{values: map[string]interface{}{"unknown_key": 1}, expected: "unknown config unknown_key"},
+		{values: map[string]interface{}{"server": map[string]interface{}{"retries": 3, "backend": "oap"}}, expected: "unknown config server.backend, server.retries"},
+		{values: map[string]interface{}{"ignored": "yaml"}, expected: "unknown config ignored"},
+		{values: map[string]interface{}{"internal": "yaml"}, expected: "unknown config internal"},
+		{values: map[string]interface{}{"rate": "ten"}, expected: "config rate: strconv.ParseInt"},
+		{values: map[string]interface{}{"enabled": "yes"}, expected: "config enabled: strconv.ParseBool"},
+		{values: map[string]interface{}{"server": "oap:11800"}, expected: "config server should be a map"},
+		{values: server("timeout", 10), expected: "config server.timeout: the duration should be like \"10s\""},
+		{values: server("timeout", "10"), expected: "config server.timeout: time: missing unit"},
+		{values: server("headers", []interface{}{"a"}), expected: "config server.headers: should be a map"},
+		{env: "ten", expected: "environment variable TEST_RATE: strconv.ParseInt"},
+		{target: testConfig{}, expected: "the config should be the pointer of struct"},
+		{values: map[string]interface{}{"tags": []interface{}{"a"}}, target: &struct{ Tags []func() }{}, expected: "config tags: unsupported type func()"},
+	}
+	for _, test := range tests {
+		t.Setenv("TEST_RATE", test.env)
+		target := test.target
+		if target == nil {
+			target = &testConfig{}
+		}
+		if err := DecodeConfig(test.values, "TEST", target); err == nil || !strings.HasPrefix(err.Error(), test.expected) {
+			t.Errorf("%v: the error %v, expected: %s", test.values, err, test.expected)
+		}
+	}
+}
+
+func TestDecodeConfigRemain(t *testing.T) {
+	type sections struct {
+		Type     string
+		Sections map[string]map[string]interface{} `config:"*"`
+	}
+	cfg := &struct{ Reporter sections }{}
+	values := map[string]interface{}{"reporter": map[string]interface{}{
+		"type": "grpc",
+		"grpc": map[string]interface{}{"backend_address": "oap:11800"},
+		"otlp": map[string]interface{}{"endpoint": "collector:4317"},
+	}}
+	if err := DecodeConfig(values, "TEST", cfg); err != nil {
+		t.Fatal(err)
+	}
+	// the keys not matched any field are kept for decoding later
+	expected := sections{Type: "grpc", Sections: map[string]map[string]interface{}{
+		"grpc": {"backend_address": "oap:11800"},
+		"otlp": {"endpoint": "collector:4317"},
+	}}
+	if !reflect.DeepEqual(cfg.Reporter, expected) {
+		t.Errorf("the decoded config: %+v", cfg.Reporter)
+	}
+	values = map[string]interface{}{"reporter": map[string]interface{}{"grpc": 11800}}
+	if err := DecodeConfig(values, "TEST", cfg); err == nil || !strings.HasPrefix(err.Error(), "config reporter: ") {
+		t.Errorf("the error of the invalid remained value: %v", err)
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	tests := map[string]string{
+		"Service":              "service",
+		"BackendAddress":       "backend_address",
+		"OTLPEndpoint":         "otlp_endpoint",
+		"GRPC":                 "grpc",
+		"DisabledInterceptors": "disabled_interceptors",
+		"HTTPServerID":         "http_server_id",
+	}
+	for name, expected := range tests {
+		if actual := snakeCase(name); actual != expected {
+			t.Errorf("%s: %s, expected: %s", name, actual, expected)
+		}
+	}
+}
+
+type testPluginConfig struct {
+	CollectHeaders []string
+	MaxLength      int
+}
+
+func TestDeclarePluginConfig(t *testing.T) {
+	defer func() {
+		_ = SetPluginConfig(nil)
+	}()
+	t.Setenv("SW_AGENT_PLUGIN_TEST_PLUGIN_MAX_LENGTH", "64")
+	defaults := &testPluginConfig{CollectHeaders: []string{"x-default"}, MaxLength: 10}
+	c := DeclarePluginConfig("test-plugin", defaults)
+	expected := &testPluginConfig{CollectHeaders: []string{"x-default"}, MaxLength: 64}
+	if actual := c.Get(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("the declared config: %+v, expected: %+v", actual, expected)
+	}
+	changed := 0
+	c.OnChange(func() {
+		changed++
+	})
+
+	// the section of the plugin, the environment variable takes precedence
+	sections := map[string]map[string]interface{}{
+		"test-plugin": {"collect_headers": []interface{}{"x-user-id"}, "max_length": 128},
+		"other":       {"unknown": true},
+	}
+	if err := SetPluginConfig(sections); err != nil {
+		t.Fatal(err)
+	}
+	expected = &testPluginConfig{CollectHeaders: []string{"x-user-id"}, MaxLength: 64}
+	if actual := c.Get(); !reflect.DeepEqual(actual, expected) || changed != 1 {
+		t.Errorf("the changed config: %+v, expected: %+v, changed: %d", actual, expected, changed)
+	}
+	if defaults.MaxLength != 10 || len(defaults.CollectHeaders) != 1 || defaults.CollectHeaders[0] != "x-default" {
+		t.Errorf("the defaults are modified: %+v", defaults)
+	}
+
+	// the invalid section keeps the applied config
+	err := SetPluginConfig(map[string]map[string]interface{}{"test-plugin": {"collect_header": "x-user-id"}})
+	if err == nil || err.Error() != "unknown config plugins.test-plugin.collect_header" {
+		t.Errorf("the unknown key of the plugin section: %v", err)
+	}
+	if actual := c.Get(); !reflect.DeepEqual(actual, expected) || changed != 1 {
+		t.Errorf("the config after the invalid change: %+v, changed: %d", actual, changed)
+	}
+
+	// the plugin declared with the invalid environment variable keeps the defaults
+	t.Setenv("SW_AGENT_PLUGIN_INVALID_MAX_LENGTH", "long")
+	invalid := DeclarePluginConfig("invalid", &testPluginConfig{MaxLength: 10})
+	if actual := invalid.Get().(*testPluginConfig); actual.MaxLength != 10 {
+		t.Errorf("the config with the invalid environment variable: %+v", actual)
+	}
+	if err := SetPluginConfig(nil); err == nil || !strings.Contains(err.Error(), "SW_AGENT_PLUGIN_INVALID_MAX_LENGTH") {
+		t.Errorf("the invalid environment variable is not reported: %v", err)
+	}
+	// none of the plugins is changed when any of them is invalid
+	if actual := c.Get(); !reflect.DeepEqual(actual, expected) || changed != 1 {
+		t.Errorf("the config is changed with the invalid plugin: %+v, changed: %d", actual, changed)
+	}
+	t.Setenv("SW_AGENT_PLUGIN_INVALID_MAX_LENGTH", "")
+}
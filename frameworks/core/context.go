@@ -0,0 +1,68 @@
+package core
+
+import "context"
+
+// the key of the span in the context.Context, the type is unnamed,
+// so the copies of the core in different enhanced packages read the same value
+type contextSpanKey = struct{ SkyWalkingActiveSpan struct{} }
+
+// the active span of the goroutine
+var activeSpanGLSKey = NewGLSKey("active-span")
+
+// ActiveSpan returns the active span of the current goroutine
+func ActiveSpan() interface{} {
+	return activeSpanGLSKey.Get()
+}
+
+// SetActiveSpan changes the active span of the current goroutine, nil span clears it
+func SetActiveSpan(span interface{}) {
+	activeSpanGLSKey.Set(span)
+}
+
+// ContextWithSpan returns a copy of the ctx which carries the span
+func ContextWithSpan(ctx context.Context, span interface{}) context.Context {
+	if ctx == nil || span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, contextSpanKey{}, span)
+}
+
+// SpanFromContext returns the span carried by the ctx
+func SpanFromContext(ctx context.Context) interface{} {
+	if ctx == nil {
+		return nil
+	}
+	return ctx.Value(contextSpanKey{})
+}
+
+// ContextWithActiveSpan injects the active span of the current goroutine into the ctx when the ctx lacks one
+func ContextWithActiveSpan(ctx context.Context) context.Context {
+	if ctx == nil || SpanFromContext(ctx) != nil {
+		return ctx
+	}
+	return ContextWithSpan(ctx, ActiveSpan())
+}
+
+// ActivateContextSpan makes the span carried by the ctx to be the active span of the current goroutine,
+// return the function to restore the previous one, or nil if the ctx has no span
+func ActivateContextSpan(ctx context.Context) func() {
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return nil
+	}
+	previous := ActiveSpan()
+	SetActiveSpan(span)
+	return func() {
+		SetActiveSpan(previous)
+	}
+}
+
+// SyncContextGLS is invoked by the adapters for the method which receives a context.Context:
+// the span of the ctx becomes active, otherwise the active span is injected into the ctx.
+// Return the ctx should be used and the function to restore the active span(nil if not changed)
+func SyncContextGLS(ctx context.Context) (context.Context, func()) {
+	if restore := ActivateContextSpan(ctx); restore != nil {
+		return ctx, restore
+	}
+	return ContextWithActiveSpan(ctx), nil
+}
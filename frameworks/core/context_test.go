@@ -0,0 +1,47 @@
+package core
+
+import (
+	"context"
+	"testing"
+)
+
+func TestContextWithSpan(t *testing.T) {
+	ctx := ContextWithSpan(context.Background(), "span-1")
+	if SpanFromContext(ctx) != "span-1" || SpanFromContext(context.Background()) != nil || SpanFromContext(nil) != nil {
+		t.Errorf("the span from the context: %v", SpanFromContext(ctx))
+	}
+	if ContextWithSpan(ctx, nil) != ctx || ContextWithSpan(nil, "span-1") != nil {
+		t.Error("the context is changed without the span or context")
+	}
+}
+
+func TestSyncContextGLS(t *testing.T) {
+	skipNotInstrumented(t)
+	defer RestoreGLS(nil)
+	SetActiveSpan("active")
+
+	// the span carried by the context becomes active until restoring
+	carried := ContextWithSpan(context.Background(), "carried")
+	ctx, restore := SyncContextGLS(carried)
+	if ctx != carried || restore == nil || ActiveSpan() != "carried" {
+		t.Fatalf("the active span of the context with span: %v, restore: %t", ActiveSpan(), restore != nil)
+	}
+	restore()
+	if ActiveSpan() != "active" {
+		t.Errorf("the restored active span: %v", ActiveSpan())
+	}
+
+	// the active span is injected into the context without span
+	ctx, restore = SyncContextGLS(context.Background())
+	if SpanFromContext(ctx) != "active" || restore != nil || ActiveSpan() != "active" {
+		t.Errorf("the span injected into the context: %v, restore: %t", SpanFromContext(ctx), restore != nil)
+	}
+	// nothing to inject without the active span
+	SetActiveSpan(nil)
+	if ctx, restore = SyncContextGLS(context.Background()); ctx != context.Background() || restore != nil {
+		t.Errorf("the context without the active span: %v, restore: %t", ctx, restore != nil)
+	}
+	if ctx, restore = SyncContextGLS(nil); ctx != nil || restore != nil {
+		t.Errorf("the nil context: %v, restore: %t", ctx, restore != nil)
+	}
+}
@@ -0,0 +1,33 @@
+package core
+
+import (
+	"sync"
+	_ "unsafe"
+)
+
+//go:linkname _skywalking_global_get _skywalking_global_get
+var _skywalking_global_get func(string) interface{}
+
+//go:linkname _skywalking_global_set _skywalking_global_set
+var _skywalking_global_set func(string, interface{})
+
+// the process wide values when the runtime is not instrumented, only shared in this copy of the core
+var localGlobals sync.Map
+
+// globalValue reads the value shared by all copies of the core, the value should use the types
+// which are same in all copies, such as the std types and the aliases of the unnamed types
+func globalValue(name string) interface{} {
+	if _skywalking_global_get != nil {
+		return _skywalking_global_get(name)
+	}
+	v, _ := localGlobals.Load(name)
+	return v
+}
+
+func setGlobalValue(name string, v interface{}) {
+	if _skywalking_global_set != nil {
+		_skywalking_global_set(name, v)
+		return
+	}
+	localGlobals.Store(name, v)
+}
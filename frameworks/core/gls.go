@@ -0,0 +1,264 @@
+package core
+
+import (
+	"sync/atomic"
+	_ "unsafe"
+)
+
+//go:linkname _skywalking_tls_get _skywalking_tls_get
+var _skywalking_tls_get func() interface{}
+
+//go:linkname _skywalking_tls_set _skywalking_tls_set
+var _skywalking_tls_set func(interface{})
+
+//go:linkname _skywalking_tls_propagate_set _skywalking_tls_propagate_set
+var _skywalking_tls_propagate_set func(func(interface{}, int64) interface{})
+
+//go:linkname _skywalking_goroutine_exit_listen _skywalking_goroutine_exit_listen
+var _skywalking_goroutine_exit_listen func(func(interface{}))
+
+// the goroutine keeps a store of the values keyed by the GLSKey name, so multiple features could coexist.
+// The types are unnamed, so the copies of the core in different enhanced packages share the same store.
+// The store is never changed after set into the goroutine(copy on write), the values are propagated into
+// the store of the new goroutine when spawning it
+type glsEntry = struct {
+	Value     interface{}
+	Propagate func(interface{}) interface{}
+}
+
+type glsStore = map[string]glsEntry
+
+// the goroutine slot accessors, keep nil when the runtime is not instrumented
+var (
+	glsGet func() interface{}
+	glsSet func(interface{})
+)
+
+// the GetGLS and SetGLS use the default key
+var defaultGLSKey = NewGLSKey("default")
+
+func init() {
+	if _skywalking_tls_get != nil && _skywalking_tls_set != nil {
+		glsGet = _skywalking_tls_get
+		glsSet = _skywalking_tls_set
+		if _skywalking_tls_propagate_set != nil {
+			_skywalking_tls_propagate_set(propagateGLSStore)
+		}
+	}
+}
+
+// GLSPropagator builds the GLS value of the new goroutine from the value of the parent goroutine,
+// it's invoked on the parent goroutine when spawning
+type GLSPropagator func(parent interface{}) interface{}
+
+// GLSSnapshot could be implemented by the GLS value, the new goroutine gets the snapshot of the parent value
+type GLSSnapshot interface {
+	SnapshotForGoroutine() interface{}
+}
+
+// GLSCloneable could be implemented by the GLS value, the new goroutine gets the deep copy of the parent value
+type GLSCloneable interface {
+	CloneForGoroutine() interface{}
+}
+
+// GLSKey is the key of a value in the goroutine local storage.
+// The value is not generic typed, because the core is copied into the packages which may compile with go1.17 or lower
+type GLSKey struct {
+	name string
+	// the GLSPropagator, it could be changed by the config reloading while other goroutines setting the value
+	propagate atomic.Value
+}
+
+// NewGLSKey creates a key, the keys with same name access the same value,
+// the value passes to the new goroutine by reference
+func NewGLSKey(name string) *GLSKey {
+	return &GLSKey{name: name}
+}
+
+// WithPropagator changes how the value passes to the new goroutine
+func (k *GLSKey) WithPropagator(p GLSPropagator) *GLSKey {
+	k.propagate.Store(p)
+	return k
+}
+
+func (k *GLSKey) propagator() GLSPropagator {
+	p, _ := k.propagate.Load().(GLSPropagator)
+	return p
+}
+
+// Get returns the value of the current goroutine, return nil if not exists or the runtime is not instrumented
+func (k *GLSKey) Get() interface{} {
+	return k.GetFrom(currentGLSStore())
+}
+
+// GetFrom returns the value from the GLS passed to the goroutine exit listener, or from the snapshot
+func (k *GLSKey) GetFrom(gls interface{}) interface{} {
+	store, _ := gls.(glsStore)
+	return store[k.name].Value
+}
+
+// Set changes the value of the current goroutine, set nil value is same as delete
+func (k *GLSKey) Set(v interface{}) {
+	if v == nil {
+		k.Delete()
+		return
+	}
+	updateGLSStore(func(store glsStore) {
+		store[k.name] = glsEntry{Value: v, Propagate: k.propagator()}
+	})
+}
+
+// Delete removes the value of the current goroutine
+func (k *GLSKey) Delete() {
+	if store := currentGLSStore(); store == nil {
+		return
+	}
+	updateGLSStore(func(store glsStore) {
+		delete(store, k.name)
+	})
+}
+
+// SnapshotGLS copies all values of the current goroutine, the snapshot could be restored in any goroutine
+func SnapshotGLS() interface{} {
+	store := currentGLSStore()
+	if store == nil {
+		return nil
+	}
+	return store
+}
+
+// RestoreGLS replaces all values of the current goroutine by the snapshot, nil snapshot clears the values
+func RestoreGLS(snapshot interface{}) {
+	if glsSet == nil {
+		return
+	}
+	store, _ := snapshot.(glsStore)
+	if isEmptyGLSStore(store) {
+		glsSet(nil)
+		return
+	}
+	glsSet(store)
+}
+
+// SetGLSPropagator changes how the value of GetGLS/SetGLS passes to the new goroutine, the default is PropagateReference.
+// The propagator is invoked on the parent goroutine when spawning, the changes after that never affect the new goroutine
+func SetGLSPropagator(p GLSPropagator) {
+	defaultGLSKey.WithPropagator(p)
+	if _, exist := currentGLSStore()[defaultGLSKey.name]; !exist {
+		return
+	}
+	updateGLSStore(func(store glsStore) {
+		entry := store[defaultGLSKey.name]
+		entry.Propagate = p
+		store[defaultGLSKey.name] = entry
+	})
+}
+
+// PropagateReference shares the same value between the parent and the new goroutine
+func PropagateReference(parent interface{}) interface{} {
+	return parent
+}
+
+// PropagateDrop starts the new goroutine without any value
+func PropagateDrop(parent interface{}) interface{} {
+	return nil
+}
+
+// PropagateSnapshot passes the snapshot of the parent value if it implements GLSSnapshot, otherwise pass by reference
+func PropagateSnapshot(parent interface{}) interface{} {
+	if s, ok := parent.(GLSSnapshot); ok {
+		return s.SnapshotForGoroutine()
+	}
+	return parent
+}
+
+// PropagateClone passes the deep copy of the parent value if it implements GLSCloneable, otherwise pass by reference
+func PropagateClone(parent interface{}) interface{} {
+	if c, ok := parent.(GLSCloneable); ok {
+		return c.CloneForGoroutine()
+	}
+	return parent
+}
+
+// AddGoroutineExitListener subscribes the end of the goroutines which have GLS, the listener is invoked on the
+// ending goroutine with its GLS, read the value by GLSKey.GetFrom.
+// The GLS is cleared after that, so the reused goroutine never carries a stale value
+func AddGoroutineExitListener(listener func(gls interface{})) {
+	if _skywalking_goroutine_exit_listen == nil {
+		return
+	}
+	_skywalking_goroutine_exit_listen(func(gls interface{}) {
+		// the goroutine is ending, a panic here cannot be recovered by anyone
+		defer func() {
+			_ = recover()
+		}()
+		listener(gls)
+	})
+}
+
+func currentGLSStore() glsStore {
+	if glsGet == nil {
+		return nil
+	}
+	store, _ := glsGet().(glsStore)
+	return store
+}
+
+// updateGLSStore changes a copy of the current store and sets it into the goroutine,
+// the goroutines spawned before still refer to the previous store
+func updateGLSStore(update func(store glsStore)) {
+	if glsGet == nil {
+		return
+	}
+	store := copyGLSStore(currentGLSStore(), nil)
+	update(store)
+	if isEmptyGLSStore(store) {
+		glsSet(nil)
+		return
+	}
+	glsSet(store)
+}
+
+// propagateGLSStore builds the store of the new goroutine from the store of its parent, every value passes through
+// the propagator of its key. It's invoked on the parent goroutine when spawning, or on the new goroutine
+// which is not spawned by the go statement(such as the coroutines) when it first accesses the GLS
+func propagateGLSStore(parent interface{}, parentID int64) interface{} {
+	store, ok := parent.(glsStore)
+	if !ok {
+		return parent
+	}
+	result := copyGLSStore(store, func(entry glsEntry) interface{} {
+		if entry.Propagate == nil {
+			return entry.Value
+		}
+		return entry.Propagate(entry.Value)
+	})
+	if isEmptyGLSStore(result) {
+		return nil
+	}
+	if lineage := spawnedLineage(store, parentID); lineage != nil {
+		result[lineageGLSKey.name] = glsEntry{Value: lineage}
+	}
+	return result
+}
+
+// isEmptyGLSStore checks the store has no value, the lineage is not a value set by the user
+func isEmptyGLSStore(store glsStore) bool {
+	if _, exist := store[lineageGLSKey.name]; exist {
+		return len(store) == 1
+	}
+	return len(store) == 0
+}
+
+func copyGLSStore(store glsStore, value func(entry glsEntry) interface{}) glsStore {
+	result := make(glsStore, len(store))
+	for k, entry := range store {
+		if value != nil {
+			entry.Value = value(entry)
+		}
+		if entry.Value != nil {
+			result[k] = entry
+		}
+	}
+	return result
+}
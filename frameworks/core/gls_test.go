@@ -0,0 +1,180 @@
+package core
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// skipNotInstrumented skips the test which needs the GLS of the runtime,
+// they are run by "go test -toolexec", see TestInstrumentedRuntime of the toolexec
+func skipNotInstrumented(t *testing.T) {
+	t.Helper()
+	if GoroutineID() == 0 {
+		t.Skip("the runtime is not instrumented")
+	}
+}
+
+// spawn runs the function in a new goroutine after the release is closed, waits until it returns
+func spawn(f func()) (release func()) {
+	start, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		<-start
+		f()
+	}()
+	return func() {
+		close(start)
+		<-done
+	}
+}
+
+type testSpanStack struct {
+	spans []string
+}
+
+func (s *testSpanStack) SnapshotForGoroutine() interface{} {
+	return &testSpanStack{spans: s.spans[len(s.spans)-1:]}
+}
+
+func (s *testSpanStack) CloneForGoroutine() interface{} {
+	return &testSpanStack{spans: append([]string(nil), s.spans...)}
+}
+
+func TestGLSPropagateAtSpawn(t *testing.T) {
+	skipNotInstrumented(t)
+	reference := NewGLSKey("test-reference")
+	snapshot := NewGLSKey("test-snapshot").WithPropagator(PropagateSnapshot)
+	clone := NewGLSKey("test-clone").WithPropagator(PropagateClone)
+	dropped := NewGLSKey("test-drop").WithPropagator(PropagateDrop)
+	defer RestoreGLS(nil)
+
+	shared := &testSpanStack{spans: []string{"entry"}}
+	reference.Set(shared)
+	snapshot.Set(&testSpanStack{spans: []string{"entry", "local"}})
+	clone.Set(&testSpanStack{spans: []string{"entry", "local"}})
+	dropped.Set("request")
+
+	var values []interface{}
+	release := spawn(func() {
+		values = []interface{}{reference.Get(), snapshot.Get(), clone.Get(), dropped.Get()}
+	})
+	// the changes after spawning never affect the new goroutine, even it accesses the GLS later
+	snapshot.Get().(*testSpanStack).spans = append(snapshot.Get().(*testSpanStack).spans, "changed")
+	clone.Get().(*testSpanStack).spans[1] = "changed"
+	reference.Set(&testSpanStack{spans: []string{"replaced"}})
+	dropped.Set("replaced")
+	release()
+
+	expected := []interface{}{
+		shared,
+		&testSpanStack{spans: []string{"local"}},
+		&testSpanStack{spans: []string{"entry", "local"}},
+		nil,
+	}
+	if !reflect.DeepEqual(values, expected) || values[0] != shared {
+		for i := range values {
+			t.Errorf("the value %d of the new goroutine: %+v, expected: %+v", i, values[i], expected[i])
+		}
+	}
+}
+
+func TestSetGLSPropagator(t *testing.T) {
+	skipNotInstrumented(t)
+	defer func() {
+		SetGLSPropagator(PropagateReference)
+		RestoreGLS(nil)
+	}()
+	SetGLS(&testSpanStack{spans: []string{"entry"}})
+	// the propagator of the value already set is changed
+	SetGLSPropagator(PropagateDrop)
+	var value interface{} = "not propagated"
+	spawn(func() {
+		value = GetGLS()
+	})()
+	if value != nil {
+		t.Errorf("the dropped value is propagated: %v", value)
+	}
+}
+
+func TestGLSKeys(t *testing.T) {
+	skipNotInstrumented(t)
+	defer RestoreGLS(nil)
+	a, b := NewGLSKey("test-a"), NewGLSKey("test-b")
+	a.Set("a1")
+	b.Set("b1")
+	// the keys with same name access the same value
+	if NewGLSKey("test-a").Get() != "a1" || b.Get() != "b1" {
+		t.Fatalf("the values: %v, %v", a.Get(), b.Get())
+	}
+	a.Set(nil)
+	b.Delete()
+	b.Delete()
+	if a.Get() != nil || b.Get() != nil || SnapshotGLS() != nil {
+		t.Fatalf("the deleted values: %v, %v, snapshot: %v", a.Get(), b.Get(), SnapshotGLS())
+	}
+
+	a.Set("a2")
+	snapshot := SnapshotGLS()
+	a.Set("a3")
+	b.Set("b3")
+	// the snapshot is never changed by the later changes, and could be restored in another goroutine
+	var restored []interface{}
+	spawn(func() {
+		RestoreGLS(snapshot)
+		restored = []interface{}{a.Get(), b.Get(), a.GetFrom(SnapshotGLS())}
+	})()
+	if !reflect.DeepEqual(restored, []interface{}{"a2", nil, "a2"}) {
+		t.Errorf("the restored values: %v", restored)
+	}
+	if a.GetFrom(snapshot) != "a2" || a.Get() != "a3" || b.Get() != "b3" {
+		t.Errorf("the values after restoring in another goroutine: %v, %v, snapshot: %v", a.Get(), b.Get(), a.GetFrom(snapshot))
+	}
+	RestoreGLS(nil)
+	if a.Get() != nil || b.Get() != nil {
+		t.Errorf("the values after clearing: %v, %v", a.Get(), b.Get())
+	}
+}
+
+func TestGLSNotInstrumented(t *testing.T) {
+	get, set := glsGet, glsSet
+	glsGet, glsSet = nil, nil
+	defer func() {
+		glsGet, glsSet = get, set
+	}()
+	key := NewGLSKey("test-not-instrumented")
+	key.Set("value")
+	SetGLS("value")
+	RestoreGLS(map[string]glsEntry{key.name: {Value: "value"}})
+	if key.Get() != nil || GetGLS() != nil || SnapshotGLS() != nil {
+		t.Errorf("the values without the instrumented runtime: %v, %v, %v", key.Get(), GetGLS(), SnapshotGLS())
+	}
+	key.Delete()
+	SetGLSPropagator(PropagateDrop)
+	SetGLSPropagator(PropagateReference)
+}
+
+// TestGLSPropagatorConcurrently changes the propagator while other goroutines setting the value and spawning, for the race detector
+func TestGLSPropagatorConcurrently(t *testing.T) {
+	skipNotInstrumented(t)
+	defer SetGLSPropagator(PropagateReference)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				SetGLS(&testSpanStack{spans: []string{strconv.Itoa(j)}})
+				spawn(func() {
+					_ = GetGLS()
+				})()
+			}
+		}()
+	}
+	propagators := []GLSPropagator{PropagateSnapshot, PropagateClone, PropagateDrop, PropagateReference}
+	for i := 0; i < 100; i++ {
+		SetGLSPropagator(propagators[i%len(propagators)])
+	}
+	wg.Wait()
+}
@@ -0,0 +1,73 @@
+package core
+
+import _ "unsafe"
+
+//go:linkname _skywalking_goroutine_id _skywalking_goroutine_id
+var _skywalking_goroutine_id func() int64
+
+//go:linkname _skywalking_goroutine_parent_id _skywalking_goroutine_parent_id
+var _skywalking_goroutine_parent_id func() int64
+
+//go:linkname _skywalking_goroutines_tls _skywalking_goroutines_tls
+var _skywalking_goroutines_tls func() map[int64]interface{}
+
+// the max count of the ancestors kept in the lineage, avoid growing by the goroutines spawned recursively
+const maxGoroutineLineage = 16
+
+// the ancestors of the goroutine, kept in the GLS store by propagateGLSStore
+var lineageGLSKey = NewGLSKey("goroutine-lineage")
+
+// GoroutineID returns the ID of the current goroutine, 0 if the runtime is not instrumented
+func GoroutineID() int64 {
+	if _skywalking_goroutine_id == nil {
+		return 0
+	}
+	return _skywalking_goroutine_id()
+}
+
+// ParentGoroutineID returns the ID of the goroutine which spawned the current goroutine,
+// 0 if the runtime is not instrumented or the goroutine is created by the runtime
+func ParentGoroutineID() int64 {
+	if _skywalking_goroutine_parent_id == nil {
+		return 0
+	}
+	return _skywalking_goroutine_parent_id()
+}
+
+// GoroutinesGLS returns the GLS of the alive goroutines which have values, goroutine ID -> GLS, read the value by GLSKey.GetFrom.
+// The goroutine which not accessed the GLS since spawned has the values of its parent, they are not propagated yet.
+// Return nil if the runtime is not instrumented
+func GoroutinesGLS() map[int64]interface{} {
+	if _skywalking_goroutines_tls == nil {
+		return nil
+	}
+	return _skywalking_goroutines_tls()
+}
+
+// GoroutineLineage returns the IDs of the goroutines which the current goroutine spawned from, the nearest first.
+// The lineage is only tracked from the goroutine which has GLS values(such as processing a request),
+// so the async goroutines could be linked to the request which started them.
+// Otherwise, only the parent goroutine is returned
+func GoroutineLineage() []int64 {
+	if lineage, ok := lineageGLSKey.Get().([]int64); ok {
+		return append([]int64(nil), lineage...)
+	}
+	if parent := ParentGoroutineID(); parent != 0 {
+		return []int64{parent}
+	}
+	return nil
+}
+
+// spawnedLineage builds the lineage of the new goroutine from the store and the ID of its parent
+func spawnedLineage(parent glsStore, parentID int64) []int64 {
+	if parentID == 0 {
+		return nil
+	}
+	ancestors, _ := parent[lineageGLSKey.name].Value.([]int64)
+	if len(ancestors) >= maxGoroutineLineage {
+		ancestors = ancestors[:maxGoroutineLineage-1]
+	}
+	lineage := make([]int64, 0, len(ancestors)+1)
+	lineage = append(lineage, parentID)
+	return append(lineage, ancestors...)
+}
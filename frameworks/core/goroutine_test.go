@@ -0,0 +1,56 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGoroutineLineage(t *testing.T) {
+	skipNotInstrumented(t)
+	defer RestoreGLS(nil)
+	parent := GoroutineID()
+
+	// the goroutine without GLS only knows its parent
+	var lineage []int64
+	var child, childParent int64
+	spawn(func() {
+		lineage, child, childParent = GoroutineLineage(), GoroutineID(), ParentGoroutineID()
+	})()
+	if !reflect.DeepEqual(lineage, []int64{parent}) || childParent != parent || child == parent || child == 0 {
+		t.Errorf("the lineage without GLS: %v, goroutine: %d, parent: %d, expected parent: %d", lineage, child, childParent, parent)
+	}
+
+	// the lineage is tracked from the goroutine with GLS values
+	NewGLSKey("test-request").Set("GET:/users")
+	spawn(func() {
+		child = GoroutineID()
+		spawn(func() {
+			lineage = GoroutineLineage()
+		})()
+	})()
+	if expected := []int64{child, parent}; !reflect.DeepEqual(lineage, expected) {
+		t.Errorf("the lineage of the grandchild: %v, expected: %v", lineage, expected)
+	}
+}
+
+func TestSpawnedLineage(t *testing.T) {
+	ancestors := make([]int64, 0, maxGoroutineLineage)
+	for i := int64(maxGoroutineLineage); i > 0; i-- {
+		ancestors = append(ancestors, i)
+	}
+	parent := glsStore{lineageGLSKey.name: {Value: ancestors}}
+	lineage := spawnedLineage(parent, 100)
+	// the nearest first, the farthest ancestor is dropped
+	if len(lineage) != maxGoroutineLineage || lineage[0] != 100 || lineage[1] != maxGoroutineLineage || lineage[len(lineage)-1] != 2 {
+		t.Errorf("the lineage: %v", lineage)
+	}
+	if len(ancestors) != maxGoroutineLineage || ancestors[0] != maxGoroutineLineage {
+		t.Errorf("the lineage of the parent is changed: %v", ancestors)
+	}
+	if lineage := spawnedLineage(glsStore{}, 100); !reflect.DeepEqual(lineage, []int64{100}) {
+		t.Errorf("the lineage without ancestors: %v", lineage)
+	}
+	if lineage := spawnedLineage(parent, 0); lineage != nil {
+		t.Errorf("the lineage without parent: %v", lineage)
+	}
+}
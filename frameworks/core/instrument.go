@@ -2,7 +2,10 @@ package core
 
 import (
 	"embed"
+	"fmt"
 	"github.com/dave/dst/dstutil"
+	"sort"
+	"sync"
 )
 
 type InstrumentPoint struct {
@@ -13,8 +16,57 @@ type InstrumentPoint struct {
 	EnhanceStruct   func(cursor *dstutil.Cursor) bool // Define which struct needs enhance
 }
 
+//go:embed *.go
+var sources embed.FS
+
+// Sources returns the source files of the core, they are copied into the enhanced package with the interceptors,
+// except the instrument.go which only used by the toolexec
+func Sources() *embed.FS {
+	return &sources
+}
+
 type Instrument interface {
+	Name() string // Plugin name, used for reporting which plugin enhanced the code
 	BasePackage() string
 	Points() []*InstrumentPoint
 	FS() *embed.FS
 }
+
+// InstrumentFiles is the manifest of the files copied into the enhanced package, implemented by the Instrument optionally.
+// Files returns the paths in the FS for the PackagePath of the points, the directory includes the go files in it recursively.
+// Without the manifest, all the go files in the FS are copied except the instrument.go in the root, the tests and the testdata.
+// The files in the subdirectories are the subpackages of the plugin, they are merged into the enhanced package with the root,
+// and the files not matched the build constraints(such as "//go:build linux" or "_windows.go") are skipped
+type InstrumentFiles interface {
+	Files(packagePath string) []string
+}
+
+var (
+	instrumentsLock sync.Mutex
+	instruments     = make(map[string]Instrument)
+)
+
+// RegisterInstrument registers the instrument of the plugin, it should be invoked in the init function of the plugin package,
+// so the toolexec program enables the plugin by importing its package. The plugin name should be unique
+func RegisterInstrument(inst Instrument) {
+	instrumentsLock.Lock()
+	defer instrumentsLock.Unlock()
+	if _, exist := instruments[inst.Name()]; exist {
+		panic(fmt.Errorf("the plugin %s is already registered", inst.Name()))
+	}
+	instruments[inst.Name()] = inst
+}
+
+// Instruments returns the registered instruments, sorted by the plugin name
+func Instruments() []Instrument {
+	instrumentsLock.Lock()
+	defer instrumentsLock.Unlock()
+	result := make([]Instrument, 0, len(instruments))
+	for _, inst := range instruments {
+		result = append(result, inst)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name() < result[j].Name()
+	})
+	return result
+}
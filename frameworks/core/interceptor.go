@@ -3,15 +3,28 @@ package core
 import _ "unsafe"
 
 var (
-	GetGLS = func() interface{} { return nil }
-	SetGLS = func(interface{}) {}
+	GetGLS       = func() interface{} { return defaultGLSKey.Get() }
+	SetGLS       = func(v interface{}) { defaultGLSKey.Set(v) }
+	AgentVersion = func() string { return "" } // the agent version stamped into the binary when linking
+	// BuildInfo returns the build metadata stamped into the binary when linking,
+	// the keys are "agent.version", "toolexec.identity", "go.version" and "plugins"(comma separated)
+	BuildInfo = func() map[string]string { return nil }
 )
 
-//go:linkname _skywalking_tls_get _skywalking_tls_get
-var _skywalking_tls_get func() interface{}
+//go:linkname _skywalking_agent_version _skywalking_agent_version
+var _skywalking_agent_version func() string
 
-//go:linkname _skywalking_tls_set _skywalking_tls_set
-var _skywalking_tls_set func(interface{})
+//go:linkname _skywalking_build_info _skywalking_build_info
+var _skywalking_build_info func() map[string]string
+
+func init() {
+	if _skywalking_agent_version != nil {
+		AgentVersion = _skywalking_agent_version
+	}
+	if _skywalking_build_info != nil {
+		BuildInfo = _skywalking_build_info
+	}
+}
 
 type Invocation struct {
 	CallerInstance interface{}
@@ -19,11 +32,35 @@ type Invocation struct {
 
 	Continue bool
 	Return   []interface{} // not fully implemented, return default value for now
+
+	Attachment interface{} // passes the value from BeforeInvoke to AfterInvoke, such as the span
+
+	restoreGLS func() // restore the active span changed by the context.Context parameter
+}
+
+// enhancedInstance is implemented by the enhanced structs, the field and the methods are generated into the enhanced package
+// with the names of the injected identifiers, so they never conflict with the package. The methods are unexported,
+// only the core copied into the same package could access them
+type enhancedInstance interface {
+	_skywalking_get_dynamic_field() interface{}
+	_skywalking_set_dynamic_field(interface{})
+}
+
+// GetDynamicField returns the value saved in the enhanced instance, it's nil when the instance is not enhanced
+func GetDynamicField(instance interface{}) interface{} {
+	if enhanced, ok := instance.(enhancedInstance); ok {
+		return enhanced._skywalking_get_dynamic_field()
+	}
+	return nil
 }
 
-type EnhancedInstance interface {
-	GetSkyWalkingDynamicField() interface{}
-	SetSkyWalkingDynamicField(interface{})
+// SetDynamicField saves the value in the enhanced instance, returns false when the instance is not enhanced
+func SetDynamicField(instance interface{}, value interface{}) bool {
+	enhanced, ok := instance.(enhancedInstance)
+	if ok {
+		enhanced._skywalking_set_dynamic_field(value)
+	}
+	return ok
 }
 
 type Interceptor interface {
@@ -0,0 +1,504 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// the global name of the propagator
+const propagatorGlobal = "propagator"
+
+// the names of the built-in propagators
+const (
+	PropagatorSW8          = "sw8"
+	PropagatorTraceContext = "tracecontext"
+	PropagatorBaggage      = "baggage"
+	PropagatorB3           = "b3"
+	PropagatorB3Multi      = "b3multi"
+)
+
+const (
+	sw8Header            = "sw8"
+	sw8CorrelationHeader = "sw8-correlation"
+	traceParentHeader    = "traceparent"
+	traceStateHeader     = "tracestate"
+	baggageHeader        = "baggage"
+	b3Header             = "b3"
+	b3TraceIDHeader      = "x-b3-traceid"
+	b3SpanIDHeader       = "x-b3-spanid"
+	b3ParentSpanIDHeader = "x-b3-parentspanid"
+	b3SampledHeader      = "x-b3-sampled"
+	b3FlagsHeader        = "x-b3-flags"
+)
+
+// Carrier reads and writes the propagation headers, such as the HTTP headers, gRPC metadata and message headers.
+// It's the alias of the unnamed interface, so the carrier could be passed to the copies of the core in other packages
+type Carrier = interface {
+	Get(key string) string
+	Set(key, value string)
+}
+
+// Propagator injects the span context into the carrier, and extracts it from the carrier of the upstream
+type Propagator = interface {
+	Inject(ctx *SpanContext, carrier Carrier)
+	// Extract returns nil if the carrier doesn't contain the context
+	Extract(carrier Carrier) *SpanContext
+	// Fields returns the header names used by the propagator
+	Fields() []string
+}
+
+// HeaderCarrier adapts the http.Header, the keys are canonicalized as the HTTP header
+type HeaderCarrier map[string][]string
+
+func (h HeaderCarrier) Get(key string) string {
+	if values := h[canonicalHeaderKey(key)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (h HeaderCarrier) Set(key, value string) {
+	h[canonicalHeaderKey(key)] = []string{value}
+}
+
+// MetadataCarrier adapts the gRPC metadata, the keys are lower case
+type MetadataCarrier map[string][]string
+
+func (m MetadataCarrier) Get(key string) string {
+	if values := m[strings.ToLower(key)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (m MetadataCarrier) Set(key, value string) {
+	m[strings.ToLower(key)] = []string{value}
+}
+
+// MapCarrier adapts the message headers, the keys are case-insensitive when reading
+type MapCarrier map[string]string
+
+func (m MapCarrier) Get(key string) string {
+	if value, ok := m[key]; ok {
+		return value
+	}
+	for k, v := range m {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+func (m MapCarrier) Set(key, value string) {
+	m[key] = value
+}
+
+// GetPropagator returns the propagator used by the tracer, it's the sw8 propagator by default
+func GetPropagator() Propagator {
+	if propagator, ok := globalValue(propagatorGlobal).(Propagator); ok && propagator != nil {
+		return propagator
+	}
+	return sw8Propagator{}
+}
+
+// SetPropagator changes the propagator used by the tracer, it's shared by all enhanced packages
+func SetPropagator(propagator Propagator) {
+	setGlobalValue(propagatorGlobal, propagator)
+}
+
+// NewPropagator builds the propagator by the names, such as "sw8,tracecontext,baggage".
+// The propagators are composited when multiple names are given
+func NewPropagator(names ...string) (Propagator, error) {
+	propagators := make([]Propagator, 0)
+	for _, name := range strings.Split(strings.Join(names, ","), ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "":
+			continue
+		case PropagatorSW8:
+			propagators = append(propagators, sw8Propagator{})
+		case PropagatorTraceContext:
+			propagators = append(propagators, traceContextPropagator{})
+		case PropagatorBaggage:
+			propagators = append(propagators, baggagePropagator{})
+		case PropagatorB3:
+			propagators = append(propagators, b3Propagator{single: true})
+		case PropagatorB3Multi:
+			propagators = append(propagators, b3Propagator{})
+		default:
+			return nil, fmt.Errorf("unknown propagator: %s", name)
+		}
+	}
+	if len(propagators) == 0 {
+		return nil, fmt.Errorf("no propagator")
+	}
+	return CompositePropagator(propagators...), nil
+}
+
+// CompositePropagator injects the context by all propagators, and extracts the context by the first propagator
+// which found it, the baggage and trace state found by the others are merged into it
+func CompositePropagator(propagators ...Propagator) Propagator {
+	if len(propagators) == 1 {
+		return propagators[0]
+	}
+	return compositePropagator(propagators)
+}
+
+type compositePropagator []Propagator
+
+func (c compositePropagator) Inject(ctx *SpanContext, carrier Carrier) {
+	for _, p := range c {
+		p.Inject(ctx, carrier)
+	}
+}
+
+func (c compositePropagator) Extract(carrier Carrier) *SpanContext {
+	var result *SpanContext
+	for _, p := range c {
+		ctx := p.Extract(carrier)
+		if ctx == nil {
+			continue
+		}
+		if result == nil {
+			result = ctx
+			continue
+		}
+		if result.TraceID == "" && ctx.TraceID != "" {
+			result, ctx = ctx, result
+		}
+		if result.TraceState == "" {
+			result.TraceState = ctx.TraceState
+		}
+		for _, item := range ctx.Baggage {
+			if _, exist := findBaggage(result.Baggage, item.Key); !exist {
+				result.Baggage = append(result.Baggage, item)
+			}
+		}
+	}
+	return result
+}
+
+func (c compositePropagator) Fields() []string {
+	result := make([]string, 0)
+	for _, p := range c {
+		result = append(result, p.Fields()...)
+	}
+	return result
+}
+
+// sw8Propagator propagates by the SkyWalking cross process propagation headers protocol v3
+type sw8Propagator struct{}
+
+func (sw8Propagator) Inject(ctx *SpanContext, carrier Carrier) {
+	if ctx.TraceID != "" {
+		carrier.Set(sw8Header, strings.Join([]string{
+			sampledFlag(ctx.Sampled, "1", "0"),
+			encodeBase64(ctx.TraceID),
+			encodeBase64(ctx.ParentSegmentID),
+			strconv.FormatInt(int64(ctx.ParentSpanID), 10),
+			encodeBase64(ctx.ParentService),
+			encodeBase64(ctx.ParentServiceInstance),
+			encodeBase64(ctx.ParentEndpoint),
+			encodeBase64(ctx.NetworkAddress),
+		}, "-"))
+	}
+	if len(ctx.Baggage) > 0 {
+		pairs := make([]string, 0, len(ctx.Baggage))
+		for _, item := range ctx.Baggage {
+			pairs = append(pairs, encodeBase64(item.Key)+":"+encodeBase64(item.Value))
+		}
+		carrier.Set(sw8CorrelationHeader, strings.Join(pairs, ","))
+	}
+}
+
+func (sw8Propagator) Extract(carrier Carrier) *SpanContext {
+	parts := strings.Split(carrier.Get(sw8Header), "-")
+	if len(parts) != 8 {
+		return nil
+	}
+	decoded := make([]string, len(parts))
+	for i, part := range parts {
+		if i == 0 || i == 3 {
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(part)
+		if err != nil {
+			return nil
+		}
+		decoded[i] = string(value)
+	}
+	spanID, err := strconv.ParseInt(parts[3], 10, 32)
+	if err != nil || decoded[1] == "" || decoded[2] == "" {
+		return nil
+	}
+	ctx := &SpanContext{
+		Sampled:               parts[0] != "0",
+		TraceID:               decoded[1],
+		ParentSegmentID:       decoded[2],
+		ParentSpanID:          int32(spanID),
+		ParentService:         decoded[4],
+		ParentServiceInstance: decoded[5],
+		ParentEndpoint:        decoded[6],
+		NetworkAddress:        decoded[7],
+	}
+	for _, pair := range strings.Split(carrier.Get(sw8CorrelationHeader), ",") {
+		kv := strings.SplitN(pair, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, keyErr := base64.StdEncoding.DecodeString(kv[0])
+		value, valueErr := base64.StdEncoding.DecodeString(kv[1])
+		if keyErr == nil && valueErr == nil && len(key) > 0 {
+			ctx.Baggage = append(ctx.Baggage, Tag{Key: string(key), Value: string(value)})
+		}
+	}
+	return ctx
+}
+
+func (sw8Propagator) Fields() []string {
+	return []string{sw8Header, sw8CorrelationHeader}
+}
+
+// traceContextPropagator propagates by the W3C trace context, the trace state is passed through
+type traceContextPropagator struct{}
+
+func (traceContextPropagator) Inject(ctx *SpanContext, carrier Carrier) {
+	if ctx.TraceID == "" {
+		return
+	}
+	carrier.Set(traceParentHeader, fmt.Sprintf("00-%s-%s-%s", hexTraceID(ctx.TraceID, false),
+		hexSpanID(ctx.ParentSegmentID, ctx.ParentSpanID), sampledFlag(ctx.Sampled, "01", "00")))
+	if ctx.TraceState != "" {
+		carrier.Set(traceStateHeader, ctx.TraceState)
+	}
+}
+
+func (traceContextPropagator) Extract(carrier Carrier) *SpanContext {
+	parts := strings.Split(strings.TrimSpace(carrier.Get(traceParentHeader)), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return nil
+	}
+	if !isHexID(parts[1], 32) || !isHexID(parts[2], 16) || len(parts[3]) != 2 {
+		return nil
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return nil
+	}
+	return &SpanContext{
+		Sampled:         flags&1 == 1,
+		TraceID:         parts[1],
+		ParentSegmentID: parts[2],
+		TraceState:      carrier.Get(traceStateHeader),
+	}
+}
+
+func (traceContextPropagator) Fields() []string {
+	return []string{traceParentHeader, traceStateHeader}
+}
+
+// baggagePropagator propagates the baggage by the W3C baggage header, the properties of the items are dropped
+type baggagePropagator struct{}
+
+func (baggagePropagator) Inject(ctx *SpanContext, carrier Carrier) {
+	if len(ctx.Baggage) == 0 {
+		return
+	}
+	items := make([]string, 0, len(ctx.Baggage))
+	for _, item := range ctx.Baggage {
+		items = append(items, percentEncode(item.Key)+"="+percentEncode(item.Value))
+	}
+	carrier.Set(baggageHeader, strings.Join(items, ","))
+}
+
+func (baggagePropagator) Extract(carrier Carrier) *SpanContext {
+	ctx := &SpanContext{}
+	for _, item := range strings.Split(carrier.Get(baggageHeader), ",") {
+		if i := strings.IndexByte(item, ';'); i >= 0 {
+			item = item[:i]
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, keyErr := percentDecode(strings.TrimSpace(kv[0]))
+		value, valueErr := percentDecode(strings.TrimSpace(kv[1]))
+		if keyErr == nil && valueErr == nil && key != "" {
+			ctx.Baggage = append(ctx.Baggage, Tag{Key: key, Value: value})
+		}
+	}
+	if len(ctx.Baggage) == 0 {
+		return nil
+	}
+	return ctx
+}
+
+func (baggagePropagator) Fields() []string {
+	return []string{baggageHeader}
+}
+
+// b3Propagator propagates by the Zipkin B3 single header or multiple headers
+type b3Propagator struct {
+	single bool
+}
+
+func (b b3Propagator) Inject(ctx *SpanContext, carrier Carrier) {
+	if ctx.TraceID == "" {
+		return
+	}
+	traceID, spanID := hexTraceID(ctx.TraceID, true), hexSpanID(ctx.ParentSegmentID, ctx.ParentSpanID)
+	if b.single {
+		carrier.Set(b3Header, traceID+"-"+spanID+"-"+sampledFlag(ctx.Sampled, "1", "0"))
+		return
+	}
+	carrier.Set(b3TraceIDHeader, traceID)
+	carrier.Set(b3SpanIDHeader, spanID)
+	carrier.Set(b3SampledHeader, sampledFlag(ctx.Sampled, "1", "0"))
+}
+
+func (b b3Propagator) Extract(carrier Carrier) *SpanContext {
+	var traceID, spanID, sampled string
+	if b.single {
+		// the header only contains the sampling decision is ignored, such as "0"
+		parts := strings.Split(strings.TrimSpace(carrier.Get(b3Header)), "-")
+		if len(parts) < 2 {
+			return nil
+		}
+		traceID, spanID = parts[0], parts[1]
+		if len(parts) > 2 {
+			sampled = parts[2]
+		}
+	} else {
+		traceID, spanID, sampled = carrier.Get(b3TraceIDHeader), carrier.Get(b3SpanIDHeader), carrier.Get(b3SampledHeader)
+		if carrier.Get(b3FlagsHeader) == "1" {
+			sampled = "d"
+		}
+	}
+	if !(isHexID(traceID, 16) || isHexID(traceID, 32)) || !isHexID(spanID, 16) {
+		return nil
+	}
+	return &SpanContext{
+		Sampled:         sampled != "0" && sampled != "false",
+		TraceID:         strings.ToLower(traceID),
+		ParentSegmentID: strings.ToLower(spanID),
+	}
+}
+
+func (b b3Propagator) Fields() []string {
+	if b.single {
+		return []string{b3Header}
+	}
+	return []string{b3TraceIDHeader, b3SpanIDHeader, b3ParentSpanIDHeader, b3SampledHeader, b3FlagsHeader}
+}
+
+// hexTraceID converts the trace id to 32 hex characters(B3 also accepts 16), the id is hashed if not hex
+func hexTraceID(id string, allowShort bool) string {
+	if isHexID(id, 32) || (allowShort && isHexID(id, 16)) {
+		return strings.ToLower(id)
+	}
+	if isHexID(id, 16) {
+		return "0000000000000000" + strings.ToLower(id)
+	}
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:16])
+}
+
+// hexSpanID builds the 16 hex characters span id from the segment and the span id in segment, it's same with
+// the span id exported by the OTLP exporter. The span id extracted from the W3C or B3 headers is used directly
+func hexSpanID(segmentID string, spanID int32) string {
+	if spanID == 0 && isHexID(segmentID, 16) {
+		return strings.ToLower(segmentID)
+	}
+	sum := sha256.Sum256([]byte(segmentID))
+	result := make([]byte, 8)
+	copy(result, sum[:4])
+	binary.BigEndian.PutUint32(result[4:], uint32(spanID))
+	return hex.EncodeToString(result)
+}
+
+// isHexID checks the id is hex with the length and not all zero
+func isHexID(id string, length int) bool {
+	if len(id) != length || strings.Trim(id, "0") == "" {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+func sampledFlag(sampled bool, yes, no string) string {
+	if sampled {
+		return yes
+	}
+	return no
+}
+
+func encodeBase64(value string) string {
+	return base64.StdEncoding.EncodeToString([]byte(value))
+}
+
+func findBaggage(baggage []Tag, key string) (string, bool) {
+	for _, item := range baggage {
+		if item.Key == key {
+			return item.Value, true
+		}
+	}
+	return "", false
+}
+
+// percentEncode escapes the characters not allowed in the baggage
+func percentEncode(value string) string {
+	var builder strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c <= ' ' || c >= 0x7f || c == '"' || c == ',' || c == ';' || c == '\\' || c == '%' || c == '=' {
+			builder.WriteString(fmt.Sprintf("%%%02X", c))
+			continue
+		}
+		builder.WriteByte(c)
+	}
+	return builder.String()
+}
+
+func percentDecode(value string) (string, error) {
+	if !strings.Contains(value, "%") {
+		return value, nil
+	}
+	var builder strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '%' {
+			builder.WriteByte(value[i])
+			continue
+		}
+		if i+2 >= len(value) {
+			return "", fmt.Errorf("invalid escape: %s", value)
+		}
+		decoded, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return "", err
+		}
+		builder.WriteByte(decoded[0])
+		i += 2
+	}
+	return builder.String(), nil
+}
+
+// canonicalHeaderKey is same with the textproto.CanonicalMIMEHeaderKey for the valid header names,
+// the net packages are not imported because the core could be copied into them
+func canonicalHeaderKey(key string) string {
+	result := []byte(key)
+	upper := true
+	for i, c := range result {
+		if upper && 'a' <= c && c <= 'z' {
+			result[i] = c - 'a' + 'A'
+		} else if !upper && 'A' <= c && c <= 'Z' {
+			result[i] = c - 'A' + 'a'
+		}
+		upper = c == '-'
+	}
+	return string(result)
+}
@@ -0,0 +1,272 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestPropagator(t *testing.T, names string) Propagator {
+	t.Helper()
+	propagator, err := NewPropagator(names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return propagator
+}
+
+func TestPropagatorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name       string
+		propagator string
+		ctx        *SpanContext
+		headers    MapCarrier   // the injected headers, only the listed headers are checked
+		expected   *SpanContext // the extracted context, same as the injected one when nil
+	}{
+		{
+			name:       "sw8",
+			propagator: PropagatorSW8,
+			ctx: &SpanContext{Sampled: true, TraceID: "trace-1", ParentSegmentID: "segment-1", ParentSpanID: 3,
+				ParentService: "service", ParentServiceInstance: "instance", ParentEndpoint: "GET:/users", NetworkAddress: "127.0.0.1:8080",
+				Baggage: []Tag{{Key: "user", Value: "tom"}, {Key: "region", Value: "a:b,c"}}},
+			headers: MapCarrier{
+				sw8Header:            "1-dHJhY2UtMQ==-c2VnbWVudC0x-3-c2VydmljZQ==-aW5zdGFuY2U=-R0VUOi91c2Vycw==-MTI3LjAuMC4xOjgwODA=",
+				sw8CorrelationHeader: "dXNlcg==:dG9t,cmVnaW9u:YTpiLGM=",
+			},
+		},
+		{
+			name:       "sw8 not sampled",
+			propagator: PropagatorSW8,
+			ctx:        &SpanContext{TraceID: "trace-1", ParentSegmentID: "segment-1"},
+			headers:    MapCarrier{sw8Header: "0-dHJhY2UtMQ==-c2VnbWVudC0x-0----"},
+		},
+		{
+			name:       "traceparent with tracestate",
+			propagator: PropagatorTraceContext,
+			ctx: &SpanContext{Sampled: true, TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", ParentSegmentID: "00f067aa0ba902b7",
+				TraceState: "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7"},
+			headers: MapCarrier{
+				traceParentHeader: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+				traceStateHeader:  "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7",
+			},
+		},
+		{
+			name:       "traceparent from the skywalking ids",
+			propagator: PropagatorTraceContext,
+			ctx:        &SpanContext{TraceID: "trace-1", ParentSegmentID: "segment-1", ParentSpanID: 2},
+			headers: MapCarrier{
+				traceParentHeader: "00-" + hexTraceID("trace-1", false) + "-" + hexSpanID("segment-1", 2) + "-00",
+			},
+			expected: &SpanContext{TraceID: hexTraceID("trace-1", false), ParentSegmentID: hexSpanID("segment-1", 2)},
+		},
+		{
+			name:       "b3 single",
+			propagator: PropagatorB3,
+			ctx:        &SpanContext{Sampled: true, TraceID: "80f198ee56343ba864fe8b2a57d3eff7", ParentSegmentID: "e457b5a2e4d86bd1"},
+			headers:    MapCarrier{b3Header: "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1"},
+		},
+		{
+			name:       "b3 single with the 64 bits trace id",
+			propagator: PropagatorB3,
+			ctx:        &SpanContext{TraceID: "80F198EE56343BA8", ParentSegmentID: "E457B5A2E4D86BD1"},
+			headers:    MapCarrier{b3Header: "80f198ee56343ba8-e457b5a2e4d86bd1-0"},
+			expected:   &SpanContext{TraceID: "80f198ee56343ba8", ParentSegmentID: "e457b5a2e4d86bd1"},
+		},
+		{
+			name:       "b3 multi",
+			propagator: PropagatorB3Multi,
+			ctx:        &SpanContext{Sampled: true, TraceID: "80f198ee56343ba864fe8b2a57d3eff7", ParentSegmentID: "e457b5a2e4d86bd1"},
+			headers: MapCarrier{
+				b3TraceIDHeader: "80f198ee56343ba864fe8b2a57d3eff7",
+				b3SpanIDHeader:  "e457b5a2e4d86bd1",
+				b3SampledHeader: "1",
+			},
+		},
+		{
+			name:       "baggage with the escaped characters",
+			propagator: PropagatorBaggage,
+			ctx:        &SpanContext{Baggage: []Tag{{Key: "user id", Value: "a=b,c;d%e\"f\\"}, {Key: "city", Value: "北京"}}},
+			headers:    MapCarrier{baggageHeader: "user%20id=a%3Db%2Cc%3Bd%25e%22f%5C,city=%E5%8C%97%E4%BA%AC"},
+		},
+	}
+	for _, test := range tests {
+		propagator := newTestPropagator(t, test.propagator)
+		carrier := MapCarrier{}
+		propagator.Inject(test.ctx, carrier)
+		for key, expected := range test.headers {
+			if actual := carrier.Get(key); actual != expected {
+				t.Errorf("%s: the injected header %s: %s, expected: %s", test.name, key, actual, expected)
+			}
+		}
+		for key := range carrier {
+			if !containsField(propagator, key) {
+				t.Errorf("%s: the injected header %s is not in the fields %v", test.name, key, propagator.Fields())
+			}
+		}
+		expected := test.expected
+		if expected == nil {
+			expected = test.ctx
+		}
+		if actual := propagator.Extract(carrier); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%s: the extracted context: %+v, expected: %+v", test.name, actual, expected)
+		}
+	}
+}
+
+func containsField(propagator Propagator, key string) bool {
+	for _, field := range propagator.Fields() {
+		if strings.EqualFold(field, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPropagatorExtract(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	sw8 := func(parts ...string) string {
+		return strings.Join(parts, "-")
+	}
+	tests := []struct {
+		name       string
+		propagator string
+		headers    MapCarrier
+		expected   *SpanContext // nil when the headers are invalid
+	}{
+		{name: "sw8 missing", propagator: PropagatorSW8, headers: MapCarrier{}},
+		{name: "sw8 truncated", propagator: PropagatorSW8, headers: MapCarrier{sw8Header: sw8("1", "dHJhY2UtMQ==", "c2VnbWVudC0x", "3", "", "", "")}},
+		{name: "sw8 invalid base64 trace id", propagator: PropagatorSW8, headers: MapCarrier{sw8Header: sw8("1", "dHJhY2UtMQ=", "c2VnbWVudC0x", "3", "", "", "", "")}},
+		{name: "sw8 invalid base64 endpoint", propagator: PropagatorSW8, headers: MapCarrier{sw8Header: sw8("1", "dHJhY2UtMQ==", "c2VnbWVudC0x", "3", "", "", "R0VU*", "")}},
+		{name: "sw8 empty trace id", propagator: PropagatorSW8, headers: MapCarrier{sw8Header: sw8("1", "", "c2VnbWVudC0x", "3", "", "", "", "")}},
+		{name: "sw8 invalid span id", propagator: PropagatorSW8, headers: MapCarrier{sw8Header: sw8("1", "dHJhY2UtMQ==", "c2VnbWVudC0x", "x", "", "", "", "")}},
+		{name: "sw8 span id overflow", propagator: PropagatorSW8, headers: MapCarrier{sw8Header: sw8("1", "dHJhY2UtMQ==", "c2VnbWVudC0x", "2147483648", "", "", "", "")}},
+		{
+			name:       "sw8 skips the invalid correlation",
+			propagator: PropagatorSW8,
+			headers: MapCarrier{
+				"SW8":                sw8("1", "dHJhY2UtMQ==", "c2VnbWVudC0x", "0", "", "", "", ""),
+				sw8CorrelationHeader: "dXNlcg==:dG9t,bad,ZW1wdHk=:,:dG9t,cmVnaW9u:!!",
+			},
+			expected: &SpanContext{Sampled: true, TraceID: "trace-1", ParentSegmentID: "segment-1",
+				Baggage: []Tag{{Key: "user", Value: "tom"}, {Key: "empty", Value: ""}}},
+		},
+		{
+			name:       "traceparent",
+			propagator: PropagatorTraceContext,
+			headers:    MapCarrier{traceParentHeader: " 00-" + traceID + "-" + spanID + "-03 ", traceStateHeader: "rojo=1"},
+			expected:   &SpanContext{Sampled: true, TraceID: traceID, ParentSegmentID: spanID, TraceState: "rojo=1"},
+		},
+		{
+			name:       "traceparent of the future version",
+			propagator: PropagatorTraceContext,
+			headers:    MapCarrier{traceParentHeader: "cc-" + traceID + "-" + spanID + "-00-extra"},
+			expected:   &SpanContext{TraceID: traceID, ParentSegmentID: spanID},
+		},
+		{name: "traceparent version 00 with extra", propagator: PropagatorTraceContext, headers: MapCarrier{traceParentHeader: "00-" + traceID + "-" + spanID + "-01-extra"}},
+		{name: "traceparent version ff", propagator: PropagatorTraceContext, headers: MapCarrier{traceParentHeader: "ff-" + traceID + "-" + spanID + "-01"}},
+		{name: "traceparent truncated", propagator: PropagatorTraceContext, headers: MapCarrier{traceParentHeader: "00-" + traceID + "-" + spanID}},
+		{name: "traceparent short trace id", propagator: PropagatorTraceContext, headers: MapCarrier{traceParentHeader: "00-" + traceID[1:] + "-" + spanID + "-01"}},
+		{name: "traceparent zero trace id", propagator: PropagatorTraceContext, headers: MapCarrier{traceParentHeader: "00-" + strings.Repeat("0", 32) + "-" + spanID + "-01"}},
+		{name: "traceparent zero span id", propagator: PropagatorTraceContext, headers: MapCarrier{traceParentHeader: "00-" + traceID + "-" + strings.Repeat("0", 16) + "-01"}},
+		{name: "traceparent invalid hex", propagator: PropagatorTraceContext, headers: MapCarrier{traceParentHeader: "00-" + strings.Repeat("z", 32) + "-" + spanID + "-01"}},
+		{name: "traceparent invalid flags", propagator: PropagatorTraceContext, headers: MapCarrier{traceParentHeader: "00-" + traceID + "-" + spanID + "-0x"}},
+		{
+			name:       "b3 single with the parent span id",
+			propagator: PropagatorB3,
+			headers:    MapCarrier{b3Header: "80F198EE56343BA8-E457B5A2E4D86BD1-d-05e3ac9a4f6e3b90"},
+			expected:   &SpanContext{Sampled: true, TraceID: "80f198ee56343ba8", ParentSegmentID: "e457b5a2e4d86bd1"},
+		},
+		{
+			name:       "b3 single without the sampling decision",
+			propagator: PropagatorB3,
+			headers:    MapCarrier{b3Header: "80f198ee56343ba8-e457b5a2e4d86bd1"},
+			expected:   &SpanContext{Sampled: true, TraceID: "80f198ee56343ba8", ParentSegmentID: "e457b5a2e4d86bd1"},
+		},
+		{name: "b3 single only the sampling decision", propagator: PropagatorB3, headers: MapCarrier{b3Header: "0"}},
+		{name: "b3 single invalid trace id length", propagator: PropagatorB3, headers: MapCarrier{b3Header: "80f198ee56343ba864-e457b5a2e4d86bd1-1"}},
+		{name: "b3 single invalid hex span id", propagator: PropagatorB3, headers: MapCarrier{b3Header: "80f198ee56343ba8-e457b5a2e4d86bdz-1"}},
+		{
+			name:       "b3 multi not sampled",
+			propagator: PropagatorB3Multi,
+			headers:    MapCarrier{"X-B3-TraceId": traceID, "X-B3-SpanId": spanID, "X-B3-Sampled": "false"},
+			expected:   &SpanContext{TraceID: traceID, ParentSegmentID: spanID},
+		},
+		{
+			name:       "b3 multi debug",
+			propagator: PropagatorB3Multi,
+			headers:    MapCarrier{b3TraceIDHeader: traceID, b3SpanIDHeader: spanID, b3SampledHeader: "0", b3FlagsHeader: "1"},
+			expected:   &SpanContext{Sampled: true, TraceID: traceID, ParentSegmentID: spanID},
+		},
+		{name: "b3 multi missing span id", propagator: PropagatorB3Multi, headers: MapCarrier{b3TraceIDHeader: traceID, b3SampledHeader: "1"}},
+		{name: "b3 multi invalid hex trace id", propagator: PropagatorB3Multi, headers: MapCarrier{b3TraceIDHeader: "x" + traceID[1:], b3SpanIDHeader: spanID}},
+		{
+			name:       "baggage with the properties and spaces",
+			propagator: PropagatorBaggage,
+			headers:    MapCarrier{baggageHeader: "k1=v1;prop=1, k2 = v%202 ,=empty,novalue,k3=%E4%BA%AC"},
+			expected:   &SpanContext{Baggage: []Tag{{Key: "k1", Value: "v1"}, {Key: "k2", Value: "v 2"}, {Key: "k3", Value: "京"}}},
+		},
+		{
+			name:       "baggage skips the invalid escapes",
+			propagator: PropagatorBaggage,
+			headers:    MapCarrier{baggageHeader: "k1=%zz,k2=v%4,k3=%4,k4=v%"},
+		},
+		{name: "baggage missing", propagator: PropagatorBaggage, headers: MapCarrier{}},
+		{
+			name:       "composite merges the tracestate and baggage",
+			propagator: "baggage,sw8,tracecontext",
+			headers: MapCarrier{
+				sw8Header:            sw8("0", "dHJhY2UtMQ==", "c2VnbWVudC0x", "1", "", "", "", ""),
+				sw8CorrelationHeader: "dXNlcg==:dG9t",
+				traceParentHeader:    "00-" + traceID + "-" + spanID + "-01",
+				traceStateHeader:     "rojo=1",
+				baggageHeader:        "user=jerry,city=beijing",
+			},
+			expected: &SpanContext{TraceID: "trace-1", ParentSegmentID: "segment-1", ParentSpanID: 1, TraceState: "rojo=1",
+				Baggage: []Tag{{Key: "user", Value: "tom"}, {Key: "city", Value: "beijing"}}},
+		},
+		{
+			name:       "composite falls back to the next propagator",
+			propagator: "sw8,b3multi",
+			headers:    MapCarrier{sw8Header: "invalid", b3TraceIDHeader: traceID, b3SpanIDHeader: spanID},
+			expected:   &SpanContext{Sampled: true, TraceID: traceID, ParentSegmentID: spanID},
+		},
+	}
+	for _, test := range tests {
+		propagator := newTestPropagator(t, test.propagator)
+		if actual := propagator.Extract(test.headers); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: the extracted context: %+v, expected: %+v", test.name, actual, test.expected)
+		}
+	}
+}
+
+func TestCarriers(t *testing.T) {
+	header := HeaderCarrier{}
+	header.Set("x-b3-traceid", "1")
+	if _, ok := header["X-B3-Traceid"]; !ok || header.Get("X-B3-TRACEID") != "1" {
+		t.Errorf("the header key is not canonicalized: %v", header)
+	}
+	metadata := MetadataCarrier{}
+	metadata.Set("SW8", "1")
+	if _, ok := metadata["sw8"]; !ok || metadata.Get("Sw8") != "1" {
+		t.Errorf("the metadata key is not lower case: %v", metadata)
+	}
+	if value := (MapCarrier{"Traceparent": "1"}).Get(traceParentHeader); value != "1" {
+		t.Errorf("the map carrier is case sensitive: %s", value)
+	}
+}
+
+func TestNewPropagator(t *testing.T) {
+	propagator := newTestPropagator(t, " SW8 , b3,")
+	expected := []string{sw8Header, sw8CorrelationHeader, b3Header}
+	if fields := propagator.Fields(); !reflect.DeepEqual(fields, expected) {
+		t.Errorf("the fields: %v, expected: %v", fields, expected)
+	}
+	for names, expected := range map[string]string{"sw8,jaeger": "unknown propagator: jaeger", " , ": "no propagator"} {
+		if _, err := NewPropagator(names); err == nil || err.Error() != expected {
+			t.Errorf("%q: the error %v, expected: %s", names, err, expected)
+		}
+	}
+}
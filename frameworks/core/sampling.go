@@ -0,0 +1,154 @@
+package core
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the global name of the sampler
+const samplerGlobal = "sampler"
+
+// Sampler decides whether the trace starting in this process is sampled, it's invoked when creating the span
+// without active span. The carrier and upstream are nil if the span is not the entry span or no upstream.
+// The spans of the unsampled trace are not created, but the context is still propagated with the unsampled flag
+type Sampler = interface {
+	Sample(operationName string, carrier Carrier, upstream *SpanContext) bool
+}
+
+// SamplingRule limits the traces to be sampled
+type SamplingRule struct {
+	// the max traces sampled per second, no limit if 0, none sampled if negative
+	Rate int
+	// the probability of a trace to be sampled in (0, 1], all sampled if 0
+	Probability float64
+}
+
+type SamplingOptions struct {
+	Default SamplingRule
+	// overrides the default rule by the operation name of the span, the key could be the pattern of path.Match,
+	// such as "GET:/users/*". The exact name is matched first, then the longer pattern
+	Routes map[string]SamplingRule
+	// the trace is always sampled when the header value is "1" or "true", such as "sw-force-sample"
+	ForceHeader string
+	// makes the decision by the rules even when the upstream has decided
+	IgnoreUpstream bool
+}
+
+// GetSampler returns the sampler used by the tracer, all traces are sampled by default except the upstream is unsampled
+func GetSampler() Sampler {
+	if sampler, ok := globalValue(samplerGlobal).(Sampler); ok && sampler != nil {
+		return sampler
+	}
+	return defaultSampler
+}
+
+// SetSampler changes the sampler used by the tracer, it's shared by all enhanced packages
+func SetSampler(sampler Sampler) {
+	setGlobalValue(samplerGlobal, sampler)
+}
+
+var defaultSampler = NewSampler(SamplingOptions{})
+
+// NewSampler creates the sampler by the rules. The decision is made in the order:
+// the force header, the sampled flag of upstream, then the probability and rate of the matched rule
+func NewSampler(opts SamplingOptions) Sampler {
+	s := &ruleSampler{
+		opts:        opts,
+		defaultRule: newRuleLimiter(opts.Default),
+		routes:      make(map[string]*ruleLimiter, len(opts.Routes)),
+	}
+	for route, rule := range opts.Routes {
+		s.routes[route] = newRuleLimiter(rule)
+		s.patterns = append(s.patterns, route)
+	}
+	sort.Slice(s.patterns, func(i, j int) bool {
+		if len(s.patterns[i]) != len(s.patterns[j]) {
+			return len(s.patterns[i]) > len(s.patterns[j])
+		}
+		return s.patterns[i] < s.patterns[j]
+	})
+	return s
+}
+
+type ruleSampler struct {
+	opts        SamplingOptions
+	defaultRule *ruleLimiter
+	routes      map[string]*ruleLimiter
+	patterns    []string // the route keys, sorted by the length descending
+}
+
+func (s *ruleSampler) Sample(operationName string, carrier Carrier, upstream *SpanContext) bool {
+	if s.opts.ForceHeader != "" && carrier != nil {
+		if value := strings.ToLower(carrier.Get(s.opts.ForceHeader)); value == "1" || value == "true" {
+			return true
+		}
+	}
+	if !s.opts.IgnoreUpstream && upstream != nil && upstream.TraceID != "" {
+		return upstream.Sampled
+	}
+	return s.rule(operationName).sample(time.Now())
+}
+
+func (s *ruleSampler) rule(operationName string) *ruleLimiter {
+	if rule, ok := s.routes[operationName]; ok {
+		return rule
+	}
+	for _, pattern := range s.patterns {
+		if matched, _ := path.Match(pattern, operationName); matched {
+			return s.routes[pattern]
+		}
+	}
+	return s.defaultRule
+}
+
+// ruleLimiter samples by the probability, then limits the sampled count in every second
+type ruleLimiter struct {
+	rule SamplingRule
+
+	lock   sync.Mutex
+	second int64
+	count  int
+	seed   uint64 // the state of the random, only used for the probability
+}
+
+func newRuleLimiter(rule SamplingRule) *ruleLimiter {
+	return &ruleLimiter{rule: rule, seed: uint64(time.Now().UnixNano()) | 1}
+}
+
+// sample decides at the time, the count is reset in every second
+func (r *ruleLimiter) sample(now time.Time) bool {
+	if r.rule.Rate < 0 {
+		return false
+	}
+	probability := r.rule.Probability > 0 && r.rule.Probability < 1
+	if r.rule.Rate == 0 && !probability {
+		return true
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if probability && r.random() >= r.rule.Probability {
+		return false
+	}
+	if r.rule.Rate == 0 {
+		return true
+	}
+	if second := now.Unix(); second != r.second {
+		r.second, r.count = second, 0
+	}
+	if r.count >= r.rule.Rate {
+		return false
+	}
+	r.count++
+	return true
+}
+
+// random returns the number in [0, 1) by the xorshift, guarded by the lock
+func (r *ruleLimiter) random() float64 {
+	r.seed ^= r.seed << 13
+	r.seed ^= r.seed >> 7
+	r.seed ^= r.seed << 17
+	return float64(r.seed>>11) / (1 << 53)
+}
@@ -0,0 +1,143 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+// sampledCount samples the times at the time, returns the sampled count
+func sampledCount(limiter *ruleLimiter, now time.Time, times int) int {
+	count := 0
+	for i := 0; i < times; i++ {
+		if limiter.sample(now) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestRuleLimiterRate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	limiter := newRuleLimiter(SamplingRule{Rate: 3})
+	if count := sampledCount(limiter, now, 10); count != 3 {
+		t.Errorf("sampled in the first second: %d, expected: 3", count)
+	}
+	// same second, the limit is reached
+	if count := sampledCount(limiter, now.Add(999*time.Millisecond), 10); count != 0 {
+		t.Errorf("sampled after the limit reached: %d", count)
+	}
+	// the count is reset in the next second
+	if count := sampledCount(limiter, now.Add(time.Second), 10); count != 3 {
+		t.Errorf("sampled in the next second: %d, expected: 3", count)
+	}
+
+	for rate, expected := range map[int]int{0: 10, -1: 0} {
+		if count := sampledCount(newRuleLimiter(SamplingRule{Rate: rate}), now, 10); count != expected {
+			t.Errorf("sampled by the rate %d: %d, expected: %d", rate, count, expected)
+		}
+	}
+}
+
+func TestRuleLimiterProbability(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	// out of (0, 1) means all sampled
+	for _, probability := range []float64{0, 1, 1.5, -0.5} {
+		if count := sampledCount(newRuleLimiter(SamplingRule{Probability: probability}), now, 1000); count != 1000 {
+			t.Errorf("sampled by the probability %v: %d, expected: 1000", probability, count)
+		}
+	}
+
+	const times = 20000
+	for _, probability := range []float64{0.01, 0.25, 0.5, 0.99} {
+		limiter := newRuleLimiter(SamplingRule{Probability: probability})
+		limiter.seed = 1
+		ratio := float64(sampledCount(limiter, now, times)) / times
+		if ratio < probability-0.02 || ratio > probability+0.02 {
+			t.Errorf("sampled ratio by the probability %v: %v", probability, ratio)
+		}
+	}
+	for _, seed := range []uint64{1, 42, uint64(time.Now().UnixNano()) | 1} {
+		limiter := &ruleLimiter{seed: seed}
+		for i := 0; i < times; i++ {
+			if value := limiter.random(); value < 0 || value >= 1 {
+				t.Fatalf("the random of the seed %d is out of [0, 1): %v", seed, value)
+			}
+		}
+	}
+
+	// the rate limits the traces sampled by the probability
+	limiter := newRuleLimiter(SamplingRule{Probability: 0.5, Rate: 100})
+	limiter.seed = 1
+	if count := sampledCount(limiter, now, times); count != 100 {
+		t.Errorf("sampled by the probability and rate: %d, expected: 100", count)
+	}
+}
+
+func TestRuleSamplerRoutes(t *testing.T) {
+	s := NewSampler(SamplingOptions{
+		Default: SamplingRule{Rate: 10},
+		Routes: map[string]SamplingRule{
+			"GET:/users/admin": {Rate: 1},
+			"GET:/users/*":     {Rate: 2},
+			"GET:/*":           {Rate: 3},
+			"*:/orders":        {Rate: 4},
+			"GET:/order?":      {Rate: 5},
+			"GET:/o*ders":      {Rate: 6},
+		},
+	}).(*ruleSampler)
+	tests := map[string]string{
+		"GET:/users/admin": "GET:/users/admin", // the exact name first
+		"GET:/users/tom":   "GET:/users/*",     // then the longer pattern
+		"GET:/users":       "GET:/*",
+		"POST:/orders":     "*:/orders",
+		"GET:/orderx":      "GET:/order?",
+		"GET:/orders":      "GET:/o*ders", // same length, by the lexical order
+		"GET:/users/a/b":   "",            // the wildcard doesn't match the separator
+		"PUT:/users/tom":   "",
+	}
+	for name, route := range tests {
+		expected := s.defaultRule
+		if route != "" {
+			expected = s.routes[route]
+		}
+		if actual := s.rule(name); actual != expected {
+			t.Errorf("the rule of %s: %+v, expected: %+v", name, actual.rule, expected.rule)
+		}
+	}
+}
+
+func TestRuleSamplerDecision(t *testing.T) {
+	none := SamplingOptions{Default: SamplingRule{Rate: -1}, ForceHeader: "sw-force-sample"}
+	tests := []struct {
+		name     string
+		opts     SamplingOptions
+		carrier  Carrier
+		upstream *SpanContext
+		expected bool
+	}{
+		{name: "the rule", opts: none, expected: false},
+		{name: "the force header", opts: none, carrier: MapCarrier{"Sw-Force-Sample": "TRUE"}, expected: true},
+		{name: "the invalid force header", opts: none, carrier: MapCarrier{"sw-force-sample": "yes"}, expected: false},
+		{name: "the sampled upstream", opts: none, upstream: &SpanContext{TraceID: "trace-1", Sampled: true}, expected: true},
+		{name: "the upstream without trace", opts: none, upstream: &SpanContext{Sampled: true}, expected: false},
+		{name: "the unsampled upstream", opts: SamplingOptions{}, upstream: &SpanContext{TraceID: "trace-1"}, expected: false},
+		{
+			name:     "the force header before the upstream",
+			opts:     none,
+			carrier:  MapCarrier{"sw-force-sample": "1"},
+			upstream: &SpanContext{TraceID: "trace-1"},
+			expected: true,
+		},
+		{
+			name:     "ignore the upstream",
+			opts:     SamplingOptions{IgnoreUpstream: true},
+			upstream: &SpanContext{TraceID: "trace-1"},
+			expected: true,
+		},
+	}
+	for _, test := range tests {
+		if actual := NewSampler(test.opts).Sample("GET:/users", test.carrier, test.upstream); actual != test.expected {
+			t.Errorf("%s: sampled %t, expected: %t", test.name, actual, test.expected)
+		}
+	}
+}
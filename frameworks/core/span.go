@@ -0,0 +1,196 @@
+package core
+
+import (
+	"context"
+	"time"
+)
+
+// Span is the operation in the trace, all methods are no-op for the noop span.
+// The span of the unsampled trace is noop, but still propagates the context
+type Span struct {
+	span *tracingSpan
+
+	unsampled *SpanContext // the context of the unsampled trace
+	previous  interface{}  // the active span restored when the span starting the unsampled trace ends
+	activated bool
+}
+
+// IsValid returns false for the noop span
+func (s *Span) IsValid() bool {
+	return s != nil && s.span != nil
+}
+
+// IsSampled returns false if the span is noop because the trace is unsampled
+func (s *Span) IsSampled() bool {
+	return s != nil && s.unsampled == nil
+}
+
+// TraceID returns the trace id, it's also available for the unsampled trace
+func (s *Span) TraceID() string {
+	if s != nil && s.unsampled != nil {
+		return s.unsampled.TraceID
+	}
+	if !s.IsValid() {
+		return ""
+	}
+	return s.span.Segment.Data.TraceID
+}
+
+func (s *Span) SegmentID() string {
+	if !s.IsValid() {
+		return ""
+	}
+	return s.span.Segment.Data.SegmentID
+}
+
+func (s *Span) SpanID() int32 {
+	if !s.IsValid() {
+		return -1
+	}
+	return s.span.Data.SpanID
+}
+
+func (s *Span) SetOperationName(name string) {
+	if s.IsValid() {
+		s.span.Data.OperationName = name
+	}
+}
+
+func (s *Span) SetPeer(peer string) {
+	if s.IsValid() {
+		s.span.Data.Peer = peer
+	}
+}
+
+func (s *Span) SetComponent(componentID int32) {
+	if s.IsValid() {
+		s.span.Data.ComponentID = componentID
+	}
+}
+
+func (s *Span) SetLayer(layer int32) {
+	if s.IsValid() {
+		s.span.Data.Layer = layer
+	}
+}
+
+func (s *Span) Tag(key, value string) {
+	if s.IsValid() {
+		s.span.Data.Tags = append(s.span.Data.Tags, Tag{Key: key, Value: value})
+	}
+}
+
+// Log records the event of the span, the fields are the key value pairs
+func (s *Span) Log(fields ...string) {
+	if !s.IsValid() {
+		return
+	}
+	log := LogData{Time: time.Now()}
+	for i := 0; i+1 < len(fields); i += 2 {
+		log.Fields = append(log.Fields, Tag{Key: fields[i], Value: fields[i+1]})
+	}
+	s.span.Data.Logs = append(s.span.Data.Logs, log)
+}
+
+// Error marks the span as error and logs the error
+func (s *Span) Error(err error, fields ...string) {
+	if !s.IsValid() {
+		return
+	}
+	s.span.Data.IsError = true
+	if err != nil {
+		s.Log(append([]string{"event", "error", "message", err.Error()}, fields...)...)
+	}
+}
+
+// Context returns a copy of the ctx which carries the span, so the span could be found by the ctx
+func (s *Span) Context(ctx context.Context) context.Context {
+	if s != nil && s.unsampled != nil {
+		return ContextWithSpan(ctx, s.unsampled)
+	}
+	if !s.IsValid() {
+		return ctx
+	}
+	return ContextWithSpan(ctx, s.span)
+}
+
+// Inject writes the context of the span into the carrier by the propagator, the downstream continues the trace
+func (s *Span) Inject(carrier Carrier) {
+	if carrier == nil || s == nil {
+		return
+	}
+	if s.unsampled != nil {
+		GetPropagator().Inject(s.unsampled, carrier)
+	} else if s.IsValid() {
+		GetPropagator().Inject(spanContext(s.span), carrier)
+	}
+}
+
+// Baggage returns the value of the baggage item, the baggage is propagated with the trace
+func (s *Span) Baggage(key string) string {
+	if s != nil && s.unsampled != nil {
+		value, _ := findBaggage(s.unsampled.Baggage, key)
+		return value
+	}
+	if !s.IsValid() {
+		return ""
+	}
+	s.span.Segment.Lock.Lock()
+	defer s.span.Segment.Lock.Unlock()
+	value, _ := findBaggage(s.span.Segment.Baggage, key)
+	return value
+}
+
+// SetBaggage adds or updates the baggage item, it's visible to the spans of the trace created after it.
+// The baggage of the unsampled trace is read only
+func (s *Span) SetBaggage(key, value string) {
+	if !s.IsValid() {
+		return
+	}
+	segment := s.span.Segment
+	segment.Lock.Lock()
+	defer segment.Lock.Unlock()
+	for i := range segment.Baggage {
+		if segment.Baggage[i].Key == key {
+			segment.Baggage[i].Value = value
+			return
+		}
+	}
+	segment.Baggage = append(segment.Baggage, Tag{Key: key, Value: value})
+}
+
+// End finishes the span, the previous active span becomes active again.
+// The segment is reported when all of its spans are finished
+func (s *Span) End() {
+	if s != nil && s.activated {
+		s.activated = false
+		if ActiveSpan() == interface{}(s.unsampled) {
+			SetActiveSpan(s.previous)
+		}
+		return
+	}
+	if !s.IsValid() {
+		return
+	}
+	span := s.span
+	segment := span.Segment
+	segment.Lock.Lock()
+	if span.Ended {
+		segment.Lock.Unlock()
+		return
+	}
+	span.Ended = true
+	span.Data.EndTime = time.Now()
+	segment.Data.Spans = append(segment.Data.Spans, span.Data)
+	segment.Opening--
+	segment.Finished = segment.Opening == 0
+	finished := segment.Finished
+	segment.Lock.Unlock()
+
+	if ActiveSpan() == interface{}(span) {
+		SetActiveSpan(span.Previous)
+	}
+	if finished {
+		reportSegment(segment.Data)
+	}
+}
@@ -0,0 +1,84 @@
+package core
+
+import (
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// the global names of the interceptor switches(map[string]*int32, keyed by "<plugin>/<interceptor>", 0 is disabled)
+// and the names disabled by the agent([]string)
+const (
+	interceptorSwitchesGlobal  = "interceptor-switches"
+	disabledInterceptorsGlobal = "disabled-interceptors"
+)
+
+// interceptorSwitch registers the switch of the interceptor, it's invoked by the generated adapter when the package initializing,
+// and the adapter checks it before every invocation, so the interceptors could be disabled without rebuilding
+func interceptorSwitch(plugin, interceptor string) *int32 {
+	name := plugin + "/" + interceptor
+	switches, _ := globalValue(interceptorSwitchesGlobal).(map[string]*int32)
+	if s := switches[name]; s != nil {
+		return s
+	}
+	s := new(int32)
+	disabled, _ := globalValue(disabledInterceptorsGlobal).([]string)
+	if !interceptorDisabled(disabled, name) {
+		*s = 1
+	}
+	// the packages are initialized one by one, so copying the map is enough
+	copied := make(map[string]*int32, len(switches)+1)
+	for k, v := range switches {
+		copied[k] = v
+	}
+	copied[name] = s
+	setGlobalValue(interceptorSwitchesGlobal, copied)
+	return s
+}
+
+// interceptorEnabled is checked by the adapter, the interceptor is skipped when disabled
+func interceptorEnabled(s *int32) bool {
+	return atomic.LoadInt32(s) != 0
+}
+
+// SetDisabledInterceptors disables the interceptors by the names and enables the others, the change takes effect immediately.
+// The name is "<plugin>/<interceptor>" such as "gin/ServerHTTPInterceptor", or the plugin name to disable all of its interceptors
+func SetDisabledInterceptors(names []string) {
+	disabled := append([]string(nil), names...)
+	setGlobalValue(disabledInterceptorsGlobal, disabled)
+	switches, _ := globalValue(interceptorSwitchesGlobal).(map[string]*int32)
+	for name, s := range switches {
+		value := int32(1)
+		if interceptorDisabled(disabled, name) {
+			value = 0
+		}
+		atomic.StoreInt32(s, value)
+	}
+}
+
+// InterceptorSwitches returns the names of the interceptors in the enhanced packages and whether they are enabled
+func InterceptorSwitches() map[string]bool {
+	switches, _ := globalValue(interceptorSwitchesGlobal).(map[string]*int32)
+	result := make(map[string]bool, len(switches))
+	for name, s := range switches {
+		result[name] = interceptorEnabled(s)
+	}
+	return result
+}
+
+// DisabledInterceptors returns the names disabled by SetDisabledInterceptors, sorted
+func DisabledInterceptors() []string {
+	disabled, _ := globalValue(disabledInterceptorsGlobal).([]string)
+	result := append([]string(nil), disabled...)
+	sort.Strings(result)
+	return result
+}
+
+func interceptorDisabled(disabled []string, name string) bool {
+	for _, d := range disabled {
+		if d == name || strings.HasPrefix(name, d+"/") {
+			return true
+		}
+	}
+	return false
+}
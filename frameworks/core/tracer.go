@@ -0,0 +1,262 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// the global names of the segment reporter and the service
+const (
+	segmentReporterGlobal = "segment-reporter"
+	serviceGlobal         = "service"
+)
+
+// the service and instance name of the process, propagated to the downstream services
+type serviceInstance = struct {
+	Service  string
+	Instance string
+}
+
+var defaultTracer = &Tracer{}
+
+// the fallback of the ID generation when the random is unavailable
+var idSequence int64
+
+// Tracer creates the spans, the spans link to the active span of the goroutine as the parent
+type Tracer struct {
+}
+
+// GetTracer returns the tracer, the spans created by it are reported by the reporter set by SetSegmentReporter
+func GetTracer() *Tracer {
+	return defaultTracer
+}
+
+// SetSegmentReporter changes the reporter for the finished segments, it's shared by all enhanced packages.
+// The reporter is invoked on the goroutine which ends the last span of segment, so it should never block
+func SetSegmentReporter(reporter func(segment *SegmentData)) {
+	setGlobalValue(segmentReporterGlobal, reporter)
+}
+
+// SetServiceInstance changes the service and instance name propagated to the downstream services
+func SetServiceInstance(service, instance string) {
+	setGlobalValue(serviceGlobal, serviceInstance{Service: service, Instance: instance})
+}
+
+type SpanOption func(span *SpanData)
+
+func WithTag(key, value string) SpanOption {
+	return func(span *SpanData) {
+		span.Tags = append(span.Tags, Tag{Key: key, Value: value})
+	}
+}
+
+func WithComponent(componentID int32) SpanOption {
+	return func(span *SpanData) {
+		span.ComponentID = componentID
+	}
+}
+
+func WithLayer(layer int32) SpanOption {
+	return func(span *SpanData) {
+		span.Layer = layer
+	}
+}
+
+func WithStartTime(t time.Time) SpanOption {
+	return func(span *SpanData) {
+		span.StartTime = t
+	}
+}
+
+// CreateEntrySpan creates the span for the request received by the service, the span continues the trace
+// extracted from the carrier by the propagator when no active span. The carrier could be nil
+func (t *Tracer) CreateEntrySpan(operationName string, carrier Carrier, opts ...SpanOption) *Span {
+	return t.createSpan(SpanKindEntry, operationName, "", carrier, opts)
+}
+
+// CreateLocalSpan creates the span for the operation in the service
+func (t *Tracer) CreateLocalSpan(operationName string, opts ...SpanOption) *Span {
+	return t.createSpan(SpanKindLocal, operationName, "", nil, opts)
+}
+
+// CreateExitSpan creates the span for the request sent to the peer, the span context is injected into
+// the carrier by the propagator. The carrier could be nil, then inject it by Span.Inject
+func (t *Tracer) CreateExitSpan(operationName, peer string, carrier Carrier, opts ...SpanOption) *Span {
+	return t.createSpan(SpanKindExit, operationName, peer, carrier, opts)
+}
+
+// ActiveSpan returns the active span of the goroutine, return the noop span if not exists
+func (t *Tracer) ActiveSpan() *Span {
+	switch active := ActiveSpan().(type) {
+	case *tracingSpan:
+		return &Span{span: active}
+	case *SpanContext:
+		return &Span{unsampled: active}
+	}
+	return &Span{}
+}
+
+func (t *Tracer) createSpan(kind int32, operationName, peer string, carrier Carrier, opts []SpanOption) *Span {
+	active := ActiveSpan()
+	if unsampled, ok := active.(*SpanContext); ok {
+		// the trace is unsampled, only propagate the context
+		result := &Span{unsampled: unsampled}
+		if kind == SpanKindExit && carrier != nil {
+			result.Inject(carrier)
+		}
+		return result
+	}
+	parent, _ := active.(*tracingSpan)
+	var upstream *SpanContext
+	if parent == nil {
+		if kind == SpanKindEntry && carrier != nil {
+			upstream = GetPropagator().Extract(carrier)
+		}
+		if !GetSampler().Sample(operationName, carrier, upstream) {
+			return unsampledSpan(active, upstream)
+		}
+	}
+	span := &tracingSpan{
+		Data: &SpanData{
+			ParentSpanID:  -1,
+			OperationName: operationName,
+			Peer:          peer,
+			Kind:          kind,
+			StartTime:     time.Now(),
+		},
+		Previous: active,
+	}
+	if parent != nil && joinSegment(parent.Segment, span) {
+		span.Data.ParentSpanID = parent.Data.SpanID
+	} else {
+		segment := newSegment(parent)
+		continueTrace(segment, upstream)
+		joinSegment(segment, span)
+	}
+	for _, opt := range opts {
+		opt(span.Data)
+	}
+	SetActiveSpan(span)
+	result := &Span{span: span}
+	if kind == SpanKindExit && carrier != nil {
+		result.Inject(carrier)
+	}
+	return result
+}
+
+// unsampledSpan starts the unsampled trace, no span is created, the context of upstream is propagated to the downstream
+// with the unsampled flag. The context becomes active until the returned span ends
+func unsampledSpan(active interface{}, upstream *SpanContext) *Span {
+	ctx := &SpanContext{}
+	if upstream != nil {
+		*ctx = *upstream
+	}
+	if ctx.TraceID == "" {
+		ctx.TraceID = generateID()
+		ctx.ParentSegmentID = ctx.TraceID
+	}
+	ctx.Sampled = false
+	SetActiveSpan(ctx)
+	return &Span{unsampled: ctx, previous: active, activated: true}
+}
+
+// newSegment creates the segment, links to the parent span if the segment of parent is finished
+func newSegment(parent *tracingSpan) *tracingSegment {
+	segment := &tracingSegment{Data: &SegmentData{SegmentID: generateID()}}
+	if parent == nil {
+		segment.Data.TraceID = generateID()
+		return segment
+	}
+	parent.Segment.Lock.Lock()
+	segment.TraceState = parent.Segment.TraceState
+	segment.Baggage = append(segment.Baggage, parent.Segment.Baggage...)
+	parent.Segment.Lock.Unlock()
+	segment.Data.TraceID = parent.Segment.Data.TraceID
+	segment.Data.Refs = append(segment.Data.Refs, SegmentRef{
+		RefType:         SegmentRefCrossThread,
+		TraceID:         parent.Segment.Data.TraceID,
+		ParentSegmentID: parent.Segment.Data.SegmentID,
+		ParentSpanID:    parent.Data.SpanID,
+	})
+	return segment
+}
+
+// continueTrace makes the segment continue the trace of the upstream process
+func continueTrace(segment *tracingSegment, ctx *SpanContext) {
+	if ctx == nil {
+		return
+	}
+	segment.TraceState = ctx.TraceState
+	segment.Baggage = append(segment.Baggage, ctx.Baggage...)
+	if ctx.TraceID == "" {
+		return
+	}
+	segment.Data.TraceID = ctx.TraceID
+	segment.Data.Refs = append(segment.Data.Refs, SegmentRef{
+		RefType:               SegmentRefCrossProcess,
+		TraceID:               ctx.TraceID,
+		ParentSegmentID:       ctx.ParentSegmentID,
+		ParentSpanID:          ctx.ParentSpanID,
+		ParentService:         ctx.ParentService,
+		ParentServiceInstance: ctx.ParentServiceInstance,
+		ParentEndpoint:        ctx.ParentEndpoint,
+		NetworkAddress:        ctx.NetworkAddress,
+	})
+}
+
+// spanContext builds the context propagated to the downstream, the span is the parent of the downstream
+func spanContext(span *tracingSpan) *SpanContext {
+	segment := span.Segment
+	service, _ := globalValue(serviceGlobal).(serviceInstance)
+	ctx := &SpanContext{
+		Sampled:               true,
+		TraceID:               segment.Data.TraceID,
+		ParentSegmentID:       segment.Data.SegmentID,
+		ParentSpanID:          span.Data.SpanID,
+		ParentService:         service.Service,
+		ParentServiceInstance: service.Instance,
+		NetworkAddress:        span.Data.Peer,
+	}
+	segment.Lock.Lock()
+	defer segment.Lock.Unlock()
+	if segment.First != nil {
+		ctx.ParentEndpoint = segment.First.OperationName
+	}
+	ctx.TraceState = segment.TraceState
+	ctx.Baggage = append(ctx.Baggage, segment.Baggage...)
+	return ctx
+}
+
+// joinSegment adds the span into the segment, return false if the segment is finished
+func joinSegment(segment *tracingSegment, span *tracingSpan) bool {
+	segment.Lock.Lock()
+	defer segment.Lock.Unlock()
+	if segment.Finished {
+		return false
+	}
+	span.Segment = segment
+	if segment.First == nil {
+		segment.First = span.Data
+	}
+	span.Data.SpanID = segment.NextSpanID
+	segment.NextSpanID++
+	segment.Opening++
+	return true
+}
+
+func reportSegment(segment *SegmentData) {
+	if reporter, ok := globalValue(segmentReporterGlobal).(func(segment *SegmentData)); ok && reporter != nil {
+		reporter(segment)
+	}
+}
+
+func generateID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16) + "." + strconv.FormatInt(atomic.AddInt64(&idSequence, 1), 10)
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,231 @@
+package core
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// recordSegments collects the reported segments until the test finished
+func recordSegments(t *testing.T) func() []*SegmentData {
+	var lock sync.Mutex
+	segments := make([]*SegmentData, 0)
+	SetSegmentReporter(func(segment *SegmentData) {
+		lock.Lock()
+		defer lock.Unlock()
+		segments = append(segments, segment)
+	})
+	t.Cleanup(func() {
+		SetSegmentReporter(nil)
+	})
+	return func() []*SegmentData {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]*SegmentData(nil), segments...)
+	}
+}
+
+func spanNames(segment *SegmentData) []string {
+	names := make([]string, 0, len(segment.Spans))
+	for _, span := range segment.Spans {
+		names = append(names, span.OperationName)
+	}
+	return names
+}
+
+func TestTracerParentChild(t *testing.T) {
+	skipNotInstrumented(t)
+	defer RestoreGLS(nil)
+	reported := recordSegments(t)
+	tracer := GetTracer()
+
+	entry := tracer.CreateEntrySpan("GET:/users", nil, WithComponent(ComponentIDGin), WithTag("http.method", "GET"))
+	local := tracer.CreateLocalSpan("query")
+	exit := tracer.CreateExitSpan("GET:/orders", "orders:8080", nil)
+	if local.TraceID() != entry.TraceID() || exit.SegmentID() != entry.SegmentID() {
+		t.Fatalf("the spans are not in the same segment: %s/%s, %s/%s", entry.TraceID(), entry.SegmentID(), local.TraceID(), local.SegmentID())
+	}
+	if entry.SpanID() != 0 || local.SpanID() != 1 || exit.SpanID() != 2 {
+		t.Errorf("the span ids: %d, %d, %d", entry.SpanID(), local.SpanID(), exit.SpanID())
+	}
+	if active := tracer.ActiveSpan(); active.SpanID() != exit.SpanID() {
+		t.Errorf("the active span: %d", active.SpanID())
+	}
+
+	// the segment is reported once all of its spans are finished
+	exit.End()
+	local.End()
+	if segments := reported(); len(segments) != 0 {
+		t.Fatalf("the segment is reported before all spans finished: %v", spanNames(segments[0]))
+	}
+	entry.End()
+	segments := reported()
+	if len(segments) != 1 {
+		t.Fatalf("the reported segments: %d", len(segments))
+	}
+	segment := segments[0]
+	if segment.TraceID != entry.TraceID() || segment.SegmentID != entry.SegmentID() || len(segment.Refs) != 0 {
+		t.Errorf("the segment: %s/%s, refs: %v", segment.TraceID, segment.SegmentID, segment.Refs)
+	}
+	if names := spanNames(segment); !reflect.DeepEqual(names, []string{"GET:/orders", "query", "GET:/users"}) {
+		t.Errorf("the spans in the finish order: %v", names)
+	}
+	parents := []int32{segment.Spans[0].ParentSpanID, segment.Spans[1].ParentSpanID, segment.Spans[2].ParentSpanID}
+	if !reflect.DeepEqual(parents, []int32{1, 0, -1}) {
+		t.Errorf("the parent span ids: %v", parents)
+	}
+	first := segment.Spans[2]
+	if first.Kind != SpanKindEntry || first.ComponentID != ComponentIDGin || first.EndTime.Before(first.StartTime) ||
+		!reflect.DeepEqual(first.Tags, []Tag{{Key: "http.method", Value: "GET"}}) {
+		t.Errorf("the entry span: %+v", first)
+	}
+	if segment.Spans[0].Kind != SpanKindExit || segment.Spans[0].Peer != "orders:8080" {
+		t.Errorf("the exit span: %+v", segment.Spans[0])
+	}
+	if ActiveSpan() != nil {
+		t.Errorf("the active span after all spans finished: %v", ActiveSpan())
+	}
+}
+
+func TestSpanEndRestoresPrevious(t *testing.T) {
+	skipNotInstrumented(t)
+	defer RestoreGLS(nil)
+	recordSegments(t)
+	tracer := GetTracer()
+
+	entry := tracer.CreateEntrySpan("GET:/users", nil)
+	local := tracer.CreateLocalSpan("query")
+	local.End()
+	if tracer.ActiveSpan().SpanID() != entry.SpanID() {
+		t.Fatalf("the active span after the child ended: %d", tracer.ActiveSpan().SpanID())
+	}
+
+	// the span ended out of order never changes the active span which is not itself
+	local = tracer.CreateLocalSpan("query")
+	entry.End()
+	if tracer.ActiveSpan().SpanID() != local.SpanID() {
+		t.Errorf("the active span after the parent ended: %d", tracer.ActiveSpan().SpanID())
+	}
+	local.End()
+	if ActiveSpan() != entry.span {
+		t.Errorf("the active span after the child ended: %v", ActiveSpan())
+	}
+	// the span created later starts a new segment, the finished segment couldn't be joined
+	next := tracer.CreateLocalSpan("next")
+	defer next.End()
+	if next.SegmentID() == entry.SegmentID() || next.TraceID() != entry.TraceID() || next.span.Data.ParentSpanID != -1 {
+		t.Errorf("the span after the segment finished: %s/%s", next.TraceID(), next.SegmentID())
+	}
+}
+
+func TestSpanEndTwice(t *testing.T) {
+	skipNotInstrumented(t)
+	defer RestoreGLS(nil)
+	reported := recordSegments(t)
+	tracer := GetTracer()
+
+	entry := tracer.CreateEntrySpan("GET:/users", nil)
+	local := tracer.CreateLocalSpan("query")
+	local.End()
+	local.End()
+	// ending twice never finishes the segment which still has the opening span
+	if segments := reported(); len(segments) != 0 {
+		t.Fatalf("the segment is reported after ending the child twice: %v", spanNames(segments[0]))
+	}
+	local.Tag("after", "end")
+	local.Error(errors.New("after end"))
+	entry.End()
+	entry.End()
+	segments := reported()
+	if len(segments) != 1 || !reflect.DeepEqual(spanNames(segments[0]), []string{"query", "GET:/users"}) {
+		t.Fatalf("the reported segments: %d", len(segments))
+	}
+	if ActiveSpan() != nil {
+		t.Errorf("the active span: %v", ActiveSpan())
+	}
+}
+
+func TestTracerAsyncSpans(t *testing.T) {
+	skipNotInstrumented(t)
+	defer RestoreGLS(nil)
+	reported := recordSegments(t)
+	tracer := GetTracer()
+
+	entry := tracer.CreateEntrySpan("GET:/users", nil)
+	// the goroutine spawned in the span joins the segment until it's finished
+	var joined, async *Span
+	spawn(func() {
+		joined = tracer.CreateLocalSpan("joined")
+		joined.End()
+	})()
+	release := spawn(func() {
+		async = tracer.CreateLocalSpan("async")
+		async.End()
+	})
+	entry.End()
+	// the segment is finished, the goroutine creates the span in a new segment which refers to the parent span
+	release()
+
+	if joined.SegmentID() != entry.SegmentID() || joined.span.Data.ParentSpanID != entry.SpanID() {
+		t.Errorf("the joined span: %s, parent: %d", joined.SegmentID(), joined.span.Data.ParentSpanID)
+	}
+	segments := reported()
+	if len(segments) != 2 {
+		t.Fatalf("the reported segments: %d", len(segments))
+	}
+	if names := spanNames(segments[0]); !reflect.DeepEqual(names, []string{"joined", "GET:/users"}) {
+		t.Errorf("the spans of the entry segment: %v", names)
+	}
+	segment := segments[1]
+	expected := []SegmentRef{{
+		RefType:         SegmentRefCrossThread,
+		TraceID:         entry.TraceID(),
+		ParentSegmentID: entry.SegmentID(),
+		ParentSpanID:    entry.SpanID(),
+	}}
+	if segment.SegmentID != async.SegmentID() || segment.SegmentID == entry.SegmentID() || segment.TraceID != entry.TraceID() ||
+		!reflect.DeepEqual(segment.Refs, expected) {
+		t.Errorf("the async segment: %s/%s, refs: %+v", segment.TraceID, segment.SegmentID, segment.Refs)
+	}
+	if names := spanNames(segment); !reflect.DeepEqual(names, []string{"async"}) || segment.Spans[0].ParentSpanID != -1 {
+		t.Errorf("the spans of the async segment: %v", names)
+	}
+}
+
+func TestTracerContinueTrace(t *testing.T) {
+	defer RestoreGLS(nil)
+	reported := recordSegments(t)
+	carrier := MapCarrier{}
+	GetPropagator().Inject(&SpanContext{
+		Sampled:               true,
+		TraceID:               "trace-1",
+		ParentSegmentID:       "segment-1",
+		ParentSpanID:          3,
+		ParentService:         "upstream",
+		ParentServiceInstance: "upstream-1",
+		ParentEndpoint:        "GET:/",
+		NetworkAddress:        "users:8080",
+	}, carrier)
+
+	// the span without the instrumented runtime is the first span of a new segment
+	span := GetTracer().CreateEntrySpan("GET:/users", carrier)
+	span.End()
+	segments := reported()
+	if len(segments) != 1 || segments[0].TraceID != "trace-1" || span.TraceID() != "trace-1" {
+		t.Fatalf("the reported segments: %d, trace id: %s", len(segments), span.TraceID())
+	}
+	expected := []SegmentRef{{
+		RefType:               SegmentRefCrossProcess,
+		TraceID:               "trace-1",
+		ParentSegmentID:       "segment-1",
+		ParentSpanID:          3,
+		ParentService:         "upstream",
+		ParentServiceInstance: "upstream-1",
+		ParentEndpoint:        "GET:/",
+		NetworkAddress:        "users:8080",
+	}}
+	if !reflect.DeepEqual(segments[0].Refs, expected) {
+		t.Errorf("the refs: %+v", segments[0].Refs)
+	}
+}
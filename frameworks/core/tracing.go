@@ -0,0 +1,122 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// The tracing data are the aliases of the unnamed types, so the spans created by the copies of the core
+// in different enhanced packages could link each other, and the reporters could read them
+
+// SpanKind
+const (
+	SpanKindEntry int32 = 0
+	SpanKindExit  int32 = 1
+	SpanKindLocal int32 = 2
+)
+
+// SpanLayer
+const (
+	SpanLayerUnknown      int32 = 0
+	SpanLayerDatabase     int32 = 1
+	SpanLayerRPCFramework int32 = 2
+	SpanLayerHttp         int32 = 3
+	SpanLayerMQ           int32 = 4
+	SpanLayerCache        int32 = 5
+)
+
+// the component IDs defined in the component-libraries.yml of the SkyWalking OAP
+const (
+	ComponentIDUnknown      int32 = 0
+	ComponentIDGoHttpServer int32 = 5004
+	ComponentIDGoHttpClient int32 = 5005
+	ComponentIDGin          int32 = 5006
+)
+
+// SegmentRefType
+const (
+	SegmentRefCrossProcess int32 = 0
+	SegmentRefCrossThread  int32 = 1
+)
+
+// Tag is the key value pair of the span tags and log fields
+type Tag = struct {
+	Key   string
+	Value string
+}
+
+// LogData is the log of the span
+type LogData = struct {
+	Time   time.Time
+	Fields []Tag
+}
+
+// SpanData is the data of the span
+type SpanData = struct {
+	SpanID        int32
+	ParentSpanID  int32 // -1 for the first span of the segment
+	OperationName string
+	Peer          string
+	Kind          int32
+	Layer         int32
+	ComponentID   int32
+	StartTime     time.Time
+	EndTime       time.Time
+	IsError       bool
+	Tags          []Tag
+	Logs          []LogData
+}
+
+// SegmentRef links the segment to the parent segment in another goroutine or process
+type SegmentRef = struct {
+	RefType               int32
+	TraceID               string
+	ParentSegmentID       string
+	ParentSpanID          int32
+	ParentService         string
+	ParentServiceInstance string
+	ParentEndpoint        string
+	NetworkAddress        string
+}
+
+// SpanContext is the trace context propagated across the processes by the propagators
+type SpanContext = struct {
+	Sampled               bool
+	TraceID               string
+	ParentSegmentID       string // the span id of the parent for the W3C and B3 formats
+	ParentSpanID          int32
+	ParentService         string
+	ParentServiceInstance string
+	ParentEndpoint        string
+	NetworkAddress        string // the address used by the parent to access the current service
+	TraceState            string // the W3C tracestate, passed through
+	Baggage               []Tag  // the sw8-correlation or W3C baggage
+}
+
+// SegmentData is the spans of a trace in the current process, reported when all spans are finished
+type SegmentData = struct {
+	TraceID   string
+	SegmentID string
+	Refs      []SegmentRef
+	Spans     []*SpanData // the finished spans, in the finish order
+}
+
+// the segment which is creating spans
+type tracingSegment = struct {
+	Data       *SegmentData
+	Lock       sync.Mutex // guards the fields below and the spans of data
+	NextSpanID int32
+	Opening    int32
+	Finished   bool
+	First      *SpanData // the first span, its operation name is the parent endpoint of the downstream
+	TraceState string
+	Baggage    []Tag
+}
+
+// the span which is running, it's the value of the active span in the GLS and context
+type tracingSpan = struct {
+	Segment  *tracingSegment
+	Data     *SpanData
+	Previous interface{} // the active span when creating, restored when the span ends
+	Ended    bool        // guarded by the lock of segment
+}
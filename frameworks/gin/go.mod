@@ -3,6 +3,7 @@ module github.com/mrproliu/go-agent-instrumentation/frameworks/gin
 go 1.19
 
 require (
+	github.com/dave/dst v0.27.2
 	github.com/gin-gonic/gin v1.9.0
 	github.com/mrproliu/go-agent-instrumentation/framework/core v0.0.0-00010101000000-000000000000
 )
@@ -12,7 +13,6 @@ replace github.com/mrproliu/go-agent-instrumentation/framework/core => ../core
 require (
 	github.com/bytedance/sonic v1.8.0 // indirect
 	github.com/chenzhuoyu/base64x v0.0.0-20221115062448-fe3a3abad311 // indirect
-	github.com/dave/dst v0.27.2 // indirect
 	github.com/gin-contrib/sse v0.1.0 // indirect
 	github.com/go-playground/locales v0.14.1 // indirect
 	github.com/go-playground/universal-translator v0.18.1 // indirect
@@ -11,9 +11,17 @@ import (
 //go:embed *
 var assets embed.FS
 
+func init() {
+	core.RegisterInstrument(&Instrument{})
+}
+
 type Instrument struct {
 }
 
+func (i *Instrument) Name() string {
+	return "gin"
+}
+
 func (i *Instrument) BasePackage() string {
 	return "github.com/gin-gonic/gin"
 }
@@ -4,26 +4,52 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/mrproliu/go-agent-instrumentation/framework/core"
-	"time"
+	"net/http"
+	"strconv"
+	"strings"
 )
 
+// the config of the plugin, it's the "plugins.gin" section of the agent config
+type ginPluginConfig struct {
+	CollectRequestHeaders []string // the request headers recorded as the "http.headers.<name>" tags
+}
+
+var ginConfig = core.DeclarePluginConfig("gin", &ginPluginConfig{})
+
 type ServerHTTPInterceptor struct {
 }
 
 func (s *ServerHTTPInterceptor) BeforeInvoke(invocation *core.Invocation) error {
-	instance := invocation.CallerInstance.(core.EnhancedInstance)
-	instance.SetSkyWalkingDynamicField("test")
 	context := invocation.Args[0].(*gin.Context)
-	fmt.Printf("request URI: %s: %v\n", context.Request.RequestURI, instance.GetSkyWalkingDynamicField())
-	core.SetGLS("test")
-	go func() {
-		time.Sleep(time.Second)
-		fmt.Printf("go routine TLS: %v\n", core.GetGLS())
-	}()
+	span := core.GetTracer().CreateEntrySpan(fmt.Sprintf("%s:%s", context.Request.Method, context.Request.URL.Path),
+		core.HeaderCarrier(context.Request.Header),
+		core.WithComponent(core.ComponentIDGin),
+		core.WithLayer(core.SpanLayerHttp),
+		core.WithTag("http.method", context.Request.Method),
+		core.WithTag("url", context.Request.Host+context.Request.RequestURI))
+	for _, header := range ginConfig.Get().(*ginPluginConfig).CollectRequestHeaders {
+		if value := context.Request.Header.Get(header); value != "" {
+			span.Tag("http.headers."+strings.ToLower(header), value)
+		}
+	}
+	invocation.Attachment = span
 	return nil
 }
 
 func (s *ServerHTTPInterceptor) AfterInvoke(invocation *core.Invocation, result ...interface{}) error {
-	fmt.Print("after\n")
+	span, ok := invocation.Attachment.(*core.Span)
+	if !ok {
+		return nil
+	}
+	context := invocation.Args[0].(*gin.Context)
+	status := context.Writer.Status()
+	span.Tag("status_code", strconv.Itoa(status))
+	if status >= http.StatusInternalServerError {
+		span.Error(nil)
+	}
+	if len(context.Errors) > 0 {
+		span.Error(context.Errors.Last())
+	}
+	span.End()
 	return nil
 }
@@ -5,6 +5,8 @@ go 1.19
 require (
 	github.com/dave/dst v0.27.2
 	github.com/mrproliu/go-agent-instrumentation/framework/core v0.0.0-00010101000000-000000000000
+	github.com/mrproliu/go-agent-instrumentation/frameworks/gin v0.0.0-00010101000000-000000000000
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 replace github.com/mrproliu/go-agent-instrumentation/framework/core => ./frameworks/core
@@ -26,7 +28,6 @@ require (
 	github.com/mattn/go-isatty v0.0.17 // indirect
 	github.com/modern-go/concurrent v0.0.0-20180228061459-e0a39a4cb421 // indirect
 	github.com/modern-go/reflect2 v1.0.2 // indirect
-	github.com/mrproliu/go-agent-instrumentation/frameworks/gin v0.0.0-00010101000000-000000000000 // indirect
 	github.com/pelletier/go-toml/v2 v2.0.6 // indirect
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
 	github.com/ugorji/go/codec v1.2.9 // indirect
@@ -38,5 +39,4 @@ require (
 	golang.org/x/text v0.7.0 // indirect
 	golang.org/x/tools v0.1.12 // indirect
 	google.golang.org/protobuf v1.28.1 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
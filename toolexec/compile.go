@@ -0,0 +1,228 @@
+package toolexec
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+type compileOptions struct {
+	Package   string
+	Output    string
+	ImportCfg string
+	EmbedCfg  string
+	Lang      string
+	GoVersion string
+	BuildID   string
+	TrimPath  string
+	Complete  bool
+	Pack      bool
+	Std       bool
+	Race      bool
+	Msan      bool
+	Asan      bool
+
+	GoFiles      []string
+	goFilesIndex int // the index of the first go file in the compile args
+}
+
+func (c *compileOptions) String() string {
+	return fmt.Sprintf("-p: %s, -o: %s", c.Package, c.Output)
+}
+
+// GoFileArgIndex returns the index of the go file in the compile args
+func (c *compileOptions) GoFileArgIndex(i int) int {
+	return c.goFilesIndex + i
+}
+
+type compileFlag struct {
+	// the flag needs a value, otherwise it is a boolean or counter flag which never consumes the next argument
+	withValue bool
+	// setter for the flags which needs to be exposed
+	set func(opt *compileOptions, value string)
+}
+
+// compileFlags is the flag set of cmd/compile in the supported go versions,
+// from cmd/compile/internal/base/flag.go and the objabi flags registered by it.
+// The unknown flag fails the parsing, it may need a value, treating it as a boolean would consume the source files
+var compileFlags = map[string]*compileFlag{
+	"%":                  {},
+	"+":                  {},
+	"B":                  {},
+	"C":                  {},
+	"D":                  {withValue: true},
+	"E":                  {},
+	"I":                  {withValue: true},
+	"K":                  {},
+	"L":                  {},
+	"N":                  {},
+	"S":                  {},
+	"V":                  {}, // also accepts -V=full
+	"W":                  {},
+	"asan":               {set: func(opt *compileOptions, v string) { opt.Asan = v != "false" }}, // since go1.18
+	"asmhdr":             {withValue: true},
+	"bench":              {withValue: true},
+	"blockprofile":       {withValue: true},
+	"buildid":            {withValue: true, set: func(opt *compileOptions, v string) { opt.BuildID = v }},
+	"c":                  {withValue: true},
+	"clobberdead":        {},
+	"clobberdeadreg":     {},
+	"complete":           {set: func(opt *compileOptions, v string) { opt.Complete = v != "false" }},
+	"coveragecfg":        {withValue: true}, // since go1.20
+	"cpuprofile":         {withValue: true},
+	"d":                  {withValue: true},
+	"dwarf":              {},
+	"dwarfbasentries":    {},
+	"dwarflocationlists": {},
+	"dynlink":            {},
+	"e":                  {},
+	"embedcfg":           {withValue: true, set: func(opt *compileOptions, v string) { opt.EmbedCfg = v }},
+	"env":                {withValue: true},
+	"errorurl":           {},
+	"gendwarfinl":        {withValue: true},
+	"goversion":          {withValue: true, set: func(opt *compileOptions, v string) { opt.GoVersion = v }},
+	"h":                  {},
+	"importcfg":          {withValue: true, set: func(opt *compileOptions, v string) { opt.ImportCfg = v }},
+	"importmap":          {withValue: true}, // removed in newer versions
+	"installsuffix":      {withValue: true},
+	"j":                  {},
+	"json":               {withValue: true},
+	"l":                  {},
+	"lang":               {withValue: true, set: func(opt *compileOptions, v string) { opt.Lang = v }},
+	"linkobj":            {withValue: true},
+	"linkshared":         {},
+	"live":               {},
+	"m":                  {},
+	"memprofile":         {withValue: true},
+	"memprofilerate":     {withValue: true},
+	"msan":               {set: func(opt *compileOptions, v string) { opt.Msan = v != "false" }},
+	"mutexprofile":       {withValue: true},
+	"nolocalimports":     {},
+	"o":                  {withValue: true, set: func(opt *compileOptions, v string) { opt.Output = v }},
+	"p":                  {withValue: true, set: func(opt *compileOptions, v string) { opt.Package = v }},
+	"pack":               {set: func(opt *compileOptions, v string) { opt.Pack = v != "false" }},
+	"pgoprofile":         {withValue: true}, // since go1.20
+	"r":                  {},
+	"race":               {set: func(opt *compileOptions, v string) { opt.Race = v != "false" }},
+	"shared":             {},
+	"smallframes":        {},
+	"spectre":            {withValue: true},
+	"std":                {set: func(opt *compileOptions, v string) { opt.Std = v != "false" }},
+	"symabis":            {withValue: true},
+	"t":                  {},
+	"traceprofile":       {withValue: true},
+	"trimpath":           {withValue: true, set: func(opt *compileOptions, v string) { opt.TrimPath = v }},
+	"u":                  {},
+	"v":                  {},
+	"w":                  {},
+	"wb":                 {},
+}
+
+func parseCompileOption(args []string) (*compileOptions, error) {
+	if toolName(args) != "compile" {
+		return nil, nil
+	}
+
+	opt := &compileOptions{}
+	i, err := parseToolFlags(args, func(name string) (bool, bool) {
+		f := compileFlags[name]
+		return f != nil && f.withValue, f != nil
+	}, func(name, value string) {
+		if f := compileFlags[name]; f != nil && f.set != nil {
+			f.set(opt, value)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("parse the compile arguments failure: %v", err)
+	}
+
+	opt.goFilesIndex = i
+	for ; i < len(args); i++ {
+		if strings.HasSuffix(args[i], ".go") {
+			opt.GoFiles = append(opt.GoFiles, args[i])
+		}
+	}
+	return opt, nil
+}
+
+// BuildFlags returns the go build flags which changes the compiled packages, the injected packages should be built with them
+func (c *compileOptions) BuildFlags() []string {
+	return sanitizerBuildFlags(c.Race, c.Msan, c.Asan)
+}
+
+func sanitizerBuildFlags(race, msan, asan bool) []string {
+	flags := make([]string, 0)
+	if race {
+		flags = append(flags, "-race")
+	}
+	if msan {
+		flags = append(flags, "-msan")
+	}
+	if asan {
+		flags = append(flags, "-asan")
+	}
+	return flags
+}
+
+// toolName returns the go tool name of the command, such as compile, link or asm
+func toolName(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	cmd := filepath.Base(args[0])
+	if ext := filepath.Ext(cmd); ext != "" {
+		cmd = strings.TrimSuffix(cmd, ext)
+	}
+	return cmd
+}
+
+// parseToolFlags parses the flags of go tool command, returns the index of the first positional argument.
+// The lookup returns whether the flag needs a value, and whether the flag is known, the unknown flag fails the parsing
+func parseToolFlags(args []string, lookup func(name string) (withValue, known bool), handle func(name, value string)) (int, error) {
+	i := 1
+	for i < len(args) {
+		arg := args[i]
+		if arg == "--" {
+			return i + 1, nil
+		}
+		// same as the flag package, the first non-flag argument terminates the flags
+		if len(arg) < 2 || arg[0] != '-' {
+			return i, nil
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		value, hasValue := "", false
+		if inx := strings.Index(name, "="); inx >= 0 {
+			name, value, hasValue = name[:inx], name[inx+1:], true
+		}
+		i++
+
+		withValue, known := lookup(name)
+		if !known {
+			return 0, fmt.Errorf("unknown flag -%s of the %s, it may be added by the new go version", name, toolName(args))
+		}
+		if withValue && !hasValue {
+			if i >= len(args) {
+				return 0, fmt.Errorf("the flag -%s needs a value", name)
+			}
+			value = args[i]
+			i++
+		}
+		handle(name, value)
+	}
+	return i, nil
+}
+
+// replaceFlagValue changes the value of the flag in the tool arguments
+func replaceFlagValue(args []string, name, value string) []string {
+	for i := 1; i < len(args); i++ {
+		if args[i] == "-"+name && i+1 < len(args) {
+			args[i+1] = value
+			return args
+		}
+		if strings.HasPrefix(args[i], "-"+name+"=") {
+			args[i] = "-" + name + "=" + value
+			return args
+		}
+	}
+	return args
+}
@@ -0,0 +1,89 @@
+package toolexec
+
+import (
+	"go/build"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// splitCommandLine splits the command printed by "go build -n", the arguments with special characters are quoted by strconv.Quote
+func splitCommandLine(t *testing.T, line string) []string {
+	result := make([]string, 0)
+	for line = strings.TrimSpace(line); line != ""; line = strings.TrimLeft(line, " ") {
+		if line[0] == '"' {
+			quoted, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				t.Fatalf("unquote %s failure: %v", line, err)
+			}
+			arg, _ := strconv.Unquote(quoted)
+			result = append(result, arg)
+			line = line[len(quoted):]
+			continue
+		}
+		end := strings.IndexByte(line, ' ')
+		if end < 0 {
+			end = len(line)
+		}
+		result = append(result, line[:end])
+		line = line[end:]
+	}
+	return result
+}
+
+func TestParseCompileOptionFromGoBuild(t *testing.T) {
+	// the compile commands of the package and all its dependencies(including the runtime) by the current toolchain
+	cmd := exec.Command(goCommand(filepath.Join(build.ToolDir, "compile")), "build", "-n", "-a", "./internal/testtarget")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("go build -n failure: %v\n%s", err, output)
+	}
+	packages := make(map[string]*compileOptions)
+	for _, line := range strings.Split(string(output), "\n") {
+		args := splitCommandLine(t, line)
+		if toolName(args) != "compile" || isVersionQuery(args) {
+			continue
+		}
+		opt, err := parseCompileOption(args)
+		if err != nil {
+			t.Fatalf("%v\n%s", err, line)
+		}
+		if opt.Package == "" || !strings.HasSuffix(opt.Output, "_pkg_.a") || opt.ImportCfg == "" {
+			t.Errorf("the options of %s: %s, importcfg: %s\n%s", opt.Package, opt, opt.ImportCfg, line)
+		}
+		// the go files are always at the end of the arguments
+		if len(opt.GoFiles) == 0 || opt.GoFileArgIndex(len(opt.GoFiles)) != len(args) {
+			t.Errorf("the go files of %s: %v\n%s", opt.Package, opt.GoFiles, line)
+		}
+		packages[opt.Package] = opt
+	}
+	runtimeOpt := packages["runtime"]
+	if runtimeOpt == nil || !runtimeOpt.Std || !strings.HasPrefix(runtimeOpt.GoVersion, "go1.") {
+		t.Errorf("the options of the runtime: %+v", runtimeOpt)
+	}
+	if packages[testTargetPackage] == nil {
+		t.Errorf("the compile of %s is not found in:\n%s", testTargetPackage, output)
+	}
+}
+
+func TestParseCompileOptionUnknownFlag(t *testing.T) {
+	compile := filepath.Join(build.ToolDir, "compile")
+	tests := map[string][]string{
+		"unknown flag -newflag":     {compile, "-o", "_pkg_.a", "-p", "main", "-newflag", "server.go"},
+		"the flag -p needs a value": {compile, "-o", "_pkg_.a", "-p"},
+	}
+	for expected, args := range tests {
+		if _, err := parseCompileOption(args); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%v: expected error %q, actual: %v", args, expected, err)
+		}
+	}
+	opt, err := parseCompileOption([]string{compile, "-o=_pkg_.a", "-p", "main", "-complete", "-c=4", "--", "-server.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opt.Package != "main" || opt.Output != "_pkg_.a" || !opt.Complete || len(opt.GoFiles) != 1 || opt.GoFiles[0] != "-server.go" {
+		t.Errorf("the parsed options: %+v", opt)
+	}
+}
@@ -0,0 +1,134 @@
+package toolexec
+
+import (
+	"fmt"
+	"github.com/mrproliu/go-agent-instrumentation/framework/core"
+	"gopkg.in/yaml.v3"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// configFileEnv is the environment variable of the agent config file, used when no -config flag
+const configFileEnv = "SW_AGENT_CONFIG"
+
+// buildConfig is the "build" section of the agent config, the environment variables are "SW_AGENT_BUILD_<KEY>".
+// The package patterns are same with the go command, "..." matches any string, such as "github.com/gin-gonic/...",
+// and "*" matches any string without "/"
+type buildConfig struct {
+	Plugins          []string // the allowlist of plugin names, all plugins are enabled if empty
+	DisabledPlugins  []string // the denylist of plugin names, takes precedence over the allowlist
+	IncludedPackages []string // the patterns of packages could be instrumented, all if empty, the runtime is always included
+	ExcludedPackages []string // the patterns of packages never instrumented
+	Strict           bool     // fails the build when instrument failure, otherwise compiles the package without instrument
+}
+
+// loadBuildConfig reads the config file and the environment variables, the runtime sections of the file are ignored.
+// The path should be absolute, the tools are executed in the directory of each package
+func loadBuildConfig(path string) (*buildConfig, error) {
+	if path == "" {
+		path = os.Getenv(configFileEnv)
+	}
+	values := make(map[string]interface{})
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(content, &values); err != nil {
+			return nil, fmt.Errorf("parse config file %s failure: %v", path, err)
+		}
+	}
+	// the runtime sections are decoded by the agent
+	cfg := &struct{ Build buildConfig }{Build: buildConfig{Strict: true}}
+	if err := core.DecodeConfig(map[string]interface{}{"build": values["build"]}, "SW_AGENT", cfg); err != nil {
+		return nil, err
+	}
+	for _, name := range append(append([]string{}, cfg.Build.Plugins...), cfg.Build.DisabledPlugins...) {
+		if findFrameworkInstrument(name) == nil {
+			return nil, fmt.Errorf("unknown plugin %q in the build config", name)
+		}
+	}
+	return &cfg.Build, nil
+}
+
+// PluginEnabled checks the plugin should be instrumented, the disabled plugins inject no code
+func (c *buildConfig) PluginEnabled(name string) bool {
+	if containsString(c.DisabledPlugins, name) {
+		return false
+	}
+	return len(c.Plugins) == 0 || containsString(c.Plugins, name)
+}
+
+// PackageInstrumented checks the package could be instrumented by the include and exclude patterns
+func (c *buildConfig) PackageInstrumented(pkg string) bool {
+	if matchPackagePatterns(c.ExcludedPackages, pkg) {
+		return false
+	}
+	return pkg == "runtime" || len(c.IncludedPackages) == 0 || matchPackagePatterns(c.IncludedPackages, pkg)
+}
+
+// ActivePlugins describes the enabled and disabled plugins, such as "gin", "none(disabled: gin)"
+func (c *buildConfig) ActivePlugins() string {
+	active, disabled := make([]string, 0), make([]string, 0)
+	for _, inst := range core.Instruments() {
+		if c.PluginEnabled(inst.Name()) {
+			active = append(active, inst.Name())
+		} else {
+			disabled = append(disabled, inst.Name())
+		}
+	}
+	result := strings.Join(active, ", ")
+	if len(active) == 0 {
+		result = "none"
+	}
+	if len(disabled) > 0 {
+		result += fmt.Sprintf("(disabled: %s)", strings.Join(disabled, ", "))
+	}
+	return result
+}
+
+// EnabledInstruments returns the instruments of the enabled plugins
+func (c *buildConfig) EnabledInstruments() []core.Instrument {
+	result := make([]core.Instrument, 0)
+	for _, inst := range core.Instruments() {
+		if c.PluginEnabled(inst.Name()) {
+			result = append(result, inst)
+		}
+	}
+	return result
+}
+
+// matchPackagePatterns checks the package matches any of the patterns, "x/..." also matches "x" as the go command
+func matchPackagePatterns(patterns []string, pkg string) bool {
+	for _, pattern := range patterns {
+		expr := regexp.QuoteMeta(pattern)
+		expr = strings.ReplaceAll(expr, `\.\.\.`, `.*`)
+		expr = strings.ReplaceAll(expr, `\*`, `[^/]*`)
+		if strings.HasSuffix(expr, `/.*`) {
+			expr = strings.TrimSuffix(expr, `/.*`) + `(/.*)?`
+		}
+		if regexp.MustCompile("^" + expr + "$").MatchString(pkg) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func findFrameworkInstrument(name string) core.Instrument {
+	for _, inst := range core.Instruments() {
+		if inst.Name() == name {
+			return inst
+		}
+	}
+	return nil
+}
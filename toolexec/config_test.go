@@ -0,0 +1,42 @@
+package toolexec
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadBuildConfig(t *testing.T) {
+	t.Setenv(configFileEnv, "")
+	t.Setenv("SW_AGENT_BUILD_STRICT", "")
+	t.Setenv("SW_AGENT_BUILD_EXCLUDED_PACKAGES", "example.com/legacy/...")
+	path := filepath.Join(t.TempDir(), "agent.yaml")
+	tests := map[string]string{
+		// the runtime sections are checked by the agent
+		"build: {plugins: [testplugin]}\nservice: users\nunknown_runtime_option: 1\n": "",
+		"service: users\n":                        "",
+		"build: {strict: false, unknown: true}\n": "unknown config build.unknown",
+		"build: {plugins: [unknown]}\n":           "unknown plugin \"unknown\" in the build config",
+		"build: [testplugin]\n":                   "config build should be a map",
+	}
+	for content, expected := range tests {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := loadBuildConfig(path)
+		if expected != "" {
+			if err == nil || err.Error() != expected {
+				t.Errorf("%q: the error %v, expected: %s", content, err, expected)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", content, err)
+			continue
+		}
+		// the strict is true by default, and the environment variables are applied
+		if !cfg.Strict || cfg.PackageInstrumented("example.com/legacy/api") || !cfg.PluginEnabled("testplugin") {
+			t.Errorf("%q: the build config %+v", content, cfg)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package toolexec
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// dumpInstrumentedFiles mirrors the rewritten and generated files into the debug directory, organized by import path
+func dumpInstrumentedFiles(opts *toolexecOptions, pkg string, rewritten map[string]string, generated []string) error {
+	if opts == nil || opts.DebugDir == "" || (len(rewritten) == 0 && len(generated) == 0) {
+		return nil
+	}
+	dir := filepath.Join(opts.DebugDir, filepath.FromSlash(pkg))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	for dest, original := range rewritten {
+		content, err := os.ReadFile(dest)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, filepath.Base(dest)), content, 0644); err != nil {
+			return err
+		}
+		if !opts.DebugDiff {
+			continue
+		}
+		originalContent, err := os.ReadFile(original)
+		if err != nil {
+			return err
+		}
+		diff := unifiedDiff(original, dest, string(originalContent), string(content))
+		if err := os.WriteFile(filepath.Join(dir, filepath.Base(dest)+".diff"), []byte(diff), 0644); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range generated {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, filepath.Base(file)), content, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package toolexec
+
+import (
+	"fmt"
+	"strings"
+)
+
+type diffOpKind int
+
+const (
+	diffEqual diffOpKind = iota
+	diffDelete
+	diffInsert
+)
+
+type diffOp struct {
+	kind diffOpKind
+	line string
+}
+
+const diffContextLines = 3
+
+// unifiedDiff returns the unified diff between two texts, return empty string when they are equal
+func unifiedDiff(oldName, newName, oldText, newText string) string {
+	ops := diffLines(splitLines(oldText), splitLines(newText))
+
+	var builder strings.Builder
+	oldLine, newLine := 1, 1
+	for i := 0; i < len(ops); {
+		if ops[i].kind == diffEqual {
+			oldLine++
+			newLine++
+			i++
+			continue
+		}
+
+		// find the whole hunk, changes closer than twice of context lines are merged into one hunk
+		start := i - diffContextLines
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != diffEqual {
+				end++
+				continue
+			}
+			equals := 0
+			for end+equals < len(ops) && ops[end+equals].kind == diffEqual {
+				equals++
+			}
+			if end+equals == len(ops) || equals > diffContextLines*2 {
+				end += minInt(equals, diffContextLines)
+				break
+			}
+			end += equals
+		}
+
+		hunkOldStart, hunkNewStart := oldLine-(i-start), newLine-(i-start)
+		oldCount, newCount := 0, 0
+		var body strings.Builder
+		for _, op := range ops[start:end] {
+			switch op.kind {
+			case diffEqual:
+				oldCount++
+				newCount++
+				body.WriteString(" " + op.line + "\n")
+			case diffDelete:
+				oldCount++
+				body.WriteString("-" + op.line + "\n")
+			case diffInsert:
+				newCount++
+				body.WriteString("+" + op.line + "\n")
+			}
+		}
+		if builder.Len() == 0 {
+			builder.WriteString(fmt.Sprintf("--- %s\n+++ %s\n", oldName, newName))
+		}
+		builder.WriteString(fmt.Sprintf("@@ -%d,%d +%d,%d @@\n", hunkOldStart, oldCount, hunkNewStart, newCount))
+		builder.WriteString(body.String())
+
+		for _, op := range ops[i:end] {
+			if op.kind != diffInsert {
+				oldLine++
+			}
+			if op.kind != diffDelete {
+				newLine++
+			}
+		}
+		i = end
+	}
+	return builder.String()
+}
+
+// diffLines finds the shortest edit script between two line lists by the Myers algorithm
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	trace := make([][]int, 0)
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrackDiff(a, b, trace)
+			}
+		}
+	}
+	return nil
+}
+
+func backtrackDiff(a, b []string, trace [][]int) []diffOp {
+	ops := make([]diffOp, 0)
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		// the trace of step d only keeps the diagonals from -d-1 to d+1
+		v := func(k int) int {
+			return trace[d][k+d+1]
+		}
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v(k-1) < v(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{kind: diffEqual, line: a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, diffOp{kind: diffInsert, line: b[y-1]})
+				y--
+			} else {
+				ops = append(ops, diffOp{kind: diffDelete, line: a[x-1]})
+				x--
+			}
+		}
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
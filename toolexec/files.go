@@ -0,0 +1,585 @@
+package toolexec
+
+import (
+	"fmt"
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/dave/dst/dstutil"
+	"github.com/mrproliu/go-agent-instrumentation/framework/core"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the import path of the core, the copied files import it but use the copy in the enhanced package
+var corePackagePath = reflect.TypeOf(core.InstrumentPoint{}).PkgPath()
+
+// the prefix of the identifiers injected into the enhanced package, the linkname variables already have it
+const injectedPrefix = "_skywalking_"
+
+// injectedFile is the go file copied into the enhanced package
+type injectedFile struct {
+	path string // the path in the FS, such as "interceptor.go" or "internal/headers/headers.go"
+	file *dst.File
+}
+
+// injectedPackage is the package merged into the enhanced package, the core, the root or a subpackage of the plugin.
+// Its files are type checked to resolve the identifiers, the top-level identifiers are prefixed by the scope of the package,
+// so they never conflict with the enhanced package and the other injected packages
+type injectedPackage struct {
+	path   string // the import path
+	prefix string // the prefix of the top-level identifiers
+	dec    *decorator.Decorator
+	files  []*injectedFile
+
+	types *types.Package
+	info  *types.Info
+}
+
+// corePrefix is the prefix of the identifiers in the copied core, such as "_skywalking_core_Invocation"
+var corePrefix = mangledPrefix("core")
+
+// coreName returns the name of the core identifier in the enhanced package, used by the generated adapter
+func coreName(name string) string {
+	return mangleName(corePrefix, name)
+}
+
+// pluginPrefix is the prefix of the identifiers in the plugin package, the dir is "." for the root package,
+// such as "_skywalking_plugin_gin_" and "_skywalking_plugin_gin_internal_headers_"
+func pluginPrefix(inst core.Instrument, dir string) string {
+	if dir == "." || dir == "" {
+		return mangledPrefix("plugin/" + inst.Name())
+	}
+	return mangledPrefix("plugin/" + inst.Name() + "/" + dir)
+}
+
+func mangledPrefix(scope string) string {
+	return injectedPrefix + regexp.MustCompile(`[^A-Za-z0-9_]`).ReplaceAllString(scope, "_") + "_"
+}
+
+// mangleName prefixes the top-level identifier, the init functions, the blank and the linkname variables are kept
+func mangleName(prefix, name string) string {
+	if name == "_" || name == "init" || strings.HasPrefix(name, injectedPrefix) {
+		return name
+	}
+	return prefix + name
+}
+
+// writeCoreFiles copies the source files of the core into the package, return the written files
+func writeCoreFiles(basePath, packageName string, imp *injectedImporter) ([]string, error) {
+	pkg := &injectedPackage{path: corePackagePath, prefix: corePrefix, dec: decorator.NewDecorator(token.NewFileSet())}
+	imp.packages[pkg.path] = pkg
+	names, err := coreFilePaths()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if err := pkg.parse(core.Sources(), name); err != nil {
+			return nil, err
+		}
+	}
+	merged := map[string]string{corePackagePath: corePrefix}
+	files := make([]string, 0, len(pkg.files))
+	for _, f := range pkg.files {
+		path := filepath.Join(basePath, fmt.Sprintf("sw_core_%s", f.path))
+		if err := pkg.write(f, merged, packageName, path, imp); err != nil {
+			return nil, fmt.Errorf("copy the core into package %s failure: %v", packageName, err)
+		}
+		files = append(files, path)
+	}
+	return files, nil
+}
+
+// coreFilePaths lists the go files of the core copied into the enhanced package, the instrument.go is only used by the toolexec,
+// and the tests are embedded with the sources but never copied
+func coreFilePaths() ([]string, error) {
+	dirEntries, err := fs.ReadDir(core.Sources(), ".")
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(dirEntries))
+	for _, entry := range dirEntries {
+		if entry.IsDir() || entry.Name() == "instrument.go" || !strings.HasSuffix(entry.Name(), ".go") || strings.HasSuffix(entry.Name(), "_test.go") {
+			continue
+		}
+		result = append(result, entry.Name())
+	}
+	return result, nil
+}
+
+// writeInstrumentFiles copies the files of the instrument into the enhanced package, the imports of the plugin packages,
+// the core and the enhanced package itself are removed, and the references to them are changed to the local names
+func writeInstrumentFiles(basePath, packageName string, inst core.Instrument, point *core.InstrumentPoint,
+	imp *injectedImporter) ([]string, error) {
+	paths, err := instrumentFilePaths(inst, point.PackagePath)
+	if err != nil {
+		return nil, err
+	}
+	pluginPath := instrumentPackagePath(inst)
+	packagePath := filepath.Join(inst.BasePackage(), point.PackagePath)
+	// the packages merged into the enhanced package, import path -> the prefix of identifiers
+	merged := map[string]string{corePackagePath: corePrefix, packagePath: ""}
+	fset := token.NewFileSet()
+	packages := make([]*injectedPackage, 0)
+	packageIndex := make(map[string]*injectedPackage)
+	for _, p := range paths {
+		pkgPath := pluginPath
+		if dir := path.Dir(p); dir != "." {
+			pkgPath = pluginPath + "/" + dir
+		}
+		pkg := packageIndex[pkgPath]
+		if pkg == nil {
+			pkg = &injectedPackage{path: pkgPath, prefix: pluginPrefix(inst, path.Dir(p)), dec: decorator.NewDecorator(fset)}
+			packages = append(packages, pkg)
+			packageIndex[pkgPath] = pkg
+			merged[pkgPath] = pkg.prefix
+			imp.packages[pkgPath] = pkg
+		}
+		if err := pkg.parse(inst.FS(), p); err != nil {
+			return nil, fmt.Errorf("parse the file %s of plugin %s failure: %v", p, inst.Name(), err)
+		}
+	}
+
+	result := make([]string, 0, len(paths))
+	for _, pkg := range packages {
+		for _, f := range pkg.files {
+			path := filepath.Join(basePath, fmt.Sprintf("sw_enhance_%s_%s", inst.Name(), strings.ReplaceAll(f.path, "/", "_")))
+			if err := pkg.write(f, merged, packageName, path, imp); err != nil {
+				return nil, fmt.Errorf("merge the plugin %s into package %s failure: %v", inst.Name(), packagePath, err)
+			}
+			result = append(result, path)
+		}
+	}
+	return result, nil
+}
+
+// instrumentFilePaths lists the go files copied into the package by the manifest of the instrument,
+// the files not matched the build constraints are skipped
+func instrumentFilePaths(inst core.Instrument, packagePath string) ([]string, error) {
+	insFS := inst.FS()
+	roots := []string{"."}
+	if manifest, ok := inst.(core.InstrumentFiles); ok {
+		roots = manifest.Files(packagePath)
+	}
+	ctxt := build.Default
+	ctxt.JoinPath = path.Join
+	ctxt.OpenFile = func(name string) (io.ReadCloser, error) {
+		return insFS.Open(name)
+	}
+
+	found := make(map[string]bool)
+	for _, root := range roots {
+		root = path.Clean(root)
+		err := fs.WalkDir(insFS, root, func(p string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				if p != root && (entry.Name() == "testdata" || strings.HasPrefix(entry.Name(), ".") || strings.HasPrefix(entry.Name(), "_")) {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			// the instrument.go is only used by the toolexec
+			if p == "instrument.go" || !strings.HasSuffix(p, ".go") || strings.HasSuffix(p, "_test.go") {
+				return nil
+			}
+			matched, err := ctxt.MatchFile(path.Dir(p), path.Base(p))
+			if err != nil {
+				return err
+			}
+			if matched {
+				found[p] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("read the files %q of plugin %s failure: %v", root, inst.Name(), err)
+		}
+	}
+	result := make([]string, 0, len(found))
+	for p := range found {
+		result = append(result, p)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// instrumentPackagePath returns the import path of the package which implements the instrument, it's the root of the FS
+func instrumentPackagePath(inst core.Instrument) string {
+	t := reflect.TypeOf(inst)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.PkgPath()
+}
+
+// parse reads the file from the FS into the package
+func (p *injectedPackage) parse(fsys fs.FS, name string) error {
+	content, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return err
+	}
+	file, err := p.dec.ParseFile(name, content, parser.ParseComments)
+	if err != nil {
+		return err
+	}
+	p.files = append(p.files, &injectedFile{path: name, file: file})
+	p.types = nil
+	return nil
+}
+
+// write renames the identifiers of the file for the enhanced package, and writes it to the path
+func (p *injectedPackage) write(f *injectedFile, merged map[string]string, packageName, path string, imp *injectedImporter) error {
+	if p.types == nil {
+		if err := p.check(imp); err != nil {
+			return err
+		}
+	}
+	if err := p.rename(f.file, merged); err != nil {
+		return err
+	}
+	f.file.Name = dst.NewIdent(packageName)
+	output, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+	return writeFile(f.file, output)
+}
+
+// check type checks the files, the imported packages are loaded by the importer. The enhanced package is compiling,
+// so its import fails, the errors caused by it are ignored, and any other error fails the check
+func (p *injectedPackage) check(imp *injectedImporter) error {
+	files := make([]*ast.File, 0, len(p.files))
+	enhancedImports := make(map[token.Pos]bool)
+	for _, f := range p.files {
+		file := p.dec.Ast.Nodes[f.file].(*ast.File)
+		files = append(files, file)
+		for _, spec := range file.Imports {
+			if path, err := strconv.Unquote(spec.Path.Value); err == nil && path == imp.enhanced {
+				enhancedImports[spec.Path.Pos()] = true
+			}
+		}
+	}
+	// loads the imported packages by once, rather than building the missing packages one by one
+	imports := make([]string, 0)
+	for _, file := range files {
+		for _, spec := range file.Imports {
+			path, err := strconv.Unquote(spec.Path.Value)
+			if err == nil && path != "unsafe" && path != "C" && path != imp.enhanced && imp.packages[path] == nil {
+				imports = append(imports, path)
+			}
+		}
+	}
+	if err := imp.exports.Build(imports); err != nil {
+		return fmt.Errorf("load the packages imported by %s failure: %v", p.path, err)
+	}
+
+	var checkErr error
+	conf := types.Config{
+		Importer:    imp,
+		FakeImportC: true,
+		Error: func(err error) {
+			if typeErr, ok := err.(types.Error); ok && enhancedImports[typeErr.Pos] {
+				return
+			}
+			if checkErr == nil {
+				checkErr = err
+			}
+		},
+	}
+	p.info = &types.Info{Defs: make(map[*ast.Ident]types.Object), Uses: make(map[*ast.Ident]types.Object)}
+	p.types, _ = conf.Check(p.path, p.dec.Fset, files, p.info)
+	if checkErr != nil {
+		return fmt.Errorf("type check the package %s failure: %v", p.path, checkErr)
+	}
+	return nil
+}
+
+// injectedImporter loads the packages imported by the injected packages for the type checker. The injected packages are
+// checked from the source, the others are loaded from the export data of the compile. The enhanced package is compiling,
+// it has no export data, so it cannot be imported
+type injectedImporter struct {
+	enhanced string
+	packages map[string]*injectedPackage
+	exports  *packageExports
+	gc       types.Importer
+}
+
+func newInjectedImporter(enhanced string, exports *packageExports) *injectedImporter {
+	return &injectedImporter{
+		enhanced: enhanced,
+		packages: make(map[string]*injectedPackage),
+		exports:  exports,
+		gc:       importer.ForCompiler(token.NewFileSet(), "gc", exports.Open),
+	}
+}
+
+func (i *injectedImporter) Import(path string) (*types.Package, error) {
+	if path == i.enhanced {
+		return nil, fmt.Errorf("package %s is compiling", path)
+	}
+	if pkg := i.packages[path]; pkg != nil {
+		if pkg.types == nil {
+			if err := pkg.check(i); err != nil {
+				return nil, err
+			}
+		}
+		return pkg.types, nil
+	}
+	return i.gc.Import(path)
+}
+
+// rename merges the file into the enhanced package. The imports of the merged packages(import path -> prefix) are removed,
+// the qualified identifiers which refer to them become the local names, such as "core.Invocation" to "_skywalking_core_Invocation",
+// and the top-level identifiers of this package are prefixed. The references are resolved by the type checker,
+// so the aliased imports and the shadowed names are handled. It fails when the name is shadowed by a local declaration
+func (p *injectedPackage) rename(file *dst.File, merged map[string]string) error {
+	var err error
+	fail := func(format string, args ...interface{}) {
+		if err == nil {
+			err = fmt.Errorf(format, args...)
+		}
+	}
+	dstutil.Apply(file, func(cursor *dstutil.Cursor) bool {
+		switch x := cursor.Node().(type) {
+		case *dst.ImportSpec:
+			importPath, e := strconv.Unquote(x.Path.Value)
+			if _, ok := merged[importPath]; e != nil || !ok {
+				return true
+			}
+			if x.Name != nil && x.Name.Name == "." {
+				fail("the dot import of %s is not supported, it's merged into the enhanced package", importPath)
+			}
+			cursor.Delete()
+		case *dst.SelectorExpr:
+			pkg, ok := x.X.(*dst.Ident)
+			if !ok {
+				return true
+			}
+			ident, ok := p.dec.Ast.Nodes[pkg].(*ast.Ident)
+			if !ok {
+				return true
+			}
+			pkgName, ok := p.object(ident).(*types.PkgName)
+			if !ok {
+				return true
+			}
+			prefix, ok := merged[pkgName.Imported().Path()]
+			if !ok {
+				return true
+			}
+			name := mangleName(prefix, x.Sel.Name)
+			if prefix == "" {
+				name = x.Sel.Name
+			}
+			if local := p.localObject(name, ident.Pos()); local != nil {
+				fail("%s: %s.%s is shadowed by the %s declared at %s", p.dec.Fset.Position(ident.Pos()),
+					pkg.Name, x.Sel.Name, local.Name(), p.dec.Fset.Position(local.Pos()))
+			}
+			replaced := dst.NewIdent(name)
+			replaced.Decs.NodeDecs = x.Decs.NodeDecs
+			cursor.Replace(replaced)
+			return false
+		case *dst.Ident:
+			ident, ok := p.dec.Ast.Nodes[x].(*ast.Ident)
+			if !ok {
+				return true
+			}
+			def, use := p.info.Defs[ident], p.info.Uses[ident]
+			// the identifiers in the invalid expressions are not recorded, such as the type of the assertion on the unloaded type,
+			// resolves them by the scopes unless they are not the references
+			if def == nil && use == nil && !nonReferenceIdent(cursor) {
+				use = p.lookup(x.Name, ident.Pos())
+			}
+			if p.declared(def) || p.declared(use) {
+				x.Name = mangleName(p.prefix, x.Name)
+			}
+		}
+		return true
+	}, nil)
+	if err != nil {
+		return err
+	}
+	// drops the import declarations which all the specs are removed
+	decls := file.Decls[:0]
+	for _, decl := range file.Decls {
+		if gen, ok := decl.(*dst.GenDecl); ok && gen.Tok == token.IMPORT && len(gen.Specs) == 0 {
+			continue
+		}
+		decls = append(decls, decl)
+	}
+	file.Decls = decls
+	return nil
+}
+
+// declared checks the object is the top-level declaration of the package, or the embedded field of the top-level type,
+// the name of the embedded field is changed with its type
+func (p *injectedPackage) declared(obj types.Object) bool {
+	if obj == nil || p.types == nil {
+		return false
+	}
+	if obj.Parent() == p.types.Scope() {
+		return true
+	}
+	field, ok := obj.(*types.Var)
+	if !ok || !field.Embedded() {
+		return false
+	}
+	t := field.Type()
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	named, ok := t.(*types.Named)
+	return ok && named.Obj().Parent() == p.types.Scope()
+}
+
+// object returns the object which the identifier refers to, by the scopes when the type checker not recorded it
+func (p *injectedPackage) object(ident *ast.Ident) types.Object {
+	if obj := p.info.Uses[ident]; obj != nil {
+		return obj
+	}
+	return p.lookup(ident.Name, ident.Pos())
+}
+
+// localObject returns the object which the name refers to at the position when it's not declared in the package scope,
+// such as the local variables and the imported package names
+func (p *injectedPackage) localObject(name string, pos token.Pos) types.Object {
+	obj := p.lookup(name, pos)
+	if obj == nil || obj.Parent() == p.types.Scope() || obj.Parent() == types.Universe {
+		return nil
+	}
+	return obj
+}
+
+// lookup returns the object which the name refers to at the position by the scopes
+func (p *injectedPackage) lookup(name string, pos token.Pos) types.Object {
+	if p.types == nil {
+		return nil
+	}
+	scope := p.types.Scope().Innermost(pos)
+	if scope == nil {
+		return nil
+	}
+	_, obj := scope.LookupParent(name, pos)
+	return obj
+}
+
+// nonReferenceIdent checks the identifier is a name but not a reference, such as the field name of the selector and the key of the literal,
+// the type of the field is a reference
+func nonReferenceIdent(cursor *dstutil.Cursor) bool {
+	switch cursor.Parent().(type) {
+	case *dst.SelectorExpr:
+		return cursor.Name() == "Sel"
+	case *dst.KeyValueExpr:
+		return cursor.Name() == "Key"
+	case *dst.Field:
+		return cursor.Name() == "Names"
+	case *dst.LabeledStmt, *dst.BranchStmt:
+		return true
+	}
+	return false
+}
+
+// checkInjectedConflicts reports the top-level identifiers and methods declared by the injected files more than once,
+// or also declared by the files of the package
+func checkInjectedConflicts(packageFiles, injectedFiles []string) error {
+	fset := token.NewFileSet()
+	declared := make(map[string][]token.Position)
+	injected := make(map[string]bool)
+	for i, files := range [][]string{packageFiles, injectedFiles} {
+		for _, f := range files {
+			file, err := parser.ParseFile(fset, f, nil, parser.SkipObjectResolution)
+			if err != nil {
+				return err
+			}
+			for name, pos := range topLevelDeclarations(file) {
+				declared[name] = append(declared[name], fset.Position(pos))
+				if i == 1 {
+					injected[name] = true
+				}
+			}
+		}
+	}
+	conflicts := make([]string, 0)
+	for name := range injected {
+		if positions := declared[name]; len(positions) > 1 {
+			locations := make([]string, 0, len(positions))
+			for _, pos := range positions {
+				locations = append(locations, pos.String())
+			}
+			conflicts = append(conflicts, fmt.Sprintf("%s(%s)", name, strings.Join(locations, ", ")))
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return fmt.Errorf("the injected declarations conflict with the package: %s", strings.Join(conflicts, "; "))
+	}
+	return nil
+}
+
+// topLevelDeclarations returns the top-level identifiers and the methods("<type>.<method>") declared in the file
+func topLevelDeclarations(file *ast.File) map[string]token.Pos {
+	result := make(map[string]token.Pos)
+	add := func(ident *ast.Ident, prefix string) {
+		if ident.Name != "_" && ident.Name != "init" {
+			result[prefix+ident.Name] = ident.Pos()
+		}
+	}
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil || len(d.Recv.List) == 0 {
+				add(d.Name, "")
+				continue
+			}
+			recv := d.Recv.List[0].Type
+			for {
+				switch r := recv.(type) {
+				case *ast.StarExpr:
+					recv = r.X
+					continue
+				case *ast.IndexExpr:
+					recv = r.X
+					continue
+				case *ast.IndexListExpr:
+					recv = r.X
+					continue
+				case *ast.ParenExpr:
+					recv = r.X
+					continue
+				}
+				break
+			}
+			if ident, ok := recv.(*ast.Ident); ok {
+				add(d.Name, ident.Name+".")
+			}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					add(s.Name, "")
+				case *ast.ValueSpec:
+					for _, name := range s.Names {
+						add(name, "")
+					}
+				}
+			}
+		}
+	}
+	return result
+}
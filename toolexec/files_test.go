@@ -0,0 +1,166 @@
+package toolexec
+
+import (
+	"github.com/dave/dst/decorator"
+	"github.com/mrproliu/go-agent-instrumentation/toolexec/internal/testplugin"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMangleName(t *testing.T) {
+	tests := map[string]string{
+		"Invocation":           "_skywalking_core_Invocation",
+		"_":                    "_",
+		"init":                 "init",
+		"_skywalking_tls_get":  "_skywalking_tls_get",
+		"_sw_not_linkname_var": "_skywalking_core__sw_not_linkname_var",
+	}
+	for name, expected := range tests {
+		if actual := coreName(name); actual != expected {
+			t.Errorf("%s: %s, expected: %s", name, actual, expected)
+		}
+	}
+	inst := &testplugin.Instrument{}
+	if prefix := pluginPrefix(inst, "."); prefix != "_skywalking_plugin_testplugin_" {
+		t.Errorf("the prefix of the plugin root: %s", prefix)
+	}
+	if prefix := pluginPrefix(inst, "internal/naming"); prefix != "_skywalking_plugin_testplugin_internal_naming_" {
+		t.Errorf("the prefix of the plugin subpackage: %s", prefix)
+	}
+}
+
+func TestInstrumentFilePaths(t *testing.T) {
+	paths, err := instrumentFilePaths(&testplugin.Instrument{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the tagged.go is excluded by the build constraint
+	if actual := strings.Join(paths, ","); actual != "interceptor.go,internal/naming/naming.go" {
+		t.Errorf("the files of the plugin: %s", actual)
+	}
+	if pkg := instrumentPackagePath(&testplugin.Instrument{}); pkg != "github.com/mrproliu/go-agent-instrumentation/toolexec/internal/testplugin" {
+		t.Errorf("the package of the plugin: %s", pkg)
+	}
+}
+
+func TestCheckInjectedConflicts(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"server.go":   "package p\n\ntype Server struct{}\n\nfunc (s *Server) Handle() {}\n\nvar name, _ = \"\", 0\n\nfunc init() {}\n",
+		"injected.go": "package p\n\nfunc (s Server) Handle() {}\n\nfunc init() {}\n\nvar _ = 1\n",
+		"adapter.go":  "package p\n\nvar name string\n\nfunc (s *Server) Adapter() {}\n",
+		// the methods of the generic types with one and multiple type parameters
+		"generic.go":          "package p\n\ntype List[T any] []T\n\nfunc (l List[T]) Len() int { return 0 }\n\ntype Map[K comparable, V any] map[K]V\n\nfunc (m *Map[K, V]) Get(k K) V { return (*m)[k] }\n",
+		"injected_generic.go": "package p\n\nfunc (l *List[_]) Len() int { return 0 }\n\nfunc (m Map[K, V]) Get(k K) V { return m[k] }\n\nfunc (m *Map[K, V]) Set(k K, v V) {}\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	err := checkInjectedConflicts([]string{path("server.go")}, []string{path("injected.go"), path("adapter.go")})
+	if err == nil {
+		t.Fatal("the conflicts are not detected")
+	}
+	for _, conflict := range []string{"Server.Handle(", "name("} {
+		if !strings.Contains(err.Error(), conflict) {
+			t.Errorf("%s is not reported: %v", conflict, err)
+		}
+	}
+	// the init functions and the blank identifiers never conflict
+	if strings.Contains(err.Error(), "init") || strings.Contains(err.Error(), "_(") {
+		t.Errorf("the init or blank is reported: %v", err)
+	}
+	if err := checkInjectedConflicts([]string{path("server.go")}, []string{path("injected.go")}); err == nil {
+		t.Error("the method conflict is not detected")
+	}
+	if err := checkInjectedConflicts([]string{path("injected.go")}, []string{path("adapter.go")}); err != nil {
+		t.Errorf("the different declarations are reported: %v", err)
+	}
+
+	err = checkInjectedConflicts([]string{path("generic.go")}, []string{path("injected_generic.go")})
+	if err == nil {
+		t.Fatal("the conflicts of the generic types are not detected")
+	}
+	for _, conflict := range []string{"List.Len(", "Map.Get("} {
+		if !strings.Contains(err.Error(), conflict) {
+			t.Errorf("%s is not reported: %v", conflict, err)
+		}
+	}
+	if strings.Contains(err.Error(), "Map.Set(") {
+		t.Errorf("the different method of the generic type is reported: %v", err)
+	}
+}
+
+func TestInjectedPackageCheck(t *testing.T) {
+	work := t.TempDir()
+	importCfg := filepath.Join(work, "importcfg")
+	writeImportCfg(t, importCfg, "strings")
+	cfg, err := readImportCfg(importCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exports := &packageExports{cfg: cfg, built: make(map[string]string)}
+	write := func(src string) (string, error) {
+		imp := newInjectedImporter(testTargetPackage, exports)
+		pkg := &injectedPackage{path: "example.com/plugin", prefix: "_skywalking_plugin_test_", dec: decorator.NewDecorator(token.NewFileSet())}
+		imp.packages[pkg.path] = pkg
+		if err := pkg.parse(fstest.MapFS{"plugin.go": {Data: []byte(src)}}, "plugin.go"); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(work, "plugin.go")
+		if err := pkg.write(pkg.files[0], map[string]string{testTargetPackage: ""}, "testtarget", path, imp); err != nil {
+			return "", err
+		}
+		content, err := os.ReadFile(path)
+		return string(content), err
+	}
+
+	// the references to the enhanced package are not resolved, the field types are still renamed
+	output, err := write(`package plugin
+
+import (
+	"strings"
+
+	"github.com/mrproliu/go-agent-instrumentation/toolexec/internal/testtarget"
+)
+
+type Local struct{}
+
+func handle(v interface{}) (interface{}, bool) {
+	req, ok := v.(*testtarget.Request)
+	if !ok {
+		return nil, false
+	}
+	local, ok := req.Header.(interface{ Local(name Local) *Local })
+	return local, ok && strings.HasPrefix(req.Path, "/")
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"type _skywalking_plugin_test_Local struct", "Local(name _skywalking_plugin_test_Local) *_skywalking_plugin_test_Local",
+		"v.(*Request)", "func _skywalking_plugin_test_handle("} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("%q is not found in the output:\n%s", expected, output)
+		}
+	}
+
+	// the other type errors fail the check
+	for src, expected := range map[string]string{
+		"package plugin\n\nvar count int = \"1\"\n":                                        "cannot use \"1\"",
+		"package plugin\n\nimport \"strings\"\n\nvar upper = strings.ToUpperCase(\"a\")\n": "ToUpperCase",
+	} {
+		if _, err := write(src); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("the type error %q is not reported: %v", expected, err)
+		}
+	}
+}
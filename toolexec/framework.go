@@ -1,47 +1,50 @@
-package main
+package toolexec
 
 import (
 	"bytes"
 	"fmt"
 	"github.com/dave/dst"
-	"github.com/dave/dst/decorator"
 	"github.com/dave/dst/dstutil"
 	"github.com/mrproliu/go-agent-instrumentation/framework/core"
-	"github.com/mrproliu/go-agent-instrumentation/frameworks/gin"
-	"html/template"
+	"go/token"
 	"io"
-	"io/fs"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"text/template"
 )
 
-var frameworkInstruments []core.Instrument
 var frameworkGeneratePrefix = "_skywalking_enhance_"
 
-func init() {
-	frameworkInstruments = append(frameworkInstruments, &gin.Instrument{})
-}
+// the functions of the adapter templates, "core" returns the name of the core identifier in the enhanced package
+var adapterTemplateFuncs = template.FuncMap{"core": coreName}
 
 type FrameworkInstrument struct {
 	points       []*InstrumentPoint
 	enhances     []FrameworkEnhanceInfo
 	replacements map[string]map[string]string
+	exports      *packageExports // loads the packages imported by the injected files for the type checker
 }
 
-func NewFrameworkInstrument() *FrameworkInstrument {
+func NewFrameworkInstrument(instruments []core.Instrument, exports *packageExports) *FrameworkInstrument {
 	points := make([]*InstrumentPoint, 0)
-	result := &FrameworkInstrument{}
+	result := &FrameworkInstrument{exports: exports}
 	replacements := make(map[string]map[string]string)
-	for _, inst := range frameworkInstruments {
+	for _, inst := range instruments {
 		for _, point := range inst.Points() {
 			points = append(points, func(p *core.InstrumentPoint, i core.Instrument) *InstrumentPoint {
+				imports := make(map[string]string) // the imports of the file, name -> path
 				return &InstrumentPoint{
 					Package: filepath.Join(inst.BasePackage(), point.PackagePath),
 					File:    point.FileName,
 					FilterAndEdit: func(cursor *dstutil.Cursor) bool {
+						if spec, ok := cursor.Node().(*dst.ImportSpec); ok {
+							if name, path := importSpecName(spec); name != "" {
+								imports[name] = path
+							}
+						}
 						if p.EnhanceStruct != nil && p.EnhanceStruct(cursor) {
 							spec := cursor.Node().(*dst.TypeSpec)
 							enhanceInfo := NewFrameworkEnhanceTypeInfo(p, i, spec)
@@ -51,7 +54,7 @@ func NewFrameworkInstrument() *FrameworkInstrument {
 						}
 						if p.FilterMethod != nil && p.FilterMethod(cursor) {
 							decl := cursor.Node().(*dst.FuncDecl)
-							methodInfo := NewFrameworkEnhanceMethodInfo(p, i, decl)
+							methodInfo := NewFrameworkEnhanceMethodInfo(p, i, decl, imports)
 							result.enhances = append(result.enhances, methodInfo)
 
 							curFileReplacement := methodInfo.BuildForInvoker()
@@ -96,6 +99,25 @@ func (f *FrameworkInstrument) WriteExtraFiles(basePath string) ([]string, error)
 		Name: dst.NewIdent(packageName),
 	}
 
+	adapterImports := make(map[string]string)
+	for _, m := range f.enhances {
+		for name, path := range m.AdapterImports() {
+			adapterImports[name] = path
+		}
+	}
+	if len(adapterImports) > 0 {
+		importDecl := &dst.GenDecl{Tok: token.IMPORT}
+		for _, name := range sortedKeys(adapterImports) {
+			spec := &dst.ImportSpec{Path: &dst.BasicLit{Kind: token.STRING, Value: strconv.Quote(adapterImports[name])}}
+			if name != importPathName(adapterImports[name]) {
+				spec.Name = dst.NewIdent(name)
+			}
+			importDecl.Specs = append(importDecl.Specs, spec)
+		}
+		importDecl.Lparen = len(importDecl.Specs) > 1
+		file.Decls = append(file.Decls, importDecl)
+	}
+
 	for _, m := range f.enhances {
 		for _, fu := range m.BuildForAdapter() {
 			file.Decls = append(file.Decls, fu)
@@ -112,137 +134,59 @@ func (f *FrameworkInstrument) WriteExtraFiles(basePath string) ([]string, error)
 		return nil, err
 	}
 
-	// intercepter file should write to the go2sky
-	intercepter := filepath.Join(basePath, "sw_intercepter.go")
-	if err := ioutil.WriteFile(intercepter, []byte(fmt.Sprintf(`package %s
-import _ "unsafe"
-
-var (
-	GetGLS = func() interface{} { return nil }
-	SetGLS = func(interface{}) {}
-)
-
-//go:linkname _skywalking_tls_get _skywalking_tls_get
-var _skywalking_tls_get func() interface{}
-
-//go:linkname _skywalking_tls_set _skywalking_tls_set
-var _skywalking_tls_set func(interface{})
-
-func init() {
-	if _skywalking_tls_get != nil && _skywalking_tls_set != nil {
-		GetGLS = _skywalking_tls_get
-		SetGLS = _skywalking_tls_set
-	}
-}
-
-type EnhancedInstance interface {
-	GetSkyWalkingDynamicField() interface{}
-	SetSkyWalkingDynamicField(interface{})
-}
-
-type Invocation struct {
-	CallerInstance interface{}
-	Args           []interface{}
-
-	Continue bool
-	Return   []interface{}
-}
-`, packageName)), 0644); err != nil {
-		return nil, err
-	}
-
-	// temporary only process the root dir
-	insFS := f.enhances[0].GetInstrument().FS()
-	dirEntries, err := fs.ReadDir(insFS, ".")
+	// copy the core into the package, the interceptors depend on it
+	packagePath := filepath.Join(f.enhances[0].GetInstrument().BasePackage(), f.enhances[0].GetPoint().PackagePath)
+	imp := newInjectedImporter(filepath.ToSlash(packagePath), f.exports)
+	coreFiles, err := writeCoreFiles(basePath, packageName, imp)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	// import interceptors
+	// import interceptors, once for every instrument which enhances the package
 	writedFiles := make([]string, 0)
-	writedFiles = append(writedFiles, adapterFile, intercepter)
-	for _, entry := range dirEntries {
-		if entry.IsDir() {
-			continue
-		}
-		if entry.Name() == "go.mod" || entry.Name() == "go.sum" || entry.Name() == "instrument.go" {
+	writedFiles = append(writedFiles, adapterFile)
+	writedFiles = append(writedFiles, coreFiles...)
+	written := make(map[string]bool)
+	for _, e := range f.enhances {
+		if written[e.GetInstrument().Name()] {
 			continue
 		}
-
-		readFile, err := fs.ReadFile(insFS, entry.Name())
-		if err != nil {
-			return nil, err
-		}
-
-		parse, err := decorator.Parse(readFile)
+		written[e.GetInstrument().Name()] = true
+		files, err := writeInstrumentFiles(basePath, packageName, e.GetInstrument(), e.GetPoint(), imp)
 		if err != nil {
 			return nil, err
 		}
-		var currentPackageImportPath = filepath.Join(f.enhances[0].GetInstrument().BasePackage(), f.enhances[0].GetPoint().PackagePath)
-		var shouldRemovePkgRef = []string{"core"}
-		dstutil.Apply(parse, func(cursor *dstutil.Cursor) bool {
-			node := cursor.Node()
-			switch x := node.(type) {
-			case *dst.ImportSpec:
-				if filepath.Base(x.Path.Value) == "core\"" { // delete core import, in real case, it should be renamed to the go2sky
-					cursor.Delete()
-					return true
-				}
-				currentPackage := "\"" + currentPackageImportPath + "\""
-				if x.Path.Value == currentPackage {
-					if x.Name != nil {
-						shouldRemovePkgRef = append(shouldRemovePkgRef, x.Name.Name)
-					} else {
-						shouldRemovePkgRef = append(shouldRemovePkgRef, filepath.Base(currentPackageImportPath))
-					}
-					cursor.Delete()
-				}
-			case *dst.FuncDecl: // delete core package use
-				if (x.Name.Name == "BeforeInvoke" || x.Name.Name == "AfterInvoke") && len(x.Type.Params.List) > 0 {
-					ref, ok := x.Type.Params.List[0].Type.(*dst.StarExpr)
-					if !ok {
-						return true
-					}
-					ref.X = dst.NewIdent("Invocation")
-				}
-			case *dst.SelectorExpr:
-				pkgRefName, ok := x.X.(*dst.Ident)
-				if !ok {
-					return true
-				}
-				for _, ref := range shouldRemovePkgRef {
-					if pkgRefName.Name == ref {
-						switch p := cursor.Parent().(type) {
-						case *dst.CallExpr:
-							p.Fun = x.Sel
-						case *dst.StarExpr:
-							p.X = x.Sel
-						case *dst.TypeAssertExpr:
-							p.Type = x.Sel
-						}
-						//cursor.Parent().(dst.Expr).X = dst.NewIdent(x.Sel.Name)
-					}
-				}
+		writedFiles = append(writedFiles, files...)
+	}
+	return writedFiles, nil
+}
 
-				return true
-			}
-			return true
-		}, func(cursor *dstutil.Cursor) bool {
-			return true
-		})
+// importSpecName returns the name and path of the import, the name is empty for the blank and dot imports
+func importSpecName(spec *dst.ImportSpec) (name, path string) {
+	path, err := strconv.Unquote(spec.Path.Value)
+	if err != nil {
+		return "", ""
+	}
+	if spec.Name == nil {
+		return importPathName(path), path
+	}
+	if spec.Name.Name == "_" || spec.Name.Name == "." {
+		return "", ""
+	}
+	return spec.Name.Name, path
+}
 
-		path := filepath.Join(basePath, fmt.Sprintf("sw_enhance_%s", entry.Name()))
-		output, err := os.Create(path)
-		if err != nil {
-			return nil, err
-		}
-		defer output.Close()
-		if e := writeFile(parse, output); e != nil {
-			return nil, e
-		}
-		writedFiles = append(writedFiles, path)
+// importPathName guesses the package name from the import path, such as "gopkg.in/yaml.v2" and "github.com/a/b/v2"
+func importPathName(path string) string {
+	elements := strings.Split(path, "/")
+	name := elements[len(elements)-1]
+	if len(elements) > 1 && regexp.MustCompile(`^v[0-9]+$`).MatchString(name) {
+		name = elements[len(elements)-2]
 	}
-	return writedFiles, nil
+	if inx := strings.Index(name, "."); inx > 0 {
+		name = name[:inx]
+	}
+	return strings.TrimPrefix(name, "go-")
 }
 
 func buildFrameworkFuncID(pkgPath string, node *dst.FuncDecl) string {
@@ -265,7 +209,8 @@ func buildFrameworkFuncID(pkgPath string, node *dst.FuncDecl) string {
 type FrameworkEnhanceInfo interface {
 	GetPoint() *core.InstrumentPoint
 	GetInstrument() core.Instrument
-	BuildForAdapter() []*dst.FuncDecl
+	BuildForAdapter() []dst.Decl
+	AdapterImports() map[string]string // the imports used by the adapter, name -> path
 }
 
 type FrameworkEnhanceTypeInfo struct {
@@ -286,18 +231,30 @@ func (f *FrameworkEnhanceTypeInfo) GetPoint() *core.InstrumentPoint {
 	return f.Point
 }
 
+func (f *FrameworkEnhanceTypeInfo) AdapterImports() map[string]string {
+	return nil
+}
+
+// the field injected into the enhanced struct and its accessors, same as the enhancedInstance of the core.
+// They have the prefix of the injected identifiers, so never conflict with the fields and methods of the struct
+const (
+	dynamicFieldName   = injectedPrefix + "dynamic_field"
+	dynamicFieldGetter = injectedPrefix + "get_dynamic_field"
+	dynamicFieldSetter = injectedPrefix + "set_dynamic_field"
+)
+
 func (f *FrameworkEnhanceTypeInfo) EnhanceField() {
 	structType := f.TypeSpec.Type.(*dst.StructType)
 	structType.Fields.List = append(structType.Fields.List, &dst.Field{
-		Names: []*dst.Ident{dst.NewIdent("skywalking_dynamic_field")},
+		Names: []*dst.Ident{dst.NewIdent(dynamicFieldName)},
 		Type:  dst.NewIdent("interface{}"),
 	})
 }
 
-func (f *FrameworkEnhanceTypeInfo) BuildForAdapter() []*dst.FuncDecl {
-	return []*dst.FuncDecl{
-		{
-			Name: &dst.Ident{Name: "GetSkyWalkingDynamicField"},
+func (f *FrameworkEnhanceTypeInfo) BuildForAdapter() []dst.Decl {
+	return []dst.Decl{
+		&dst.FuncDecl{
+			Name: &dst.Ident{Name: dynamicFieldGetter},
 			Recv: &dst.FieldList{
 				List: []*dst.Field{
 					{
@@ -315,11 +272,11 @@ func (f *FrameworkEnhanceTypeInfo) BuildForAdapter() []*dst.FuncDecl {
 				},
 			},
 			Body: &dst.BlockStmt{
-				List: goStringToStmts("return receiver.skywalking_dynamic_field", false),
+				List: goStringToStmts("return receiver."+dynamicFieldName, false),
 			},
 		},
-		{
-			Name: &dst.Ident{Name: "SetSkyWalkingDynamicField"},
+		&dst.FuncDecl{
+			Name: &dst.Ident{Name: dynamicFieldSetter},
 			Recv: &dst.FieldList{
 				List: []*dst.Field{
 					{
@@ -340,7 +297,7 @@ func (f *FrameworkEnhanceTypeInfo) BuildForAdapter() []*dst.FuncDecl {
 				Results: &dst.FieldList{},
 			},
 			Body: &dst.BlockStmt{
-				List: goStringToStmts("receiver.skywalking_dynamic_field = param", false),
+				List: goStringToStmts("receiver."+dynamicFieldName+" = param", false),
 			},
 		},
 	}
@@ -353,26 +310,56 @@ type FrameworkEnhanceMethodInfo struct {
 	FuncParameters []*ParameterInfo
 	FuncRecvs      []*ParameterInfo
 	FuncResults    []*ParameterInfo
+	// the index of the first context.Context parameter, -1 if not exist,
+	// the adapter syncs the span between the context and the GLS through it
+	ContextParameter int
 
 	adapterPreFuncName  string
 	adapterPostFuncName string
+	adapterSwitchName   string // the package variable of the interceptor switch, checked before invoking the interceptor
+	adapterImports      map[string]string
 }
 
-func NewFrameworkEnhanceMethodInfo(p *core.InstrumentPoint, i core.Instrument, f *dst.FuncDecl) *FrameworkEnhanceMethodInfo {
+func NewFrameworkEnhanceMethodInfo(p *core.InstrumentPoint, i core.Instrument, f *dst.FuncDecl,
+	fileImports map[string]string) *FrameworkEnhanceMethodInfo {
 	info := &FrameworkEnhanceMethodInfo{
-		Point:      p,
-		Instrument: i,
-		FuncDecl:   f,
+		Point:            p,
+		Instrument:       i,
+		FuncDecl:         f,
+		ContextParameter: -1,
+		adapterImports:   make(map[string]string),
 	}
 	info.FuncParameters = enhanceParameterNames(f.Type.Params)
 	info.FuncResults = enhanceParameterNames(f.Type.Results)
 	if f.Recv != nil {
 		info.FuncRecvs = enhanceParameterNames(f.Recv)
 	}
+	for inx, param := range info.FuncParameters {
+		if sel, ok := param.Type.(*dst.SelectorExpr); ok && sel.Sel.Name == "Context" {
+			if pkg, ok := sel.X.(*dst.Ident); ok && fileImports[pkg.Name] == "context" {
+				info.ContextParameter = inx
+				break
+			}
+		}
+	}
+	// the adapter declares the same types as the method, so it needs the imports used by them
+	for _, params := range [][]*ParameterInfo{info.FuncRecvs, info.FuncParameters, info.FuncResults} {
+		for _, param := range params {
+			dst.Inspect(param.Type, func(node dst.Node) bool {
+				if sel, ok := node.(*dst.SelectorExpr); ok {
+					if pkg, ok := sel.X.(*dst.Ident); ok && fileImports[pkg.Name] != "" {
+						info.adapterImports[pkg.Name] = fileImports[pkg.Name]
+					}
+				}
+				return true
+			})
+		}
+	}
 
 	funcID := buildFrameworkFuncID(filepath.Join(i.BasePackage(), p.PackagePath), f)
 	info.adapterPreFuncName = fmt.Sprintf("%s%s", frameworkGeneratePrefix, funcID)
 	info.adapterPostFuncName = fmt.Sprintf("%s%s_ret", frameworkGeneratePrefix, funcID)
+	info.adapterSwitchName = fmt.Sprintf("%s%s_switch", frameworkGeneratePrefix, funcID)
 	return info
 }
 
@@ -384,6 +371,10 @@ func (e *FrameworkEnhanceMethodInfo) GetPoint() *core.InstrumentPoint {
 	return e.Point
 }
 
+func (e *FrameworkEnhanceMethodInfo) AdapterImports() map[string]string {
+	return e.adapterImports
+}
+
 func (e *FrameworkEnhanceMethodInfo) BuildForInvoker() map[string]string {
 	invokerResultParams := ""
 	if len(e.FuncResults) > 0 {
@@ -440,7 +431,23 @@ func (e *FrameworkEnhanceMethodInfo) BuildForInvoker() map[string]string {
 	return map[string]string{replacedName: result}
 }
 
-func (e *FrameworkEnhanceMethodInfo) BuildForAdapter() []*dst.FuncDecl {
+func (e *FrameworkEnhanceMethodInfo) BuildForAdapter() []dst.Decl {
+	// the switch is registered when the package initializing, the interceptor is skipped when the agent disables it
+	switchDecl := &dst.GenDecl{
+		Tok: token.VAR,
+		Specs: []dst.Spec{
+			&dst.ValueSpec{
+				Names: []*dst.Ident{dst.NewIdent(e.adapterSwitchName)},
+				Values: []dst.Expr{&dst.CallExpr{
+					Fun: dst.NewIdent(coreName("interceptorSwitch")),
+					Args: []dst.Expr{
+						&dst.BasicLit{Kind: token.STRING, Value: strconv.Quote(e.Instrument.Name())},
+						&dst.BasicLit{Kind: token.STRING, Value: strconv.Quote(e.Point.InterceptorName)},
+					},
+				}},
+			},
+		},
+	}
 	preFunc := &dst.FuncDecl{
 		Name: &dst.Ident{Name: e.adapterPreFuncName},
 		Type: &dst.FuncType{
@@ -463,19 +470,24 @@ func (e *FrameworkEnhanceMethodInfo) BuildForAdapter() []*dst.FuncDecl {
 	for i, result := range e.FuncResults {
 		preFunc.Type.Results.List = append(preFunc.Type.Results.List, &dst.Field{
 			Names: []*dst.Ident{dst.NewIdent(fmt.Sprintf("ret_%d", i))},
-			Type:  &dst.StarExpr{X: dst.Clone(result.Type).(dst.Expr)},
+			Type:  dst.Clone(result.Type).(dst.Expr),
 		})
 	}
 	preFunc.Type.Results.List = append(preFunc.Type.Results.List, &dst.Field{
 		Names: []*dst.Ident{dst.NewIdent("inv")},
-		Type:  &dst.StarExpr{X: dst.NewIdent("Invocation")},
+		Type:  &dst.StarExpr{X: dst.NewIdent(coreName("Invocation"))},
 	})
 	preFunc.Type.Results.List = append(preFunc.Type.Results.List, &dst.Field{
 		Names: []*dst.Ident{dst.NewIdent("keep")},
 		Type:  dst.NewIdent("bool"),
 	})
 
-	parse, err := template.New("").Parse(`invocation := &Invocation{}
+	parse, err := template.New("").Funcs(adapterTemplateFuncs).Parse(`if !{{core "interceptorEnabled"}}({{.SwitchName}}) {
+	return {{ range $index, $value := .FuncResults -}}
+{{- if ne $index 0}}, {{end}}ret_{{$index}}
+{{- end}}{{if .FuncResults}}, {{- end}}nil, true
+}
+invocation := &{{core "Invocation"}}{}
 {{if .FuncRecvs -}}
 invocation.CallerInstance = *recv_0	// for caller if exist
 {{- end}}
@@ -483,22 +495,29 @@ invocation.Args = make([]interface{}, {{len .FuncParameters}})
 {{- range $index, $value := .FuncParameters}}
 invocation.Args[{{$index}}] = *param_{{$index}}
 {{- end}}
+{{- if ge .ContextParameter 0}}
+*param_{{.ContextParameter}}, invocation.restoreGLS = {{core "SyncContextGLS"}}(*param_{{.ContextParameter}})
+invocation.Args[{{.ContextParameter}}] = *param_{{.ContextParameter}}
+{{- end}}
 
-inter := &{{.Point.InterceptorName}}{}
+inter := &{{.InterceptorType}}{}
 // real invoke
 if err := inter.BeforeInvoke(invocation); err != nil {
 	// using go2sky log error
 	return {{ range $index, $value := .FuncResults -}}
-{{- if ne .index 0}}, {{end}}$value.DefaultValueAsString
+{{- if ne $index 0}}, {{end}}ret_{{$index}}
 {{- end}}{{if .FuncResults}}, {{- end}}invocation, true
 }
 if (invocation.Continue) {
+	if invocation.restoreGLS != nil {
+		invocation.restoreGLS()
+	}
 	return {{ range $index, $value := .FuncResults -}}
-{{- if ne .index 0}}, {{end}}$value.DefaultValueAsString
+{{- if ne $index 0}}, {{end}}ret_{{$index}}
 {{- end}}{{if .FuncResults}}, {{- end}}invocation, false
 }
 return {{ range $index, $value := .FuncResults -}}
-{{- if ne .index 0}}, {{end}}$value.DefaultValueAsString
+{{- if ne $index 0}}, {{end}}ret_{{$index}}
 {{- end}}{{if .FuncResults}}, {{- end}}invocation, true`)
 	if err != nil {
 		panic(fmt.Errorf("parse pre funtion failure: %v", err))
@@ -522,7 +541,7 @@ return {{ range $index, $value := .FuncResults -}}
 	}
 	postFunc.Type.Params.List = append(postFunc.Type.Params.List, &dst.Field{
 		Names: []*dst.Ident{dst.NewIdent("invocation")},
-		Type:  &dst.StarExpr{X: dst.NewIdent("Invocation")},
+		Type:  &dst.StarExpr{X: dst.NewIdent(coreName("Invocation"))},
 	})
 	for inx, f := range e.FuncResults {
 		postFunc.Type.Params.List = append(postFunc.Type.Params.List, &dst.Field{
@@ -530,10 +549,14 @@ return {{ range $index, $value := .FuncResults -}}
 			Type:  &dst.StarExpr{X: dst.Clone(f.Type).(dst.Expr)},
 		})
 	}
-	parse, err = template.New("").Parse(`inter := &{{.Point.InterceptorName}}{}
-inter.AfterInvoke(invocation{{ range $index, $value := .FuncResults -}}
-{{- if ne .index 0}}, {{end}}ret_$index
-{{- end}})`)
+	parse, err = template.New("").Funcs(adapterTemplateFuncs).Parse(`if invocation == nil {
+	return
+}
+inter := &{{.InterceptorType}}{}
+inter.AfterInvoke(invocation{{ range $index, $value := .FuncResults }}, ret_{{$index}}{{ end }})
+if invocation.restoreGLS != nil {
+	invocation.restoreGLS()
+}`)
 	if err != nil {
 		panic(fmt.Errorf("parse pre funtion failure: %v", err))
 	}
@@ -546,7 +569,17 @@ inter.AfterInvoke(invocation{{ range $index, $value := .FuncResults -}}
 	postFunc.Body = &dst.BlockStmt{
 		List: goStringToStmts(buffer.String(), false),
 	}
-	return []*dst.FuncDecl{preFunc, postFunc}
+	return []dst.Decl{switchDecl, preFunc, postFunc}
+}
+
+// InterceptorType is the name of the interceptor in the enhanced package, used by the adapter template
+func (e *FrameworkEnhanceMethodInfo) InterceptorType() string {
+	return mangleName(pluginPrefix(e.Instrument, "."), e.Point.InterceptorName)
+}
+
+// SwitchName is the package variable of the interceptor switch, used by the adapter template
+func (e *FrameworkEnhanceMethodInfo) SwitchName() string {
+	return e.adapterSwitchName
 }
 
 func (r *FrameworkInstrument) ExtraChangesForEnhancedFile(f string) error {
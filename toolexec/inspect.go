@@ -0,0 +1,220 @@
+package toolexec
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/dave/dst/dstutil"
+	"go/parser"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+)
+
+type inspectPackage struct {
+	ImportPath string
+	Dir        string
+	GoFiles    []string
+}
+
+type inspectResult struct {
+	Package     string `json:"package"`
+	File        string `json:"file"`
+	Kind        string `json:"kind"`
+	Name        string `json:"name"`
+	Plugin      string `json:"plugin"`
+	Interceptor string `json:"interceptor,omitempty"`
+}
+
+// inspect loads the packages of a module and reports which code would be enhanced, without building anything
+func inspect(args []string) error {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	format := flags.String("format", "table", "output format, table or json")
+	dir := flags.String("dir", ".", "the module directory to inspect")
+	configFile := flags.String("config", "", "the agent config file, the disabled plugins and packages are not reported")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := loadBuildConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	patterns := flags.Args()
+	if len(patterns) == 0 {
+		patterns = []string{"./..."}
+	}
+
+	packages, err := listPackages(*dir, patterns)
+	if err != nil {
+		return err
+	}
+	results, err := inspectPackages(packages, cfg)
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "json":
+		return writeInspectJSON(os.Stdout, results)
+	case "table":
+		return writeInspectTable(os.Stdout, results)
+	default:
+		return fmt.Errorf("unknown output format: %s", *format)
+	}
+}
+
+func listPackages(dir string, patterns []string) ([]*inspectPackage, error) {
+	cmd := exec.Command("go", append([]string{"list", "-deps", "-json"}, patterns...)...)
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	output, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	packages := make([]*inspectPackage, 0)
+	decoder := json.NewDecoder(output)
+	for {
+		pkg := &inspectPackage{}
+		if err := decoder.Decode(pkg); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		packages = append(packages, pkg)
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("list packages failure: %v", err)
+	}
+	return packages, nil
+}
+
+func inspectPackages(packages []*inspectPackage, cfg *buildConfig) ([]*inspectResult, error) {
+	results := make([]*inspectResult, 0)
+	for _, pkg := range packages {
+		if !cfg.PackageInstrumented(pkg.ImportPath) {
+			continue
+		}
+		var runtimePoints []*InstrumentPoint
+		if pkg.ImportPath == "runtime" {
+			// the runtime locates in $GOROOT/src/runtime
+			runtimeInst, err := NewRuntimeInstrument(goRootVersion(filepath.Dir(filepath.Dir(pkg.Dir))))
+			if err != nil {
+				return nil, err
+			}
+			runtimePoints = runtimeInst.HookPoints()
+		}
+		for _, file := range pkg.GoFiles {
+			// the matchers may edit the file, so every point works on a fresh parsed file
+			parse := func() (*dst.File, error) {
+				return decorator.ParseFile(nil, filepath.Join(pkg.Dir, file), nil, parser.ParseComments)
+			}
+
+			for _, inst := range cfg.EnabledInstruments() {
+				for _, point := range inst.Points() {
+					if filepath.Join(inst.BasePackage(), point.PackagePath) != pkg.ImportPath || point.FileName != file {
+						continue
+					}
+					f, err := parse()
+					if err != nil {
+						return nil, err
+					}
+					dstutil.Apply(f, func(cursor *dstutil.Cursor) bool {
+						if point.EnhanceStruct != nil && point.EnhanceStruct(cursor) {
+							results = append(results, newInspectResult(pkg, file, cursor.Node(), inst.Name(), ""))
+						}
+						if point.FilterMethod != nil && point.FilterMethod(cursor) {
+							results = append(results, newInspectResult(pkg, file, cursor.Node(), inst.Name(), point.InterceptorName))
+						}
+						return true
+					}, nil)
+				}
+			}
+
+			for _, point := range runtimePoints {
+				if point.File != file {
+					continue
+				}
+				f, err := parse()
+				if err != nil {
+					return nil, err
+				}
+				dstutil.Apply(f, func(cursor *dstutil.Cursor) bool {
+					if point.FilterAndEdit(cursor) {
+						results = append(results, newInspectResult(pkg, file, cursor.Node(), "runtime", ""))
+					}
+					return true
+				}, nil)
+			}
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Package != results[j].Package {
+			return results[i].Package < results[j].Package
+		}
+		if results[i].File != results[j].File {
+			return results[i].File < results[j].File
+		}
+		return results[i].Name < results[j].Name
+	})
+	return results, nil
+}
+
+func newInspectResult(pkg *inspectPackage, file string, node dst.Node, plugin, interceptor string) *inspectResult {
+	result := &inspectResult{
+		Package:     pkg.ImportPath,
+		File:        file,
+		Plugin:      plugin,
+		Interceptor: interceptor,
+	}
+	switch n := node.(type) {
+	case *dst.FuncDecl:
+		result.Kind = "function"
+		result.Name = n.Name.Name
+		if n.Recv != nil && len(n.Recv.List) > 0 {
+			result.Kind = "method"
+			result.Name = fmt.Sprintf("(%s).%s", receiverTypeName(n.Recv.List[0].Type), n.Name.Name)
+		}
+	case *dst.TypeSpec:
+		result.Kind = "struct"
+		result.Name = n.Name.Name
+	}
+	return result
+}
+
+func receiverTypeName(expr dst.Expr) string {
+	switch t := expr.(type) {
+	case *dst.StarExpr:
+		return "*" + receiverTypeName(t.X)
+	case *dst.Ident:
+		return t.Name
+	case *dst.IndexExpr:
+		return receiverTypeName(t.X)
+	case *dst.IndexListExpr:
+		return receiverTypeName(t.X)
+	}
+	return ""
+}
+
+func writeInspectJSON(w io.Writer, results []*inspectResult) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(results)
+}
+
+func writeInspectTable(w io.Writer, results []*inspectResult) error {
+	writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "PACKAGE\tFILE\tKIND\tNAME\tPLUGIN\tINTERCEPTOR")
+	for _, r := range results {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Package, r.File, r.Kind, r.Name, r.Plugin, r.Interceptor)
+	}
+	return writer.Flush()
+}
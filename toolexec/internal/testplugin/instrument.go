@@ -0,0 +1,58 @@
+// Package testplugin is the plugin used by the tests of the toolexec, it is registered by the tests,
+// not imported by the toolexec program
+package testplugin
+
+import (
+	"embed"
+	"github.com/dave/dst"
+	"github.com/dave/dst/dstutil"
+	"github.com/mrproliu/go-agent-instrumentation/framework/core"
+)
+
+//go:embed interceptor.go tagged.go internal
+var assets embed.FS
+
+type Instrument struct {
+}
+
+func (i *Instrument) Name() string {
+	return "testplugin"
+}
+
+func (i *Instrument) BasePackage() string {
+	return "github.com/mrproliu/go-agent-instrumentation/toolexec/internal/testtarget"
+}
+
+func (i *Instrument) FS() *embed.FS {
+	return &assets
+}
+
+func (i *Instrument) Files(packagePath string) []string {
+	return []string{"interceptor.go", "tagged.go", "internal"}
+}
+
+func (i *Instrument) Points() []*core.InstrumentPoint {
+	return []*core.InstrumentPoint{
+		{
+			PackagePath: "",
+			FileName:    "server.go",
+			FilterMethod: func(cursor *dstutil.Cursor) bool {
+				n, ok := cursor.Node().(*dst.FuncDecl)
+				if !ok || n.Name.Name != "Handle" || n.Recv == nil || len(n.Recv.List) == 0 {
+					return false
+				}
+				expr, ok := n.Recv.List[0].Type.(*dst.StarExpr)
+				if !ok {
+					return false
+				}
+				ident, ok := expr.X.(*dst.Ident)
+				return ok && ident.Name == "Server"
+			},
+			InterceptorName: "ServerInterceptor",
+			EnhanceStruct: func(cursor *dstutil.Cursor) bool {
+				n, ok := cursor.Node().(*dst.TypeSpec)
+				return ok && n.Name.Name == "Server"
+			},
+		},
+	}
+}
@@ -0,0 +1,37 @@
+package testplugin
+
+import (
+	"github.com/mrproliu/go-agent-instrumentation/framework/core"
+	"github.com/mrproliu/go-agent-instrumentation/toolexec/internal/testplugin/internal/naming"
+	target "github.com/mrproliu/go-agent-instrumentation/toolexec/internal/testtarget"
+)
+
+type ServerInterceptor struct {
+}
+
+func (s *ServerInterceptor) BeforeInvoke(invocation *core.Invocation) error {
+	req := invocation.Args[1].(*target.Request)
+	// counts the requests of the server in the field injected into it
+	count, _ := core.GetDynamicField(invocation.CallerInstance).(int)
+	core.SetDynamicField(invocation.CallerInstance, count+1)
+	invocation.Attachment = core.GetTracer().CreateEntrySpan(operationName(req), core.HeaderCarrier(req.Header),
+		core.WithTag("http.method", req.Method))
+	return nil
+}
+
+func (s *ServerInterceptor) AfterInvoke(invocation *core.Invocation, result ...interface{}) error {
+	span, ok := invocation.Attachment.(*core.Span)
+	if !ok {
+		return nil
+	}
+	if err, ok := result[1].(error); ok && err != nil {
+		span.Error(err)
+	}
+	span.End()
+	return nil
+}
+
+// operationName is also declared in the enhanced package
+func operationName(req *target.Request) string {
+	return naming.Operation(req.Method, req.Path)
+}
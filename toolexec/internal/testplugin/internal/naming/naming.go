@@ -0,0 +1,8 @@
+package naming
+
+import "strings"
+
+// Operation is the operation name of the request
+func Operation(method, path string) string {
+	return strings.ToUpper(method) + ":" + path
+}
@@ -0,0 +1,6 @@
+//go:build skywalking_never
+
+package testplugin
+
+// never copied, the build constraint is not satisfied
+var tagged = operationName
@@ -0,0 +1,12 @@
+package testtarget
+
+// the names declared by the core and the test plugin, the injected identifiers should not conflict with them
+type Invocation struct {
+	Name string
+}
+
+var GetGLS = func() string { return "testtarget" }
+
+func operationName(req *Request) string {
+	return req.Path
+}
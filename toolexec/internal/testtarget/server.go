@@ -0,0 +1,34 @@
+// Package testtarget is the package enhanced by the test plugin in the tests of the toolexec
+package testtarget
+
+import (
+	"context"
+	"strings"
+)
+
+type Request struct {
+	Method string
+	Path   string
+	Header map[string][]string
+}
+
+type Server struct {
+	name string
+}
+
+func NewServer(name string) *Server {
+	return &Server{name: name}
+}
+
+// Handle is intercepted by the test plugin
+func (s *Server) Handle(ctx context.Context, req *Request) (string, error) {
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	return s.name + ":" + strings.ToLower(req.Method) + " " + req.Path, nil
+}
+
+// ignored is not intercepted
+func (s *Server) ignored(req *Request) string {
+	return req.Path
+}
@@ -1,4 +1,4 @@
-package main
+package toolexec
 
 import (
 	"fmt"
@@ -11,7 +11,6 @@ import (
 	"io"
 	"os"
 	"path/filepath"
-	"strings"
 )
 
 type InstrumentPoint struct {
@@ -26,23 +25,32 @@ type Instrument interface {
 	WriteExtraFiles(basePath string) ([]string, error)
 }
 
-func instrument(args []string, opt *compileOptions) ([]string, error) {
+func instrument(args []string, opt *compileOptions, toolOpts *toolexecOptions) ([]string, error) {
+	exports, err := newPackageExports(args, opt, toolOpts)
+	if err != nil {
+		return nil, err
+	}
 	var inst Instrument
 	switch opt.Package {
 	case "runtime":
-		inst = NewRuntimeInstrument()
+		version, err := detectRuntimeGoVersion(opt)
+		if err != nil {
+			return nil, err
+		}
+		runtimeInst, err := NewRuntimeInstrument(version)
+		if err != nil {
+			return nil, err
+		}
+		inst = runtimeInst
 	default:
-		inst = NewFrameworkInstrument()
+		inst = NewFrameworkInstrument(toolOpts.Config.EnabledInstruments(), exports)
 	}
 
 	var buildDir = filepath.Dir(opt.Output)
 
 	// basic filter matched files
 	fileWithInfo := make(map[string]*fileInfo)
-	for inx, path := range args {
-		if !strings.HasSuffix(path, ".go") {
-			continue
-		}
+	for inx, path := range opt.GoFiles {
 		for _, hp := range inst.HookPoints() {
 			if hp.Package != opt.Package {
 				continue
@@ -60,7 +68,7 @@ func instrument(args []string, opt *compileOptions) ([]string, error) {
 			info := fileWithInfo[path]
 			if info == nil {
 				info = &fileInfo{
-					argsIndex: inx,
+					argsIndex: opt.GoFileArgIndex(inx),
 					dstFile:   file,
 				}
 				fileWithInfo[path] = info
@@ -91,6 +99,7 @@ func instrument(args []string, opt *compileOptions) ([]string, error) {
 	}
 
 	// write instrumented files to the build directory
+	rewrittenFiles := make(map[string]string)
 	for updateFileSrc := range instruments {
 		fileInfo := fileWithInfo[updateFileSrc]
 		filename := filepath.Base(updateFileSrc)
@@ -108,6 +117,7 @@ func instrument(args []string, opt *compileOptions) ([]string, error) {
 			return nil, err
 		}
 		args[fileInfo.argsIndex] = dest
+		rewrittenFiles[dest] = updateFileSrc
 	}
 
 	// write extra files if exist
@@ -116,8 +126,23 @@ func instrument(args []string, opt *compileOptions) ([]string, error) {
 		return nil, err
 	}
 	if len(files) > 0 {
+		packageFiles := make([]string, 0, len(opt.GoFiles))
+		for inx := range opt.GoFiles {
+			packageFiles = append(packageFiles, args[opt.GoFileArgIndex(inx)])
+		}
+		if err := checkInjectedConflicts(packageFiles, files); err != nil {
+			return nil, fmt.Errorf("instrument package %s failure: %v", opt.Package, err)
+		}
 		args = append(args, files...)
 	}
+	// the injected files may import the packages which the target package never imports
+	if args, err = addInjectedImports(args, opt, exports, files); err != nil {
+		return nil, err
+	}
+
+	if err := dumpInstrumentedFiles(toolOpts, opt.Package, rewrittenFiles, files); err != nil {
+		return nil, err
+	}
 
 	return args, nil
 }
@@ -127,7 +152,8 @@ func writeFile(file *dst.File, w io.Writer) error {
 	if err != nil {
 		return err
 	}
-	return printer.Fprint(w, fset, af)
+	// same printer config as gofmt, keeps the dumped files and their diff readable
+	return (&printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}).Fprint(w, fset, af)
 }
 
 type fileInfo struct {
@@ -0,0 +1,481 @@
+package toolexec
+
+import (
+	"flag"
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/mrproliu/go-agent-instrumentation/framework/core"
+	"github.com/mrproliu/go-agent-instrumentation/toolexec/internal/testplugin"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in the testdata")
+
+const testTargetPackage = "github.com/mrproliu/go-agent-instrumentation/toolexec/internal/testtarget"
+
+func init() {
+	// same as the plugins imported by the toolexec program
+	core.RegisterInstrument(&testplugin.Instrument{})
+}
+
+// instrumentResult is the compile of the package after instrumenting
+type instrumentResult struct {
+	work  string   // the build directory, the rewritten and generated files are written into it
+	args  []string // the compile args passed to the compiler
+	files []string // the go files in the compile args
+}
+
+// writeImportCfg writes the importcfg with the export data of the packages and their dependencies built by the current toolchain,
+// it contains the packages imported by the core and the test plugin, so the injected files are type checked without building
+func writeImportCfg(t *testing.T, path string, packages ...string) {
+	t.Helper()
+	args := append([]string{"list", "-export", "-deps", "-f", "{{if .Export}}packagefile {{.ImportPath}}={{.Export}}{{end}}"}, packages...)
+	output, err := exec.Command(goCommand(filepath.Join(build.ToolDir, "compile")), args...).Output()
+	if err != nil {
+		t.Fatalf("list the export data of %v failure: %v", packages, err)
+	}
+	if err := os.WriteFile(path, output, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// runInstrument instruments the package by the mock compile args, same as the go command compiles the package.
+// The extra lines are appended into the importcfg, such as the packages of the runtime in other go versions
+func runInstrument(t *testing.T, pkg, goVersion string, goFiles []string, importCfgLines ...string) (*instrumentResult, error) {
+	work := t.TempDir()
+	importCfg := filepath.Join(work, "importcfg")
+	writeImportCfg(t, importCfg, corePackagePath, testTargetPackage)
+	if len(importCfgLines) > 0 {
+		file, err := os.OpenFile(importCfg, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = file.WriteString(strings.Join(importCfgLines, "\n") + "\n")
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	args := []string{filepath.Join(build.ToolDir, "compile"), "-o", filepath.Join(work, "_pkg_.a"), "-trimpath", work + "=>",
+		"-p", pkg, "-lang=go1.19", "-complete", "-buildid", "test/test", "-goversion", goVersion, "-importcfg", importCfg, "-pack"}
+	args = append(args, goFiles...)
+	opt, err := parseCompileOption(args)
+	if err != nil {
+		return nil, err
+	}
+	args, err = instrument(args, opt, &toolexecOptions{Config: &buildConfig{Strict: true}})
+	if err != nil {
+		return nil, err
+	}
+	result := &instrumentResult{work: work, args: args}
+	for _, arg := range args[opt.GoFileArgIndex(0):] {
+		if strings.HasSuffix(arg, ".go") {
+			result.files = append(result.files, arg)
+		}
+	}
+	return result, nil
+}
+
+// checkGolden compares the content with the golden file in the testdata, updates it with the -update flag
+func checkGolden(t *testing.T, name, actual string) {
+	t.Helper()
+	path := filepath.Join("testdata", filepath.FromSlash(name)+".golden")
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(actual), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read the golden file failure, run the tests with -update to create it: %v", err)
+	}
+	if diff := unifiedDiff(path, name, string(expected), actual); diff != "" {
+		t.Errorf("%s is different from the golden file, run the tests with -update if it's expected:\n%s", name, diff)
+	}
+}
+
+// typeCheck checks the compiled files of the package by go/types, the imports are type checked from the sources
+func typeCheck(t *testing.T, pkg string, files []string) *types.Package {
+	t.Helper()
+	fset := token.NewFileSet()
+	parsed := make([]*ast.File, 0, len(files))
+	for _, f := range files {
+		file, err := parser.ParseFile(fset, f, nil, parser.ParseComments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed = append(parsed, file)
+	}
+	errs := make([]string, 0)
+	conf := types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+		Error: func(err error) {
+			errs = append(errs, err.Error())
+		},
+	}
+	checked, _ := conf.Check(pkg, fset, parsed, nil)
+	if len(errs) > 0 {
+		t.Fatalf("type check the instrumented package %s failure:\n%s", pkg, strings.Join(errs, "\n"))
+	}
+	return checked
+}
+
+func TestInstrumentFramework(t *testing.T) {
+	src, err := filepath.Abs(filepath.Join("internal", "testtarget"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	goFiles := []string{filepath.Join(src, "names.go"), filepath.Join(src, "server.go")}
+	result, err := runInstrument(t, testTargetPackage, runtime.Version(), goFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the paths are different in every run, and the go version in every environment
+	normalize := strings.NewReplacer(result.work, "$WORK", src, "$SRC", runtime.Version(), "$GOVERSION").Replace
+	checkGolden(t, "framework/args", normalize(strings.Join(result.args[1:], "\n"))+"\n")
+
+	coreFiles := 0
+	for _, f := range result.files {
+		if filepath.Dir(f) != result.work {
+			continue
+		}
+		content, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := filepath.Base(f)
+		switch original, err := os.ReadFile(filepath.Join(src, name)); {
+		case strings.HasPrefix(name, "sw_core_"):
+			// the copies change with the core, only checked by the type checking
+			coreFiles++
+		case err == nil:
+			checkGolden(t, "framework/"+name+".diff", normalize(unifiedDiff("$SRC/"+name, "$WORK/"+name, string(original), string(content))))
+		default:
+			checkGolden(t, "framework/"+name, string(content))
+		}
+	}
+	coreSources, err := fs.Glob(core.Sources(), "*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests, err := fs.Glob(core.Sources(), "*_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the instrument.go and the tests are not copied
+	if expected := len(coreSources) - len(tests) - 1; coreFiles != expected {
+		t.Errorf("the core files copied: %d, expected: %d", coreFiles, expected)
+	}
+	pkg := typeCheck(t, testTargetPackage, result.files)
+	// the field of the enhanced struct is accessed by the copied core
+	server := types.NewPointer(pkg.Scope().Lookup("Server").Type())
+	enhanced := pkg.Scope().Lookup(coreName("enhancedInstance")).Type().Underlying().(*types.Interface)
+	if !types.Implements(server, enhanced) {
+		t.Errorf("the enhanced struct %s does not implement the %s", server, coreName("enhancedInstance"))
+	}
+}
+
+func TestInstrumentFrameworkUnmatched(t *testing.T) {
+	src, err := filepath.Abs(filepath.Join("internal", "testtarget"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	goFiles := []string{filepath.Join(src, "names.go"), filepath.Join(src, "server.go")}
+	// same files in the package which no plugin enhances
+	result, err := runInstrument(t, "example.com/testtarget", runtime.Version(), goFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(result.files, ",") != strings.Join(goFiles, ",") {
+		t.Errorf("the files of the package not instrumented are changed: %v", result.files)
+	}
+	// only the importcfg of the mock compile is in the build directory
+	if entries, err := os.ReadDir(result.work); err != nil || len(entries) != 1 {
+		t.Errorf("the files are written into the build directory: %v, %v", entries, err)
+	}
+}
+
+func TestInstrumentFrameworkConflict(t *testing.T) {
+	src := t.TempDir()
+	server, err := os.ReadFile(filepath.Join("internal", "testtarget", "server.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the names same as the accessors of the injected field in the package are kept
+	accessors := []byte(`package testtarget
+
+func (s *Server) GetDynamicField() interface{} {
+	return s.name
+}
+
+func (s *Server) SetDynamicField(name interface{}) {
+	s.name = name.(string)
+}
+`)
+	goFiles := []string{filepath.Join(src, "accessors.go"), filepath.Join(src, "server.go")}
+	for inx, content := range [][]byte{accessors, server} {
+		if err := os.WriteFile(goFiles[inx], content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := runInstrument(t, testTargetPackage, runtime.Version(), goFiles)
+	if err != nil {
+		t.Fatalf("the package declared the names of the accessors is not instrumented: %v", err)
+	}
+	typeCheck(t, testTargetPackage, result.files)
+
+	// the names with the prefix of the injected identifiers are reserved
+	conflict := []byte(`package testtarget
+
+func (s *Server) ` + dynamicFieldGetter + `() interface{} {
+	return nil
+}
+`)
+	if err := os.WriteFile(goFiles[0], conflict, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = runInstrument(t, testTargetPackage, runtime.Version(), goFiles)
+	if err == nil || !strings.Contains(err.Error(), "Server."+dynamicFieldGetter) {
+		t.Fatalf("the conflict of the enhanced struct is not detected: %v", err)
+	}
+}
+
+func TestInstrumentRuntime(t *testing.T) {
+	if _, err := NewRuntimeInstrument(runtime.Version()); err != nil {
+		t.Skip(err)
+	}
+	pkg, err := build.Import("runtime", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	goFiles := make([]string, 0, len(pkg.GoFiles))
+	for _, f := range pkg.GoFiles {
+		goFiles = append(goFiles, filepath.Join(pkg.Dir, f))
+	}
+	result, err := runInstrument(t, "runtime", runtime.Version(), goFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	written := make(map[string]string)
+	for _, f := range result.files {
+		if filepath.Dir(f) == result.work {
+			written[filepath.Base(f)] = f
+		}
+	}
+	if len(written) != 3 || written["runtime2.go"] == "" || written["proc.go"] == "" || written["skywalking.go"] == "" {
+		t.Fatalf("the written files of the runtime: %v", written)
+	}
+	// the runtime sources are different in every go version, the rewritten files are checked by the patched code
+	runtime2, err := decorator.ParseFile(nil, written["runtime2.go"], nil, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := ""
+	dst.Inspect(runtime2, func(node dst.Node) bool {
+		if spec, ok := node.(*dst.TypeSpec); ok && spec.Name.Name == "g" {
+			list := spec.Type.(*dst.StructType).Fields.List
+			for _, f := range list[len(list)-5:] {
+				fields += f.Names[0].Name + " "
+			}
+		}
+		return true
+	})
+	if fields != "swtls swparentid swinherited swspawn swspawned " {
+		t.Errorf("the fields appended to the g: %q", fields)
+	}
+	proc, err := os.ReadFile(written["proc.go"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, call := range []string{".swinherited = ", ".swspawned = false", "_skywalking_tls_spawn()", "_skywalking_goroutine_exit()", ".swtls), nil)"} {
+		if !strings.Contains(string(proc), call) {
+			t.Errorf("%s is not injected into the proc.go", call)
+		}
+	}
+	extra, err := os.ReadFile(written["skywalking.go"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "runtime/skywalking.go", string(extra))
+	typeCheck(t, "runtime", result.files)
+}
+
+// TestInstrumentedRuntime runs the tests which need the GLS of the runtime by the toolexec program,
+// they are skipped by the normal "go test"
+func TestInstrumentedRuntime(t *testing.T) {
+	if testing.Short() {
+		t.Skip("build the toolexec program and the tests of the instrumented packages")
+	}
+	if _, err := NewRuntimeInstrument(runtime.Version()); err != nil {
+		t.Skip(err)
+	}
+	goCmd := goCommand(filepath.Join(build.ToolDir, "compile"))
+	toolexec := filepath.Join(t.TempDir(), "toolexec")
+	cmd := exec.Command(goCmd, "build", "-o", toolexec, "./cmd")
+	cmd.Dir = ".."
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("build the toolexec program failure: %v\n%s", err, output)
+	}
+	// the packages in the modules of the repository, the relative directory -> the packages
+	tests := map[string][]string{
+		"frameworks/core": {"."},
+		"agent":           {"./leak"},
+	}
+	for dir, packages := range tests {
+		cmd := exec.Command(goCmd, append([]string{"test", "-count=1", "-race", "-toolexec", toolexec}, packages...)...)
+		cmd.Dir = filepath.Join("..", dir)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Errorf("the tests of %s %v failure: %v\n%s", dir, packages, err, output)
+		}
+	}
+}
+
+func TestInstrumentRuntimeChanged(t *testing.T) {
+	src := t.TempDir()
+	// the newproc1 has different parameters from the patch strategy of the version
+	files := map[string]string{
+		"runtime2.go": "package runtime\n\ntype g struct {\n\tgoid uint64\n}\n",
+		"proc.go":     "package runtime\n\nfunc newproc(fn func()) {}\n\nfunc newproc1(fn func()) *g {\n\treturn nil\n}\n\nfunc goexit1() {}\n\nfunc goexit0(gp *g) {}\n",
+	}
+	goFiles := make([]string, 0, len(files))
+	for name, content := range files {
+		goFiles = append(goFiles, filepath.Join(src, name))
+		if err := os.WriteFile(filepath.Join(src, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := runInstrument(t, "runtime", "go1.21.0", goFiles)
+	if err == nil || !strings.Contains(err.Error(), "cannot patch the newproc1") {
+		t.Fatalf("the changed runtime is not detected: %v", err)
+	}
+}
+
+func TestInstrumentRuntimeNewprocArguments(t *testing.T) {
+	src := t.TempDir()
+	// the runtime of go1.16, the arguments of the go statement follow the siz on the stack of newproc
+	files := map[string]string{
+		"runtime2.go": "package runtime\n\ntype g struct {\n\tgoid int64\n}\n",
+		"proc.go": `package runtime
+
+import "unsafe"
+
+type funcval struct{}
+
+func newproc(siz int32, fn *funcval) {}
+
+func newproc1(fn *funcval, argp unsafe.Pointer, narg int32, callergp *g, callerpc uintptr) *g {
+	return nil
+}
+
+func goexit1() {}
+
+func goexit0(gp *g) {}
+`,
+	}
+	goFiles := make([]string, 0, len(files))
+	for name, content := range files {
+		goFiles = append(goFiles, filepath.Join(src, name))
+		if err := os.WriteFile(filepath.Join(src, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// imported by the runtime of go1.16 and the injected file
+	result, err := runInstrument(t, "runtime", "go1.16.15", goFiles, "packagefile runtime/internal/atomic=atomic.a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range result.files {
+		if filepath.Base(f) != "proc.go" || filepath.Dir(f) != result.work {
+			continue
+		}
+		proc, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// only the go statement without arguments propagates on the parent goroutine
+		if !strings.Contains(string(proc), "func newproc(siz int32, fn *funcval) {\n\tif siz == 0 {\n\t\t_skywalking_tls_spawn()\n\t}\n}") {
+			t.Errorf("the newproc is not patched:\n%s", proc)
+		}
+		return
+	}
+	t.Fatalf("the proc.go is not written: %v", result.files)
+}
+
+func TestNewRuntimeInstrument(t *testing.T) {
+	tests := []struct {
+		version      string
+		paramCount   int
+		atomic       string
+		unsupported  bool
+		unrecognized bool
+	}{
+		{version: "go1.15.15", unsupported: true},
+		{version: "go1.16", paramCount: 5, atomic: "runtime/internal/atomic"},
+		{version: "go1.21.0", paramCount: 3, atomic: "runtime/internal/atomic"},
+		{version: "go1.23rc1", paramCount: 5, atomic: "internal/runtime/atomic"},
+		{version: "go1.99.0", unsupported: true},
+		{version: "devel +abcdef", unrecognized: true},
+	}
+	for _, tt := range tests {
+		inst, err := NewRuntimeInstrument(tt.version)
+		switch {
+		case tt.unrecognized || tt.unsupported:
+			expected := "is not supported"
+			if tt.unrecognized {
+				expected = "cannot recognize"
+			}
+			if err == nil || !strings.Contains(err.Error(), expected) {
+				t.Errorf("%s: expected error %q, actual: %v", tt.version, expected, err)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", tt.version, err)
+		case inst.patch.newprocParamCount != tt.paramCount:
+			t.Errorf("%s: the newproc1 parameters: %d, expected: %d", tt.version, inst.patch.newprocParamCount, tt.paramCount)
+		case inst.patch.atomicPackage != tt.atomic:
+			t.Errorf("%s: the atomic package: %s, expected: %s", tt.version, inst.patch.atomicPackage, tt.atomic)
+		}
+	}
+}
+
+func TestDetectRuntimeGoVersion(t *testing.T) {
+	goRoot := t.TempDir()
+	proc := filepath.Join(goRoot, "src", "runtime", "proc.go")
+	if err := os.MkdirAll(filepath.Dir(proc), 0755); err != nil {
+		t.Fatal(err)
+	}
+	opt := &compileOptions{Lang: "go1.19", GoFiles: []string{proc}}
+	// the language version of the module is not the version of the runtime
+	if version, err := detectRuntimeGoVersion(opt); err == nil {
+		t.Errorf("detected the version %s without the version of the toolchain", version)
+	}
+	if err := os.WriteFile(filepath.Join(goRoot, "VERSION"), []byte("go1.21.3\ntime 2023-10-09T17:04:35Z\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := detectRuntimeGoVersion(opt); err != nil || version != "go1.21.3" {
+		t.Errorf("the version from the GOROOT: %s, %v", version, err)
+	}
+	opt.GoVersion = "go1.22.0"
+	if version, err := detectRuntimeGoVersion(opt); err != nil || version != "go1.22.0" {
+		t.Errorf("the version from the -goversion: %s, %v", version, err)
+	}
+}
@@ -0,0 +1,171 @@
+package toolexec
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+type linkOptions struct {
+	Output    string
+	ImportCfg string
+	Race      bool
+	Msan      bool
+	Asan      bool
+}
+
+// linkFlagsWithValue is the flags of cmd/link which needs a value
+var linkFlagsWithValue = map[string]bool{
+	"B": true, "D": true, "E": true, "H": true, "I": true, "L": true, "R": true, "T": true, "X": true,
+	"benchmark": true, "benchmarkprofile": true, "buildid": true, "buildmode": true, "capturehostobjs": true,
+	"cpuprofile": true, "debugtextsize": true, "debugtramp": true, "extar": true, "extld": true, "extldflags": true,
+	"fipso": true, "funcalign": true, "importcfg": true, "installsuffix": true, "k": true, "libgcc": true,
+	"linkmode": true, "macos": true, "macsdk": true, "memprofile": true, "memprofilerate": true, "o": true,
+	"pluginpath": true, "r": true, "randlayout": true, "strictdups": true, "tmpdir": true,
+}
+
+func parseLinkOption(args []string) *linkOptions {
+	if toolName(args) != "link" {
+		return nil
+	}
+	opt := &linkOptions{}
+	// only the flags before the main package are read, they are given by the go command first,
+	// so the unknown flags are treated as the boolean flags
+	_, _ = parseToolFlags(args, func(name string) (bool, bool) {
+		return linkFlagsWithValue[name], true
+	}, func(name, value string) {
+		switch name {
+		case "o":
+			opt.Output = value
+		case "importcfg":
+			opt.ImportCfg = value
+		case "race":
+			opt.Race = value != "false"
+		case "msan":
+			opt.Msan = value != "false"
+		case "asan":
+			opt.Asan = value != "false"
+		}
+	})
+	return opt
+}
+
+// instrumentLink adds the packages imported by the injected code, and stamps the build metadata into the binary
+func instrumentLink(args []string, opt *linkOptions, toolOpts *toolexecOptions) ([]string, error) {
+	cfg, err := readImportCfg(opt.ImportCfg)
+	if err != nil {
+		return nil, err
+	}
+	injected, err := injectedImports(cfg, toolOpts.Config)
+	if err != nil {
+		return nil, err
+	}
+	if len(injected) > 0 {
+		// the dependencies of the injected packages also need to be linked
+		packageFiles, err := listPackageFiles(args[0], sanitizerBuildFlags(opt.Race, opt.Msan, opt.Asan), toolOpts, injected, true)
+		if err != nil {
+			return nil, err
+		}
+		missing := make([]string, 0)
+		for pkg := range packageFiles {
+			if _, exist := cfg.packageFiles[pkg]; !exist {
+				missing = append(missing, pkg)
+			}
+		}
+		sort.Strings(missing)
+		for _, pkg := range missing {
+			cfg.AddPackageFile(pkg, packageFiles[pkg])
+		}
+		cfgPath := opt.ImportCfg + ".skywalking"
+		if err := cfg.Write(cfgPath); err != nil {
+			return nil, err
+		}
+		args = replaceFlagValue(args, "importcfg", cfgPath)
+	}
+
+	// printed once for every binary, the go command shows it under the package of binary
+	fmt.Fprintf(os.Stderr, "skywalking agent %s, active plugins: %s\n", agentVersion(), toolOpts.Config.ActivePlugins())
+
+	stamps, err := buildStamps(args[0], toolOpts)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]string{args[0]}, stamps...), args[1:]...), nil
+}
+
+// buildStamps returns the -X flags of the build metadata, they are read by core.AgentVersion and core.BuildInfo
+func buildStamps(tool string, toolOpts *toolexecOptions) ([]string, error) {
+	identity, err := toolOpts.Identity()
+	if err != nil {
+		return nil, err
+	}
+	plugins := make([]string, 0)
+	for _, inst := range toolOpts.Config.EnabledInstruments() {
+		plugins = append(plugins, inst.Name())
+	}
+	values := [][2]string{
+		{"skywalkingAgentVersion", agentVersion()},
+		{"skywalkingToolexecIdentity", identity},
+		{"skywalkingGoVersion", toolGoVersion(tool)},
+		{"skywalkingPlugins", strings.Join(plugins, ",")},
+	}
+	stamps := make([]string, 0, len(values)*2)
+	for _, v := range values {
+		stamps = append(stamps, "-X", fmt.Sprintf("runtime.%s=%s", v[0], v[1]))
+	}
+	return stamps, nil
+}
+
+// toolGoVersion returns the go version of the toolchain which the tool belongs to,
+// from the VERSION file of the GOROOT, or the "-V=full" output of the tool, such as "link version go1.21.3"
+func toolGoVersion(tool string) string {
+	if version := goRootVersion(toolGoRoot(tool)); version != "" {
+		return version
+	}
+	output, err := exec.Command(tool, "-V=full").Output()
+	if err != nil {
+		return "unknown"
+	}
+	_, version, found := strings.Cut(strings.TrimSpace(string(output)), " version ")
+	if !found {
+		return "unknown"
+	}
+	return version
+}
+
+// the module of the agent, the custom toolexec program depends on it
+const agentModule = "github.com/mrproliu/go-agent-instrumentation"
+
+// agentVersion is the module version of the toolexec program, the vcs revision is used when built in the source tree.
+// The version of the agent module is used when the custom toolexec program imports it
+func agentVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if info.Main.Path != agentModule {
+		for _, dep := range info.Deps {
+			if dep.Path == agentModule && dep.Version != "" && dep.Version != "(devel)" {
+				return dep.Version
+			}
+		}
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	version := "(devel)"
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			version += "+" + setting.Value
+		case "vcs.modified":
+			if setting.Value == "true" {
+				version += ".dirty"
+			}
+		}
+	}
+	return version
+}
@@ -0,0 +1,93 @@
+package toolexec
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestInstrumentLinkStamps(t *testing.T) {
+	dir := t.TempDir()
+	importCfg := filepath.Join(dir, "importcfg.link")
+	if err := os.WriteFile(importCfg, []byte("packagefile main=main.a\npackagefile runtime=runtime.a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tool := filepath.Join(build.ToolDir, "link")
+	args := []string{tool, "-o", filepath.Join(dir, "a.out"), "-importcfg", importCfg, "-buildmode=exe", "main.a"}
+	opt := parseLinkOption(args)
+	if opt.Output != args[2] || opt.ImportCfg != importCfg {
+		t.Fatalf("the link options: %+v", opt)
+	}
+
+	toolOpts := &toolexecOptions{Config: &buildConfig{}}
+	linkArgs, err := instrumentLink(args, opt, toolOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := toolOpts.Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plugins := make([]string, 0)
+	for _, inst := range toolOpts.Config.EnabledInstruments() {
+		plugins = append(plugins, inst.Name())
+	}
+	// no injected packages in the importcfg, only the stamps are added before the original flags
+	expected := append([]string{tool,
+		"-X", "runtime.skywalkingAgentVersion=" + agentVersion(),
+		"-X", "runtime.skywalkingToolexecIdentity=" + identity,
+		"-X", "runtime.skywalkingGoVersion=" + runtime.Version(),
+		"-X", "runtime.skywalkingPlugins=" + strings.Join(plugins, ","),
+	}, args[1:]...)
+	if !reflect.DeepEqual(linkArgs, expected) {
+		t.Errorf("the link arguments: %q\nexpected: %q", linkArgs, expected)
+	}
+}
+
+func TestToolGoVersion(t *testing.T) {
+	if version := toolGoVersion(filepath.Join(build.ToolDir, "link")); version != runtime.Version() {
+		t.Errorf("the go version of the current toolchain: %s, expected: %s", version, runtime.Version())
+	}
+	// no VERSION file in the GOROOT, the version is read from the tool
+	tool := filepath.Join(t.TempDir(), "pkg", "tool", "linux_amd64", "link")
+	if err := os.MkdirAll(filepath.Dir(tool), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tool, []byte("#!/bin/sh\necho 'link version devel go1.23-abc123 X:none'\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if version := toolGoVersion(tool); version != "devel go1.23-abc123 X:none" {
+		t.Errorf("the go version of the tool: %s", version)
+	}
+	if version := toolGoVersion(filepath.Join(t.TempDir(), "link")); version != "unknown" {
+		t.Errorf("the go version of the missing tool: %s", version)
+	}
+}
+
+func TestMainModuleDir(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "go.mod"), []byte("module example.com/app\n\ngo 1.19\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sub := filepath.Join(root, "internal", "handler")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	// the go command executes the tools in its working directory, which could be any directory of the main module
+	if err := os.Chdir(sub); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOWORK", "off")
+	if dir := mainModuleDir(filepath.Join(build.ToolDir, "link")); dir != root {
+		t.Errorf("the main module dir: %s, expected: %s", dir, root)
+	}
+}
@@ -0,0 +1,159 @@
+package toolexec
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// toolexecOptions are the flags given before the tool path, such as: -toolexec "cmd -debug-dir /tmp/sw"
+type toolexecOptions struct {
+	DebugDir   string
+	DebugDiff  bool
+	ConfigFile string
+
+	Config *buildConfig
+	flags  []string
+}
+
+// Identity is the hash of the toolexec program and its options, the program contains all the instruments
+func (t *toolexecOptions) Identity() (string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Open(executable)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	hash.Write([]byte(strings.Join(t.flags, " ")))
+	// the config file and environment variables change the instrumented code
+	hash.Write([]byte(fmt.Sprintf("%+v", *t.Config)))
+	return hex.EncodeToString(hash.Sum(nil))[:16], nil
+}
+
+// Command returns the toolexec command with the same options, for the nested go command
+func (t *toolexecOptions) Command() (string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	command := make([]string, 0, len(t.flags)+1)
+	for _, arg := range append([]string{executable}, t.flags...) {
+		// same quoting rule as the go command splits the toolexec
+		if strings.ContainsAny(arg, " \t") {
+			quote := "\""
+			if strings.Contains(arg, quote) {
+				quote = "'"
+			}
+			arg = quote + arg + quote
+		}
+		command = append(command, arg)
+	}
+	return strings.Join(command, " "), nil
+}
+
+func parseToolexecOptions(args []string) (*toolexecOptions, []string) {
+	opts := &toolexecOptions{}
+	flags := flag.NewFlagSet("toolexec", flag.ExitOnError)
+	flags.StringVar(&opts.DebugDir, "debug-dir", "", "mirror the instrumented and generated files into the directory")
+	flags.BoolVar(&opts.DebugDiff, "debug-diff", false, "write the unified diff of every instrumented file next to it, requires -debug-dir")
+	flags.StringVar(&opts.ConfigFile, "config", "", "the absolute path of the agent config file, default read from the SW_AGENT_CONFIG")
+	_ = flags.Parse(args)
+	opts.flags = args[:len(args)-flags.NArg()]
+	return opts, flags.Args()
+}
+
+// Main runs the toolexec program, or the "inspect" command when the first argument is "inspect".
+// The instruments registered by core.RegisterInstrument are used, so the custom toolexec program
+// only needs to import the plugin packages and invoke it
+func Main() {
+	if len(os.Args) > 1 && os.Args[1] == "inspect" {
+		if err := inspect(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	toolOpts, args := parseToolexecOptions(os.Args[1:])
+	var err error
+	if toolOpts.Config, err = loadBuildConfig(toolOpts.ConfigFile); err != nil {
+		log.Fatal(err)
+	}
+	switch toolName(args) {
+	case "compile":
+		option, parseErr := parseCompileOption(args)
+		if parseErr != nil && toolOpts.Config.Strict {
+			err = parseErr
+		} else if parseErr != nil {
+			log.Printf("compile without instrument: %v", parseErr)
+		} else if option.Package != "" && option.Output != "" && toolOpts.Config.PackageInstrumented(option.Package) {
+			original := append([]string(nil), args...)
+			args, err = instrument(args, option, toolOpts)
+			if err != nil && !toolOpts.Config.Strict {
+				log.Printf("instrument package %s failure, compile it without instrument: %v", option.Package, err)
+				args, err = original, nil
+			}
+		}
+	case "link":
+		if option := parseLinkOption(args); option.ImportCfg != "" && option.Output != "" {
+			args, err = instrumentLink(args, option, toolOpts)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if isVersionQuery(args) {
+		err = executeVersionQuery(args, toolOpts)
+	} else {
+		err = executeCommand(args)
+	}
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			os.Exit(exitErr.ExitCode())
+		}
+		log.Fatal(err)
+	}
+}
+
+func executeCommand(args []string) error {
+	path := args[0]
+	args = args[1:]
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// isVersionQuery checks the command is "tool -V=full", the go command uses its output as part of the build cache key
+func isVersionQuery(args []string) bool {
+	return len(args) == 2 && args[1] == "-V=full"
+}
+
+// executeVersionQuery appends the identity of the toolexec to the tool version,
+// so the instrumented packages never share the build cache with the normal build
+func executeVersionQuery(args []string, toolOpts *toolexecOptions) error {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return err
+	}
+	identity, err := toolOpts.Identity()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(os.Stdout, "%s+skywalking-%s\n", strings.TrimSpace(string(output)), identity)
+	return err
+}
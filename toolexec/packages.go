@@ -0,0 +1,321 @@
+package toolexec
+
+import (
+	"fmt"
+	"github.com/mrproliu/go-agent-instrumentation/framework/core"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type importCfg struct {
+	lines        []string
+	packageFiles map[string]string
+	importMap    map[string]string // the import path in the source -> the actual package path, such as the vendored packages
+}
+
+func readImportCfg(path string) (*importCfg, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := newImportCfg()
+	for _, line := range strings.Split(strings.TrimRight(string(content), "\n"), "\n") {
+		cfg.lines = append(cfg.lines, line)
+		verb, value, _ := strings.Cut(line, " ")
+		kv := strings.SplitN(value, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch verb {
+		case "packagefile":
+			cfg.packageFiles[kv[0]] = kv[1]
+		case "importmap":
+			cfg.importMap[kv[0]] = kv[1]
+		}
+	}
+	return cfg, nil
+}
+
+func newImportCfg() *importCfg {
+	return &importCfg{packageFiles: make(map[string]string), importMap: make(map[string]string)}
+}
+
+// PackageFile returns the archive file of the imported package
+func (c *importCfg) PackageFile(importPath string) string {
+	if actual, ok := c.importMap[importPath]; ok {
+		importPath = actual
+	}
+	return c.packageFiles[importPath]
+}
+
+func (c *importCfg) AddPackageFile(pkg, file string) {
+	c.packageFiles[pkg] = file
+	c.lines = append(c.lines, fmt.Sprintf("packagefile %s=%s", pkg, file))
+}
+
+func (c *importCfg) Write(path string) error {
+	return os.WriteFile(path, []byte(strings.Join(c.lines, "\n")+"\n"), 0644)
+}
+
+// addInjectedImports makes the packages imported by the injected files could be found by the compiler
+func addInjectedImports(args []string, opt *compileOptions, exports *packageExports, files []string) ([]string, error) {
+	if opt.ImportCfg == "" || len(files) == 0 {
+		return args, nil
+	}
+	imports, err := fileImports(files)
+	if err != nil {
+		return nil, err
+	}
+	missing := make([]string, 0)
+	for _, imp := range imports {
+		if exports.cfg.PackageFile(imp) == "" && imp != opt.Package {
+			missing = append(missing, imp)
+		}
+	}
+	if len(missing) == 0 {
+		return args, nil
+	}
+	if err := exports.Build(missing); err != nil {
+		return nil, fmt.Errorf("build the packages imported by the injected files of %s failure: %v", opt.Package, err)
+	}
+
+	cfg, err := readImportCfg(opt.ImportCfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, pkg := range missing {
+		cfg.AddPackageFile(pkg, exports.File(pkg))
+	}
+	cfgPath := filepath.Join(filepath.Dir(opt.Output), "importcfg.skywalking")
+	if err := cfg.Write(cfgPath); err != nil {
+		return nil, err
+	}
+	return replaceFlagValue(args, "importcfg", cfgPath), nil
+}
+
+// packageExports finds the export data of the packages for the compiling package. The packages imported by it are
+// in the importcfg of the compile, the others are built by the go command, such as the packages imported by the injected files
+type packageExports struct {
+	tool       string
+	buildFlags []string
+	toolOpts   *toolexecOptions
+	cfg        *importCfg
+	built      map[string]string // import path -> the archive file built by the go command
+}
+
+func newPackageExports(args []string, opt *compileOptions, toolOpts *toolexecOptions) (*packageExports, error) {
+	cfg := newImportCfg()
+	if opt.ImportCfg != "" {
+		var err error
+		if cfg, err = readImportCfg(opt.ImportCfg); err != nil {
+			return nil, err
+		}
+	}
+	return &packageExports{tool: args[0], buildFlags: opt.BuildFlags(), toolOpts: toolOpts, cfg: cfg,
+		built: make(map[string]string)}, nil
+}
+
+// Build builds the packages not found by once, the built packages are cached
+func (e *packageExports) Build(packages []string) error {
+	missing := make([]string, 0, len(packages))
+	for _, pkg := range packages {
+		if e.File(pkg) == "" {
+			missing = append(missing, pkg)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	packageFiles, err := listPackageFiles(e.tool, e.buildFlags, e.toolOpts, missing, false)
+	if err != nil {
+		return err
+	}
+	for _, pkg := range missing {
+		if packageFiles[pkg] == "" {
+			return fmt.Errorf("cannot find the package %s", pkg)
+		}
+		e.built[pkg] = packageFiles[pkg]
+	}
+	return nil
+}
+
+// File returns the archive file of the package, it's empty when not found
+func (e *packageExports) File(importPath string) string {
+	if file := e.cfg.PackageFile(importPath); file != "" {
+		return file
+	}
+	return e.built[importPath]
+}
+
+// Open opens the export data of the package for the gc importer, builds the package when not found
+func (e *packageExports) Open(importPath string) (io.ReadCloser, error) {
+	if err := e.Build([]string{importPath}); err != nil {
+		return nil, err
+	}
+	return os.Open(e.File(importPath))
+}
+
+// fileImports returns the imported package paths of the go files, the pseudo packages are excluded
+func fileImports(files []string) ([]string, error) {
+	imports := make(map[string]bool)
+	for _, f := range files {
+		if err := addFileImports(imports, f, nil); err != nil {
+			return nil, err
+		}
+	}
+	return sortedKeys(imports), nil
+}
+
+// addFileImports adds the imported package paths of the file into the set, the src is same as the parser.ParseFile
+func addFileImports(imports map[string]bool, filename string, src interface{}) error {
+	parsed, err := parser.ParseFile(token.NewFileSet(), filename, src, parser.ImportsOnly)
+	if err != nil {
+		return err
+	}
+	for _, spec := range parsed.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			return err
+		}
+		if path == "unsafe" || path == "C" {
+			continue
+		}
+		imports[path] = true
+	}
+	return nil
+}
+
+// injectedImports returns the packages imported by the code injected into the linked packages, the merged packages are excluded.
+// It's derived from the build config rather than recorded by the compile, the instrumented packages may come from the build cache.
+// The runtime injects no import, the core and the plugin files are injected into the packages of the enabled instruments
+func injectedImports(cfg *importCfg, config *buildConfig) ([]string, error) {
+	imports := make(map[string]bool)
+	merged := map[string]bool{corePackagePath: true}
+	coreInjected := false
+	for _, inst := range config.EnabledInstruments() {
+		pluginPath := instrumentPackagePath(inst)
+		for _, point := range inst.Points() {
+			packagePath := filepath.ToSlash(filepath.Join(inst.BasePackage(), point.PackagePath))
+			if _, linked := cfg.packageFiles[packagePath]; !linked || !config.PackageInstrumented(packagePath) {
+				continue
+			}
+			merged[packagePath] = true
+			coreInjected = true
+			paths, err := instrumentFilePaths(inst, point.PackagePath)
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range paths {
+				content, err := fs.ReadFile(inst.FS(), p)
+				if err != nil {
+					return nil, err
+				}
+				if err := addFileImports(imports, p, content); err != nil {
+					return nil, fmt.Errorf("parse the file %s of plugin %s failure: %v", p, inst.Name(), err)
+				}
+			}
+			for imp := range imports {
+				if imp == pluginPath || strings.HasPrefix(imp, pluginPath+"/") {
+					merged[imp] = true
+				}
+			}
+		}
+	}
+	if coreInjected {
+		names, err := coreFilePaths()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			content, err := fs.ReadFile(core.Sources(), name)
+			if err != nil {
+				return nil, err
+			}
+			if err := addFileImports(imports, name, content); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for imp := range merged {
+		delete(imports, imp)
+	}
+	return sortedKeys(imports), nil
+}
+
+// listPackageFiles builds the packages by the go command of the current toolchain, and returns their archive files.
+// The packages are built with the same toolexec and build flags, so they are same with the packages in the current build
+func listPackageFiles(tool string, buildFlags []string, toolOpts *toolexecOptions, packages []string, withDeps bool) (map[string]string, error) {
+	listArgs := []string{"list", "-export", "-f", "{{if .Export}}{{.ImportPath}}={{.Export}}{{end}}"}
+	if withDeps {
+		listArgs = append(listArgs, "-deps")
+	}
+	toolexec, err := toolOpts.Command()
+	if err != nil {
+		return nil, err
+	}
+	listArgs = append(listArgs, "-toolexec", toolexec)
+	listArgs = append(listArgs, buildFlags...)
+	listArgs = append(listArgs, packages...)
+
+	cmd := exec.Command(goCommand(tool), listArgs...)
+	// resolves the packages by the main module, same versions as the current build
+	cmd.Dir = mainModuleDir(tool)
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("build injected packages %v failure: %v", packages, err)
+	}
+	result := make(map[string]string)
+	for _, line := range strings.Split(string(output), "\n") {
+		if kv := strings.SplitN(line, "=", 2); len(kv) == 2 {
+			result[kv[0]] = kv[1]
+		}
+	}
+	return result, nil
+}
+
+// mainModuleDir returns the root directory of the main module. The go command executes the compile and link
+// in its own working directory, so the go.mod found from there is the main module of the current build,
+// even when the compiling package is a dependency in the module cache
+func mainModuleDir(tool string) string {
+	dir, _ := os.Getwd()
+	cmd := exec.Command(goCommand(tool), "env", "GOMOD")
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	// no go.mod in the GOPATH mode, and os.DevNull when the module mode is disabled
+	if gomod := strings.TrimSpace(string(output)); err == nil && gomod != "" && gomod != os.DevNull {
+		return filepath.Dir(gomod)
+	}
+	return dir
+}
+
+// goCommand finds the go command of the toolchain which the tool belongs to
+func goCommand(tool string) string {
+	goBin := filepath.Join(toolGoRoot(tool), "bin", "go")
+	if _, err := os.Stat(goBin); err != nil {
+		return "go"
+	}
+	return goBin
+}
+
+// toolGoRoot returns the GOROOT of the tool, which locates in $GOROOT/pkg/tool/$GOOS_$GOARCH
+func toolGoRoot(tool string) string {
+	return filepath.Dir(filepath.Dir(filepath.Dir(filepath.Dir(tool))))
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	result := make([]string, 0, len(m))
+	for k := range m {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+	return result
+}
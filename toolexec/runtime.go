@@ -0,0 +1,507 @@
+package toolexec
+
+import (
+	"fmt"
+	"github.com/dave/dst"
+	"github.com/dave/dst/dstutil"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// runtimePatch is the patch strategy of the runtime for the go versions
+type runtimePatch struct {
+	minVersion int // minor version of go1.x
+	maxVersion int
+
+	// the parameter count of newproc1, and the index of the parent goroutine parameter
+	newprocParamCount  int
+	newprocParentIndex int
+	// func newproc(siz int32, fn *funcval), the arguments of fn follow it on the stack when the siz is not 0
+	newprocSizeParam bool
+	// the atomic package of the runtime
+	atomicPackage string
+}
+
+var runtimePatches = []*runtimePatch{
+	// func newproc1(fn *funcval, argp unsafe.Pointer, narg int32, callergp *g, callerpc uintptr) *g
+	{minVersion: 16, maxVersion: 17, newprocParamCount: 5, newprocParentIndex: 3, newprocSizeParam: true, atomicPackage: "runtime/internal/atomic"},
+	// func newproc1(fn *funcval, callergp *g, callerpc uintptr) *g
+	{minVersion: 18, maxVersion: 22, newprocParamCount: 3, newprocParentIndex: 1, atomicPackage: "runtime/internal/atomic"},
+	// func newproc1(fn *funcval, callergp *g, callerpc uintptr, parked bool, waitreason waitReason) *g
+	{minVersion: 23, maxVersion: 27, newprocParamCount: 5, newprocParentIndex: 1, atomicPackage: "internal/runtime/atomic"},
+}
+
+type RuntimeInstrument struct {
+	version string
+	patch   *runtimePatch
+	patched map[string]bool
+}
+
+func NewRuntimeInstrument(version string) (*RuntimeInstrument, error) {
+	minor, ok := parseGoMinorVersion(version)
+	if !ok {
+		return nil, fmt.Errorf("cannot recognize the go version %q of the runtime", version)
+	}
+	for _, p := range runtimePatches {
+		if minor >= p.minVersion && minor <= p.maxVersion {
+			return &RuntimeInstrument{version: version, patch: p, patched: make(map[string]bool)}, nil
+		}
+	}
+	return nil, fmt.Errorf("the runtime of %s is not supported, supported versions: go1.%d - go1.%d",
+		version, runtimePatches[0].minVersion, runtimePatches[len(runtimePatches)-1].maxVersion)
+}
+
+func (r *RuntimeInstrument) HookPoints() []*InstrumentPoint {
+	return []*InstrumentPoint{
+		{
+			Package: "runtime",
+			File:    "runtime2.go",
+			FilterAndEdit: func(cursor *dstutil.Cursor) bool {
+				switch n := cursor.Node().(type) {
+				case *dst.TypeSpec:
+					// append tls into goroutine
+					if n.Name != nil && n.Name.Name != "g" {
+						return false
+					}
+					st, ok := n.Type.(*dst.StructType)
+					if !ok {
+						return false
+					}
+					st.Fields.List = append(st.Fields.List, &dst.Field{
+						// the pointer of the tls value, stored atomically, the other goroutines could read it
+						Names: []*dst.Ident{dst.NewIdent("swtls")},
+						Type:  dst.NewIdent("unsafe.Pointer"),
+					}, &dst.Field{
+						Names: []*dst.Ident{dst.NewIdent("swparentid")}, // the goid of the goroutine which spawned it
+						Type:  dst.NewIdent("int64"),
+					}, &dst.Field{
+						Names: []*dst.Ident{dst.NewIdent("swinherited")}, // the swtls is the parent's, not propagated yet
+						Type:  dst.NewIdent("bool"),
+					}, &dst.Field{
+						Names: []*dst.Ident{dst.NewIdent("swspawn")}, // the tls pointer propagated for the goroutine spawning by newproc
+						Type:  dst.NewIdent("unsafe.Pointer"),
+					}, &dst.Field{
+						Names: []*dst.Ident{dst.NewIdent("swspawned")},
+						Type:  dst.NewIdent("bool"),
+					})
+					r.patched["g"] = true
+					return true
+				}
+				return false
+			},
+		},
+		{
+			Package: "runtime",
+			File:    "proc.go",
+			FilterAndEdit: func(cursor *dstutil.Cursor) bool {
+				switch n := cursor.Node().(type) {
+				case *dst.FuncDecl:
+					if n.Name.Name != "newproc1" || n.Recv != nil {
+						return false
+					}
+					if n.Type.Results == nil || len(n.Type.Results.List) != 1 || !r.isNewprocMatched(n.Type.Params) {
+						return false
+					}
+
+					parameterNames := enhanceParameterNames(n.Type.Params)
+					// enhance the result names
+					resultNames := enhanceParameterNames(n.Type.Results)
+					parent := parameterNames[r.patch.newprocParentIndex].Name
+					// running on the system stack, moves the tls propagated by newproc into the new goroutine.
+					// Otherwise(such as the coroutines), copies the tls of the parent, the new goroutine propagates it
+					n.Body.List = append(goStringToStmts(fmt.Sprintf(`defer func() {
+	if %[1]s != nil && %[2]s != nil {
+		%[1]s.swparentid = int64(%[2]s.goid)
+		if %[2]s.swspawned {
+			atomicstorep(unsafe.Pointer(&%[1]s.swtls), %[2]s.swspawn)
+			%[1]s.swinherited = false
+			%[2]s.swspawn = nil
+			%[2]s.swspawned = false
+		} else {
+			atomicstorep(unsafe.Pointer(&%[1]s.swtls), %[2]s.swtls)
+			%[1]s.swinherited = %[2]s.swtls != nil
+		}
+	}
+}()`, resultNames[0].Name, parent), false), n.Body.List...)
+					r.patched["newproc1"] = true
+					return true
+				}
+
+				return false
+			},
+		},
+		{
+			Package: "runtime",
+			File:    "proc.go",
+			FilterAndEdit: func(cursor *dstutil.Cursor) bool {
+				switch n := cursor.Node().(type) {
+				case *dst.FuncDecl:
+					if n.Recv != nil {
+						return false
+					}
+					switch n.Name.Name {
+					case "newproc":
+						// still running on the parent goroutine, the propagators run before switching to the system stack
+						spawn := "_skywalking_tls_spawn()"
+						if r.patch.newprocSizeParam {
+							parameterNames := enhanceParameterNames(n.Type.Params)
+							if len(parameterNames) != 2 {
+								return false
+							}
+							// the arguments of the go statement follow the siz on the stack without the pointer map,
+							// calling the functions which could grow the stack or run the GC is unsafe,
+							// so the new goroutine propagates the tls by itself
+							spawn = fmt.Sprintf("if %s == 0 {\n\t_skywalking_tls_spawn()\n}", parameterNames[0].Name)
+						} else if n.Type.Params == nil || len(n.Type.Params.List) != 1 {
+							return false
+						}
+						n.Body.List = append(goStringToStmts(spawn, false), n.Body.List...)
+						r.patched["newproc"] = true
+						return true
+					case "goexit1":
+						// still running on the exiting goroutine, the listeners could access its context
+						n.Body.List = append(goStringToStmts(`_skywalking_goroutine_exit()`, false), n.Body.List...)
+						r.patched["goexit1"] = true
+						return true
+					case "goexit0":
+						// the goroutine would be put into the free list for reuse, clear the tls and lineage
+						parameterNames := enhanceParameterNames(n.Type.Params)
+						if len(parameterNames) != 1 {
+							return false
+						}
+						n.Body.List = append(goStringToStmts(fmt.Sprintf("atomicstorep(unsafe.Pointer(&%[1]s.swtls), nil)\n%[1]s.swparentid = 0\n%[1]s.swinherited = false\n%[1]s.swspawn = nil\n%[1]s.swspawned = false",
+							parameterNames[0].Name), false), n.Body.List...)
+						r.patched["goexit0"] = true
+						return true
+					}
+				}
+				return false
+			},
+		},
+	}
+}
+
+// isNewprocMatched checks the parameters of newproc1 are same as the patch strategy, the parent goroutine must be "*g"
+func (r *RuntimeInstrument) isNewprocMatched(params *dst.FieldList) bool {
+	if params == nil || len(params.List) != r.patch.newprocParamCount {
+		return false
+	}
+	for _, f := range params.List {
+		if len(f.Names) > 1 {
+			return false
+		}
+	}
+	star, ok := params.List[r.patch.newprocParentIndex].Type.(*dst.StarExpr)
+	if !ok {
+		return false
+	}
+	ident, ok := star.X.(*dst.Ident)
+	return ok && ident.Name == "g"
+}
+
+// detectRuntimeGoVersion finds the go version of the runtime which is compiling,
+// from the compile arguments, runtime/internal/sys/zversion.go(before go1.17) or the VERSION file of the GOROOT.
+// The -lang is the language version of the module, not the runtime, so it's never used
+func detectRuntimeGoVersion(opt *compileOptions) (string, error) {
+	if opt.GoVersion != "" {
+		return opt.GoVersion, nil
+	}
+	for _, f := range opt.GoFiles {
+		if filepath.Base(f) != "proc.go" {
+			continue
+		}
+		runtimeDir := filepath.Dir(f)
+		if content, err := os.ReadFile(filepath.Join(runtimeDir, "internal", "sys", "zversion.go")); err == nil {
+			if matches := regexp.MustCompile("const TheVersion = `(.+)`").FindSubmatch(content); len(matches) == 2 {
+				return string(matches[1]), nil
+			}
+		}
+		if version := goRootVersion(filepath.Dir(filepath.Dir(runtimeDir))); version != "" {
+			return version, nil
+		}
+	}
+	return "", fmt.Errorf("cannot detect the go version of the runtime, no -goversion flag, zversion.go or VERSION file of the GOROOT")
+}
+
+// goRootVersion reads the go version from the VERSION file of the GOROOT, the first line is the version
+func goRootVersion(goRoot string) string {
+	content, err := os.ReadFile(filepath.Join(goRoot, "VERSION"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.SplitN(string(content), "\n", 2)[0])
+}
+
+// parseGoMinorVersion parses the minor version from the go version, such as go1.21.3, go1.22rc1 or devel go1.23-xxx
+func parseGoMinorVersion(version string) (int, bool) {
+	matches := regexp.MustCompile(`go1\.(\d+)`).FindStringSubmatch(version)
+	if len(matches) != 2 {
+		return 0, false
+	}
+	minor, err := strconv.Atoi(matches[1])
+	return minor, err == nil
+}
+
+func (r *RuntimeInstrument) ExtraChangesForEnhancedFile(filepath string) error {
+	return nil
+}
+
+func (r *RuntimeInstrument) WriteExtraFiles(basePath string) ([]string, error) {
+	// refuse to build when the runtime is different from the patch strategy, otherwise the goroutine context is lost silently
+	for _, name := range []string{"g", "newproc", "newproc1", "goexit1", "goexit0"} {
+		if !r.patched[name] {
+			return nil, fmt.Errorf("cannot patch the %s of the runtime in %s, the runtime is changed in this version", name, r.version)
+		}
+	}
+	//if p1, p2, inv, keep := _sw_write_extra_file(&r, &basePath); !keep {
+	//	return p1, p2
+	//} else {
+	//	defer _sw_write_extra_file_ret(inv, r1, r2)
+	//}
+	tlsExt := filepath.Join(basePath, "skywalking.go")
+	if err := ioutil.WriteFile(tlsExt, []byte(`package runtime
+
+import (
+	"`+r.patch.atomicPackage+`"
+	"unsafe"
+)
+
+//go:linkname _skywalking_tls_get _skywalking_tls_get
+var _skywalking_tls_get = _skywalking_tls_get_impl
+
+//go:linkname _skywalking_tls_set _skywalking_tls_set
+var _skywalking_tls_set = _skywalking_tls_set_impl
+
+//go:linkname _skywalking_agent_version _skywalking_agent_version
+var _skywalking_agent_version = _skywalking_agent_version_impl
+
+//go:linkname _skywalking_build_info _skywalking_build_info
+var _skywalking_build_info = _skywalking_build_info_impl
+
+// stamped by the toolexec when linking
+var (
+	skywalkingAgentVersion     string
+	skywalkingToolexecIdentity string
+	skywalkingGoVersion        string
+	skywalkingPlugins          string
+)
+
+func _skywalking_agent_version_impl() string {
+	return skywalkingAgentVersion
+}
+
+func _skywalking_build_info_impl() map[string]string {
+	return map[string]string{
+		"agent.version":     skywalkingAgentVersion,
+		"toolexec.identity": skywalkingToolexecIdentity,
+		"go.version":        skywalkingGoVersion,
+		"plugins":           skywalkingPlugins,
+	}
+}
+
+//go:linkname _skywalking_tls_propagate_set _skywalking_tls_propagate_set
+var _skywalking_tls_propagate_set = _skywalking_tls_propagate_set_impl
+
+// builds the tls of the new goroutine from the tls and the goid of its parent,
+// share the same value when no propagator
+var _skywalking_tls_propagator func(interface{}, int64) interface{}
+
+func _skywalking_tls_propagate_set_impl(p func(interface{}, int64) interface{}) {
+	_skywalking_tls_propagator = p
+}
+
+// the tls is saved in the goroutine as the pointer of the value, the value is never changed after boxed,
+// so the other goroutines could read it by loading the pointer atomically
+func _skywalking_tls_box(v interface{}) unsafe.Pointer {
+	if v == nil {
+		return nil
+	}
+	box := new(interface{})
+	*box = v
+	return unsafe.Pointer(box)
+}
+
+func _skywalking_tls_unbox(p unsafe.Pointer) interface{} {
+	if p == nil {
+		return nil
+	}
+	return *(*interface{})(p)
+}
+
+// only the goroutine itself changes its tls, newproc1 and goexit0 change it when the goroutine is not running
+func _skywalking_tls_store(gp *g, v interface{}) {
+	_skywalking_tls_release()
+	atomicstorep(unsafe.Pointer(&gp.swtls), _skywalking_tls_box(v))
+}
+
+// the race detector never sees the atomic operations of the runtime, so the values published to the tls
+// are synchronized explicitly with the readers of the other goroutines
+var _skywalking_tls_race uintptr
+
+func _skywalking_tls_release() {
+	if raceenabled {
+		racereleasemerge(unsafe.Pointer(&_skywalking_tls_race))
+	}
+}
+
+func _skywalking_tls_acquire() {
+	if raceenabled {
+		raceacquire(unsafe.Pointer(&_skywalking_tls_race))
+	}
+}
+
+// propagates the tls of the current goroutine for the goroutine it's spawning, invoked by newproc on the parent goroutine
+// before switching to the system stack, so the new goroutine gets the values at the time of spawning.
+// The result is moved into the new goroutine by newproc1
+func _skywalking_tls_spawn() {
+	gp := getg()
+	if gp != gp.m.curg {
+		return
+	}
+	if gp.swinherited {
+		_skywalking_tls_propagate(gp)
+	}
+	if gp.swtls == nil {
+		return
+	}
+	spawn := gp.swtls
+	if _skywalking_tls_propagator != nil {
+		spawn = _skywalking_tls_box(_skywalking_tls_propagator(_skywalking_tls_unbox(gp.swtls), int64(gp.goid)))
+		_skywalking_tls_release()
+	}
+	gp.swspawn = spawn
+	gp.swspawned = true
+}
+
+// propagates the tls inherited from the parent, invoked on the new goroutine when it first accesses the tls or exits.
+// Only the goroutines not spawned by newproc(such as the coroutines) or spawned with the arguments on the stack(before go1.18)
+// inherit the tls
+func _skywalking_tls_propagate(gp *g) {
+	gp.swinherited = false
+	if gp.swtls != nil && _skywalking_tls_propagator != nil {
+		_skywalking_tls_store(gp, _skywalking_tls_propagator(_skywalking_tls_unbox(gp.swtls), gp.swparentid))
+	}
+}
+
+//go:linkname _skywalking_goroutine_exit_listen _skywalking_goroutine_exit_listen
+var _skywalking_goroutine_exit_listen = _skywalking_goroutine_exit_listen_impl
+
+var _skywalking_goroutine_exit_lock mutex
+var _skywalking_goroutine_exit_listeners []func(interface{})
+
+func _skywalking_goroutine_exit_listen_impl(listener func(interface{})) {
+	lock(&_skywalking_goroutine_exit_lock)
+	listeners := make([]func(interface{}), 0, len(_skywalking_goroutine_exit_listeners)+1)
+	listeners = append(listeners, _skywalking_goroutine_exit_listeners...)
+	_skywalking_goroutine_exit_listeners = append(listeners, listener)
+	unlock(&_skywalking_goroutine_exit_lock)
+}
+
+// invoked in goexit1, only the goroutine with tls notifies the listeners
+func _skywalking_goroutine_exit() {
+	gp := getg()
+	if gp.swinherited {
+		_skywalking_tls_propagate(gp)
+	}
+	tls := _skywalking_tls_unbox(gp.swtls)
+	if tls == nil {
+		return
+	}
+	// the slice is replaced rather than changed, so only the header needs to be read under the lock
+	lock(&_skywalking_goroutine_exit_lock)
+	listeners := _skywalking_goroutine_exit_listeners
+	unlock(&_skywalking_goroutine_exit_lock)
+	for _, listener := range listeners {
+		listener(tls)
+	}
+}
+
+//go:linkname _skywalking_goroutines_tls _skywalking_goroutines_tls
+var _skywalking_goroutines_tls = _skywalking_goroutines_tls_impl
+
+// the tls of the alive goroutines, goid -> tls, the goroutine not propagated yet has the tls of its parent.
+// The goroutines are not stopped, the pointer of the tls is loaded atomically, and the value in it is never changed
+func _skywalking_goroutines_tls_impl() map[int64]interface{} {
+	lock(&allglock)
+	gs := allgs[:len(allgs):len(allgs)]
+	unlock(&allglock)
+	result := make(map[int64]interface{})
+	for _, gp := range gs {
+		if readgstatus(gp) == _Gdead {
+			continue
+		}
+		if tls := _skywalking_tls_unbox(atomic.Loadp(unsafe.Pointer(&gp.swtls))); tls != nil {
+			result[int64(gp.goid)] = tls
+		}
+	}
+	// every value loaded is released before storing
+	_skywalking_tls_acquire()
+	return result
+}
+
+//go:linkname _skywalking_global_get _skywalking_global_get
+var _skywalking_global_get = _skywalking_global_get_impl
+
+//go:linkname _skywalking_global_set _skywalking_global_set
+var _skywalking_global_set = _skywalking_global_set_impl
+
+// the process wide values shared by the copies of the core, such as the reporter
+var _skywalking_global_lock mutex
+var _skywalking_globals map[string]interface{}
+
+func _skywalking_global_get_impl(name string) interface{} {
+	return _skywalking_globals[name]
+}
+
+// copy on write, so the readers never lock
+func _skywalking_global_set_impl(name string, v interface{}) {
+	lock(&_skywalking_global_lock)
+	globals := make(map[string]interface{}, len(_skywalking_globals)+1)
+	for k, val := range _skywalking_globals {
+		globals[k] = val
+	}
+	globals[name] = v
+	_skywalking_globals = globals
+	unlock(&_skywalking_global_lock)
+}
+
+//go:linkname _skywalking_goroutine_id _skywalking_goroutine_id
+var _skywalking_goroutine_id = _skywalking_goroutine_id_impl
+
+//go:linkname _skywalking_goroutine_parent_id _skywalking_goroutine_parent_id
+var _skywalking_goroutine_parent_id = _skywalking_goroutine_parent_id_impl
+
+// the user goroutine, the new goroutine is the current goroutine when invoked by the propagator
+//go:nosplit
+func _skywalking_goroutine_id_impl() int64 {
+	return int64(getg().m.curg.goid)
+}
+
+//go:nosplit
+func _skywalking_goroutine_parent_id_impl() int64 {
+	return getg().m.curg.swparentid
+}
+
+func _skywalking_tls_get_impl() interface{} {
+	gp := getg().m.curg
+	if gp.swinherited {
+		_skywalking_tls_propagate(gp)
+	}
+	return _skywalking_tls_unbox(gp.swtls)
+}
+
+// the inherited tls is replaced without propagating
+func _skywalking_tls_set_impl(v interface{}) {
+	gp := getg().m.curg
+	gp.swinherited = false
+	_skywalking_tls_store(gp, v)
+}
+`), 0644); err != nil {
+		return nil, err
+	}
+	return []string{tlsExt}, nil
+}
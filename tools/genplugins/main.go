@@ -0,0 +1,119 @@
+// genplugins discovers the plugins in the frameworks directory, every directory with the instrument.go is a plugin module,
+// then generates the cmd/plugins.go which imports them, and adds their replace directives into the go.mod.
+// Run it by "go generate ./cmd" in the repository root after adding a plugin
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// the version of the modules replaced by the local directories
+const localVersion = "v0.0.0-00010101000000-000000000000"
+
+var modulePattern = regexp.MustCompile(`(?m)^module\s+(\S+)`)
+
+func main() {
+	root, err := findRoot()
+	if err != nil {
+		log.Fatal(err)
+	}
+	plugins, err := discoverPlugins(root)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := writeImports(filepath.Join(root, "cmd", "plugins.go"), plugins); err != nil {
+		log.Fatal(err)
+	}
+	for _, dir := range sortedKeys(plugins) {
+		module := plugins[dir]
+		cmd := exec.Command("go", "mod", "edit",
+			"-require="+module+"@"+localVersion, "-replace="+module+"=./"+filepath.ToSlash(dir))
+		cmd.Dir, cmd.Stdout, cmd.Stderr = root, os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			log.Fatalf("add the plugin %s into go.mod failure: %v", module, err)
+		}
+	}
+}
+
+// findRoot returns the directory of the go.mod of the agent, from the working directory upwards
+func findRoot() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "frameworks", "core", "instrument.go")); err == nil {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("the repository root is not found")
+		}
+		dir = parent
+	}
+}
+
+// discoverPlugins returns the plugin modules, relative directory -> module path
+func discoverPlugins(root string) (map[string]string, error) {
+	matches, err := filepath.Glob(filepath.Join(root, "frameworks", "*", "instrument.go"))
+	if err != nil {
+		return nil, err
+	}
+	plugins := make(map[string]string)
+	for _, match := range matches {
+		dir := filepath.Dir(match)
+		if filepath.Base(dir) == "core" {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+		if err != nil {
+			return nil, fmt.Errorf("the plugin %s should be a module: %v", dir, err)
+		}
+		module := modulePattern.FindSubmatch(content)
+		if module == nil {
+			return nil, fmt.Errorf("no module declared in %s/go.mod", dir)
+		}
+		rel, err := filepath.Rel(root, dir)
+		if err != nil {
+			return nil, err
+		}
+		plugins[rel] = string(module[1])
+	}
+	return plugins, nil
+}
+
+func writeImports(path string, plugins map[string]string) error {
+	var buffer bytes.Buffer
+	buffer.WriteString("// Code generated by tools/genplugins; DO NOT EDIT.\n\npackage main\n\nimport (\n")
+	modules := make([]string, 0, len(plugins))
+	for _, module := range plugins {
+		modules = append(modules, module)
+	}
+	sort.Strings(modules)
+	for _, module := range modules {
+		fmt.Fprintf(&buffer, "\t_ %q\n", module)
+	}
+	buffer.WriteString(")\n")
+	content, err := format.Source(buffer.Bytes())
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0644)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}